@@ -0,0 +1,239 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// blockedMessageRegexp match the status codes in the error message, but not the digits in the illust id
+var blockedMessageRegexp = regexp.MustCompile(`(^|[^0-9])(401|403|429)([^0-9]|$)|Unauthorized|Forbidden|Too Many Requests`)
+
+// maxBenchShift limit the bench duration to 8 times of 'account-bench-sec'
+const maxBenchShift = 3
+
+// loggedOutWait is the interval to check again if all the accounts are logged out
+const loggedOutWait = 30 * time.Second
+
+// PixivAccount is a credential to request pixiv, the empty user agent and proxy use the global options
+type PixivAccount struct {
+	Name      string `mapstructure:"name"`
+	Cookie    string `mapstructure:"cookie"`
+	UserAgent string `mapstructure:"user-agent"`
+	Proxy     string `mapstructure:"proxy"`
+}
+
+// PixivAccounts return the 'accounts' in options, or the account of 'cookie', 'user-agent' and 'proxy' if not set
+func PixivAccounts(options *PixivDlOptions) []PixivAccount {
+	if len(options.Accounts) == 0 {
+		return []PixivAccount{{
+			Name:      "default",
+			Cookie:    options.Cookie,
+			UserAgent: options.UserAgent,
+			Proxy:     options.Proxy,
+		}}
+	}
+
+	accounts := make([]PixivAccount, 0, len(options.Accounts))
+	for idx, account := range options.Accounts {
+		if len(account.Name) == 0 {
+			account.Name = fmt.Sprintf("account-%d", idx)
+		}
+		if len(account.UserAgent) == 0 {
+			account.UserAgent = options.UserAgent
+		}
+		if len(account.Proxy) == 0 {
+			account.Proxy = options.Proxy
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// NewPixivClient create the pixiv api client with the account
+func NewPixivClient(account *PixivAccount, timeout int32) *pixiv.PixivClient {
+	var client *pixiv.PixivClient
+	if len(account.Proxy) > 0 {
+		proxy, _ := url.Parse(account.Proxy)
+		client = pixiv.NewPixivClientWithProxy(proxy, timeout)
+	} else {
+		client = pixiv.NewPixivClient(timeout)
+	}
+	if len(account.Cookie) > 0 {
+		cookieKV := strings.Split(account.Cookie, "=")
+		if len(cookieKV) == 2 {
+			client.AddCookie(cookieKV[0], cookieKV[1])
+		} else {
+			client.SetCookiePHPSESSID(account.Cookie)
+		}
+	}
+	if len(account.UserAgent) > 0 {
+		client.SetUserAgent(account.UserAgent)
+	}
+	return client
+}
+
+type accountState struct {
+	account      PixivAccount
+	benchedUntil time.Time
+	failures     int  // the consecutive failures caused by the account
+	loggedOut    bool // the cookie is expired, see AuthWatchdog
+}
+
+// AccountPool is shared by all the workers to rotate through the accounts. An account is benched for
+// 'account-bench-sec' if pixiv responds 401/403/429 to it, and the duration doubles if it fails again.
+type AccountPool struct {
+	mu            sync.Mutex
+	accounts      []*accountState
+	next          int
+	benchDuration time.Duration
+}
+
+var (
+	sharedPool     *AccountPool
+	sharedPoolOnce sync.Once
+)
+
+// SharedAccountPool return the account pool shared by all the workers in this process
+func SharedAccountPool(options *PixivDlOptions) *AccountPool {
+	sharedPoolOnce.Do(func() {
+		sharedPool = NewAccountPool(options)
+	})
+	return sharedPool
+}
+
+func NewAccountPool(options *PixivDlOptions) *AccountPool {
+	pool := &AccountPool{benchDuration: time.Duration(options.AccountBenchSec) * time.Second}
+	for _, account := range PixivAccounts(options) {
+		pool.accounts = append(pool.accounts, &accountState{account: account})
+	}
+	return pool
+}
+
+// Accounts return the accounts in the pool, the index is used in Acquire and Report
+func (p *AccountPool) Accounts() []PixivAccount {
+	accounts := make([]PixivAccount, 0, len(p.accounts))
+	for _, state := range p.accounts {
+		accounts = append(accounts, state.account)
+	}
+	return accounts
+}
+
+// Acquire return the index of the next available account in round robin, it blocks until any account is
+// available if all of them are benched or logged out
+func (p *AccountPool) Acquire(ctx context.Context) (int, error) {
+	for {
+		idx, wait, loggedOut := p.pick()
+		if wait <= 0 {
+			return idx, nil
+		}
+		if loggedOut {
+			// the AuthWatchdog logs it
+			log.Debugf("[AccountPool] All the accounts are logged out, wait %s", wait)
+		} else {
+			log.Warningf("[AccountPool] All the accounts are benched, wait %s", wait)
+		}
+		if !SleepContext(ctx, wait) {
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// pick return the next available account, or the time to wait for the first account coming back, loggedOut is
+// true if all the accounts are logged out
+func (p *AccountPool) pick() (idx int, wait time.Duration, loggedOut bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(p.accounts); i++ {
+		idx := (p.next + i) % len(p.accounts)
+		if p.accounts[idx].loggedOut {
+			continue
+		}
+		remain := p.accounts[idx].benchedUntil.Sub(now)
+		if remain <= 0 {
+			p.next = idx + 1
+			return idx, 0, false
+		}
+		if wait == 0 || remain < wait {
+			wait = remain
+		}
+	}
+	if wait == 0 {
+		return 0, loggedOutWait, true
+	}
+	return 0, wait, false
+}
+
+// SetLoggedOut stop using the account until it's logged in again, return false if not changed
+func (p *AccountPool) SetLoggedOut(idx int, loggedOut bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.accounts[idx].loggedOut == loggedOut {
+		return false
+	}
+	p.accounts[idx].loggedOut = loggedOut
+	return true
+}
+
+// Report the result of a request with the account, bench the account if it's forbidden or rate limited
+func (p *AccountPool) Report(idx int, err error) {
+	if err == nil {
+		p.mu.Lock()
+		p.accounts[idx].failures = 0
+		p.mu.Unlock()
+		return
+	}
+	// there is no other account to continue with, the retry backoff is enough
+	if len(p.accounts) < 2 || !isAccountBlocked(err) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := p.accounts[idx]
+	if time.Now().Before(state.benchedUntil) {
+		// the in-flight requests fail together
+		return
+	}
+	shift := state.failures
+	if shift > maxBenchShift {
+		shift = maxBenchShift
+	}
+	state.failures++
+	duration := p.benchDuration << shift
+	state.benchedUntil = time.Now().Add(duration)
+	accountBenchedCnt.WithLabelValues(state.account.Name).Inc()
+	log.Warningf("[AccountPool] Bench account '%s' for %s, msg: %s", state.account.Name, duration, err)
+}
+
+// HttpStatusError is the unexpected HTTP status code responded by pixiv
+type HttpStatusError struct {
+	Code int
+	Url  string
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d, url: %s", e.Code, e.Url)
+}
+
+// isAccountBlocked return true if the account is forbidden or rate limited. pixiv.PixivClient does not expose
+// the status code, so its error message is checked.
+func isAccountBlocked(err error) bool {
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden ||
+			statusErr.Code == http.StatusTooManyRequests
+	}
+	return blockedMessageRegexp.MatchString(err.Error())
+}
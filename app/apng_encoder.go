@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// apngEncoder write an animated PNG frame by frame, every frame is encoded as 8-bit RGBA
+// See https://wiki.mozilla.org/APNG_Specification
+type apngEncoder struct {
+	w          io.Writer
+	frameCount int
+	width      int
+	height     int
+	seq        uint32
+	frames     int
+	err        error
+}
+
+func newApngEncoder(w io.Writer, frameCount int) *apngEncoder {
+	return &apngEncoder{w: w, frameCount: frameCount}
+}
+
+func (e *apngEncoder) writeChunk(chunkType string, data []byte) {
+	if e.err != nil {
+		return
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], chunkType)
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(header[4:])
+	_, _ = crc.Write(data)
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+
+	for _, b := range [][]byte{header, data, footer} {
+		if _, e.err = e.w.Write(b); e.err != nil {
+			return
+		}
+	}
+}
+
+func (e *apngEncoder) writeHeader(width, height int) {
+	e.width, e.height = width, height
+	if _, e.err = e.w.Write([]byte("\x89PNG\r\n\x1a\n")); e.err != nil {
+		return
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // color type RGBA
+	e.writeChunk("IHDR", ihdr)
+
+	actl := make([]byte, 8) // num_plays 0 means loop forever
+	binary.BigEndian.PutUint32(actl, uint32(e.frameCount))
+	e.writeChunk("acTL", actl)
+}
+
+// compressImage filter every scanline with the Sub filter and compress them with zlib
+func compressImage(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	cur := make([]byte, width*4)
+	line := make([]byte, 1+width*4)
+	line[0] = 1 // Sub filter
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, y)).(color.NRGBA)
+			cur[x*4], cur[x*4+1], cur[x*4+2], cur[x*4+3] = c.R, c.G, c.B, c.A
+		}
+		for i := range cur {
+			if i < 4 {
+				line[1+i] = cur[i]
+			} else {
+				line[1+i] = cur[i] - cur[i-4]
+			}
+		}
+		if _, err := zw.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// AddFrame encode the image as a frame of the animation, delay in milliseconds
+func (e *apngEncoder) AddFrame(img image.Image, delay int) error {
+	if e.err != nil {
+		return e.err
+	}
+	bounds := img.Bounds()
+	if e.frames == 0 {
+		e.writeHeader(bounds.Dx(), bounds.Dy())
+	}
+	if e.frames >= e.frameCount {
+		return errors.New("apng: too many frames")
+	}
+
+	delayNum, delayDen, err := apngDelay(delay)
+	if err != nil {
+		return err
+	}
+
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], e.seq)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(bounds.Dy()))
+	binary.BigEndian.PutUint16(fctl[20:], delayNum)
+	binary.BigEndian.PutUint16(fctl[22:], delayDen)
+	e.writeChunk("fcTL", fctl)
+	e.seq++
+
+	data, err := compressImage(img)
+	if err != nil {
+		return err
+	}
+	if e.frames == 0 {
+		e.writeChunk("IDAT", data)
+	} else {
+		fdat := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(fdat, e.seq)
+		copy(fdat[4:], data)
+		e.writeChunk("fdAT", fdat)
+		e.seq++
+	}
+	e.frames++
+	return e.err
+}
+
+// apngDelay return the delay fraction in seconds of the fcTL chunk, the numerator and denominator are both 16 bits so
+// that the long delay is scaled down to a smaller denominator with less precision
+func apngDelay(delay int) (uint16, uint16, error) {
+	if delay < 0 {
+		return 0, 0, fmt.Errorf("apng: invalid delay %d", delay)
+	}
+	for _, den := range []int{1000, 100, 10, 1} {
+		num := (delay*den + 500) / 1000
+		if num <= math.MaxUint16 {
+			return uint16(num), uint16(den), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("apng: delay too long %d", delay)
+}
+
+// Close write the end of the png, it doesn't close the underlying writer
+func (e *apngEncoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.frames != e.frameCount {
+		return errors.New("apng: frame count mismatch")
+	}
+	e.writeChunk("IEND", nil)
+	return e.err
+}
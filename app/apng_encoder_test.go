@@ -0,0 +1,179 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+type apngChunk struct {
+	chunkType string
+	data      []byte
+}
+
+// parsePngChunks split the chunks after the signature and check the CRCs
+func parsePngChunks(t *testing.T, data []byte) []apngChunk {
+	t.Helper()
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(data, signature) {
+		t.Fatalf("invalid png signature: %q", data[:8])
+	}
+	data = data[len(signature):]
+	var chunks []apngChunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("truncated chunk: %d bytes", len(data))
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if 12+size > len(data) {
+			t.Fatalf("chunk '%s' size %d out of range %d", data[4:8], size, len(data)-12)
+		}
+		chunkType, body := string(data[4:8]), data[8:8+size]
+		if crc := binary.BigEndian.Uint32(data[8+size:]); crc != crc32.ChecksumIEEE(data[4:8+size]) {
+			t.Fatalf("chunk '%s' crc mismatch", chunkType)
+		}
+		chunks = append(chunks, apngChunk{chunkType: chunkType, data: body})
+		data = data[12+size:]
+	}
+	return chunks
+}
+
+// pngFile build a standalone png from the chunks, it's used to decode the fdAT frames
+func pngFile(chunks ...apngChunk) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	encoder := newApngEncoder(&buf, 0)
+	for _, chunk := range chunks {
+		encoder.writeChunk(chunk.chunkType, chunk.data)
+	}
+	return buf.Bytes()
+}
+
+func TestApngEncoder(t *testing.T) {
+	const width, height = 29, 17
+	delays := []int{100, 250, 70000}
+	frames := make([]*image.NRGBA, len(delays))
+	for i := range frames {
+		frames[i] = testFrame(width, height, int64(20+i))
+	}
+
+	var buf bytes.Buffer
+	encoder := newApngEncoder(&buf, len(frames))
+	for i, frame := range frames {
+		if err := encoder.AddFrame(frame, delays[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the decoders without APNG support show the first frame
+	first, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameImage(t, frames[0], first)
+
+	chunks := parsePngChunks(t, buf.Bytes())
+	var types []string
+	for _, chunk := range chunks {
+		types = append(types, chunk.chunkType)
+	}
+	wantTypes := []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	if len(types) != len(wantTypes) {
+		t.Fatalf("chunk layout mismatch, want: %v, got: %v", wantTypes, types)
+	}
+	for i := range types {
+		if types[i] != wantTypes[i] {
+			t.Fatalf("chunk layout mismatch, want: %v, got: %v", wantTypes, types)
+		}
+	}
+
+	ihdr := chunks[0]
+	if w, h := binary.BigEndian.Uint32(ihdr.data), binary.BigEndian.Uint32(ihdr.data[4:]); w != width || h != height {
+		t.Fatalf("IHDR size mismatch, got: %dx%d", w, h)
+	}
+	if frameCount := binary.BigEndian.Uint32(chunks[1].data); frameCount != uint32(len(frames)) {
+		t.Fatalf("acTL frame count mismatch, want: %d, got: %d", len(frames), frameCount)
+	}
+
+	wantDelays := [][2]uint16{{100, 1000}, {250, 1000}, {7000, 100}}
+	var (
+		seq   uint32
+		frame int
+	)
+	for i := 2; i < len(chunks)-1; i += 2 {
+		fctl, body := chunks[i], chunks[i+1]
+		if got := binary.BigEndian.Uint32(fctl.data); got != seq {
+			t.Fatalf("frame %d: fcTL sequence mismatch, want: %d, got: %d", frame, seq, got)
+		}
+		seq++
+		if w, h := binary.BigEndian.Uint32(fctl.data[4:]), binary.BigEndian.Uint32(fctl.data[8:]); w != width || h != height {
+			t.Fatalf("frame %d: fcTL size mismatch, got: %dx%d", frame, w, h)
+		}
+		num, den := binary.BigEndian.Uint16(fctl.data[20:]), binary.BigEndian.Uint16(fctl.data[22:])
+		if num != wantDelays[frame][0] || den != wantDelays[frame][1] {
+			t.Fatalf("frame %d: delay mismatch, want: %v, got: %d/%d", frame, wantDelays[frame], num, den)
+		}
+
+		idat := body.data
+		if body.chunkType == "fdAT" {
+			if got := binary.BigEndian.Uint32(body.data); got != seq {
+				t.Fatalf("frame %d: fdAT sequence mismatch, want: %d, got: %d", frame, seq, got)
+			}
+			seq++
+			idat = body.data[4:]
+		}
+		img, err := png.Decode(bytes.NewReader(pngFile(ihdr, apngChunk{"IDAT", idat}, apngChunk{"IEND", nil})))
+		if err != nil {
+			t.Fatalf("frame %d: %s", frame, err)
+		}
+		assertSameImage(t, frames[frame], img)
+		frame++
+	}
+}
+
+func TestApngEncoderFrameCountMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := newApngEncoder(&buf, 2)
+	if err := encoder.AddFrame(testFrame(4, 4, 1), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Close(); err == nil {
+		t.Fatal("expect error if fewer frames than the acTL frame count")
+	}
+}
+
+func TestApngDelay(t *testing.T) {
+	tests := []struct {
+		delay   int
+		num     uint16
+		den     uint16
+		wantErr bool
+	}{
+		{delay: 0, num: 0, den: 1000},
+		{delay: 65535, num: 65535, den: 1000},
+		{delay: 65536, num: 6554, den: 100},
+		{delay: 655350, num: 65535, den: 100},
+		{delay: 655360, num: 6554, den: 10},
+		{delay: 65535000, num: 65535, den: 1},
+		{delay: 65535500, wantErr: true},
+		{delay: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		num, den, err := apngDelay(tt.delay)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("apngDelay(%d) expect error, got: %d/%d", tt.delay, num, den)
+			}
+			continue
+		}
+		if err != nil || num != tt.num || den != tt.den {
+			t.Errorf("apngDelay(%d) want: %d/%d, got: %d/%d, err: %v", tt.delay, tt.num, tt.den, num, den, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AccountStatus is the login status of the cookie of an account
+type AccountStatus struct {
+	Account   string
+	HasCookie bool // the account without cookie requests pixiv anonymously
+	LoggedIn  bool
+	UserId    string
+	UserName  string
+	Err       error // failed to check, e.g. network error
+}
+
+// Ok return false if the cookie is not logged in or failed to check
+func (s *AccountStatus) Ok() bool {
+	return !s.HasCookie || (s.Err == nil && s.LoggedIn)
+}
+
+func (s *AccountStatus) String() string {
+	switch {
+	case !s.HasCookie:
+		return fmt.Sprintf("account '%s' has no cookie, request anonymously", s.Account)
+	case s.Err != nil:
+		return fmt.Sprintf("account '%s' failed to check login status, msg: %s", s.Account, s.Err)
+	case !s.LoggedIn:
+		return fmt.Sprintf("account '%s' is NOT logged in, the cookie is expired or invalid", s.Account)
+	default:
+		return fmt.Sprintf("account '%s' is logged in as '%s' (uid: %s)", s.Account, s.UserName, s.UserId)
+	}
+}
+
+func checkAccount(ctx context.Context, account *PixivAccount, client *PixivWebClient) *AccountStatus {
+	status := &AccountStatus{Account: account.Name, HasCookie: len(account.Cookie) > 0}
+	if !status.HasCookie {
+		return status
+	}
+	loginStatus, err := client.GetLoginStatus(ctx)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.LoggedIn = loginStatus.LoggedIn
+	status.UserId = loginStatus.UserId
+	status.UserName = loginStatus.UserName
+	return status
+}
+
+// CheckAccounts check the login status of all the accounts
+func CheckAccounts(ctx context.Context, options *PixivDlOptions) []*AccountStatus {
+	statuses := make([]*AccountStatus, 0)
+	for _, account := range PixivAccounts(options) {
+		account := account
+		client := NewPixivWebClient(options, &account, options.ParseTimeoutMs)
+		statuses = append(statuses, checkAccount(ctx, &account, client))
+	}
+	return statuses
+}
+
+// LogAccountStatuses log the login status of the accounts, the logged out accounts are logged as error
+func LogAccountStatuses(statuses []*AccountStatus) {
+	for _, status := range statuses {
+		logAccountStatus(status)
+	}
+}
+
+func logAccountStatus(status *AccountStatus) {
+	switch {
+	case status.Err != nil:
+		log.Warningf("[Auth] The %s", status)
+	case !status.Ok():
+		log.Errorf("[Auth] The %s, the bookmarks, following and R-18 illust can not be downloaded, "+
+			"please update the cookie", status)
+	default:
+		log.Infof("[Auth] The %s", status)
+	}
+}
+
+// AuthWatchdog check the login status of the accounts every 'auth-check-interval-sec' in service mode. The workers
+// stop using the logged out accounts, so all the work is paused if all the accounts are logged out, rather than
+// getting empty bookmarks pages and marking the illust not found. They continue when the account is logged in again.
+type AuthWatchdog struct {
+	options  *PixivDlOptions
+	pool     *AccountPool
+	accounts []PixivAccount
+	clients  []*PixivWebClient
+	last     []*AccountStatus // the last checked status of the accounts, nil if never checked
+}
+
+func NewAuthWatchdog(options *PixivDlOptions) *AuthWatchdog {
+	w := &AuthWatchdog{
+		options: options,
+		pool:    SharedAccountPool(options),
+	}
+	w.accounts = w.pool.Accounts()
+	for idx := range w.accounts {
+		w.clients = append(w.clients, NewPixivWebClient(options, &w.accounts[idx], options.ParseTimeoutMs))
+	}
+	w.last = make([]*AccountStatus, len(w.accounts))
+	return w
+}
+
+// Run check the accounts every 'auth-check-interval-sec' until the ctx is done
+func (w *AuthWatchdog) Run(ctx context.Context) {
+	interval := time.Duration(w.options.AuthCheckIntervalSec) * time.Second
+	for SleepContext(ctx, interval) {
+		w.Check(ctx)
+	}
+}
+
+// Check check all the accounts once and stop or resume using them, the status is logged when it changes
+func (w *AuthWatchdog) Check(ctx context.Context) []*AccountStatus {
+	statuses := make([]*AccountStatus, 0, len(w.accounts))
+	withCookie, loggedOut := 0, 0
+	for idx := range w.accounts {
+		status := checkAccount(ctx, &w.accounts[idx], w.clients[idx])
+		statuses = append(statuses, status)
+		if !status.HasCookie {
+			if w.last[idx] == nil {
+				logAccountStatus(status)
+				w.last[idx] = status
+			}
+			continue
+		}
+		withCookie++
+		if status.Err != nil {
+			// keep the last status if failed to check
+			log.Warningf("[AuthWatchdog] The %s", status)
+			if last := w.last[idx]; last != nil && !last.Ok() {
+				loggedOut++
+			}
+			continue
+		}
+
+		if last := w.last[idx]; last == nil || last.LoggedIn != status.LoggedIn || last.UserId != status.UserId {
+			logAccountStatus(status)
+		}
+		w.last[idx] = status
+		if status.LoggedIn {
+			accountLoggedIn.WithLabelValues(status.Account).Set(1)
+			if w.pool.SetLoggedOut(idx, false) {
+				log.Infof("[AuthWatchdog] Account '%s' is logged in again, resume using it", status.Account)
+			}
+			continue
+		}
+		accountLoggedIn.WithLabelValues(status.Account).Set(0)
+		loggedOut++
+		if w.pool.SetLoggedOut(idx, true) {
+			log.Errorf("[AuthWatchdog] Account '%s' is logged out, stop using it", status.Account)
+		}
+	}
+	if withCookie > 0 && loggedOut == withCookie && withCookie == len(w.accounts) {
+		log.Errorf("[AuthWatchdog] !!! All the accounts are logged out, ALL THE WORK IS PAUSED. Please update the cookie " +
+			"and restart, the work continues automatically if the account is logged in again")
+	}
+	return statuses
+}
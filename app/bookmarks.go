@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+// the 'bookmarks-visibility' options, the private bookmarks are only visible to the account owns them
+const (
+	BookmarksVisibilityPublic  = "public"
+	BookmarksVisibilityPrivate = "private"
+	BookmarksVisibilityAll     = "all"
+)
+
+func IsValidBookmarksVisibility(visibility string) bool {
+	return visibility == BookmarksVisibilityPublic || visibility == BookmarksVisibilityPrivate ||
+		visibility == BookmarksVisibilityAll
+}
+
+// IllustBookmark is the bookmark of the illust by the user
+type IllustBookmark struct {
+	Pid     string
+	Uid     string
+	Private bool
+	Tags    []string // the bookmark tags set by the user, not the illust tags
+}
+
+// BookmarksPage is a page of the illust bookmarks of the user, Bookmarks is in the same order as Works
+type BookmarksPage struct {
+	Works     []*pixiv.IllustDigest
+	Bookmarks []*IllustBookmark
+	Total     int32
+}
+
+// bookmarksAjaxBody is the response of '/ajax/user/{uid}/illusts/bookmarks'
+type bookmarksAjaxBody struct {
+	Works []struct {
+		// the id of the deleted illust is a number instead of a string
+		Id           json.Number `json:"id"`
+		UserId       json.Number `json:"userId"`
+		PageCount    int         `json:"pageCount"`
+		BookmarkData *struct {
+			Id      json.Number `json:"id"`
+			Private bool        `json:"private"`
+		} `json:"bookmarkData"`
+	} `json:"works"`
+	Total int32 `json:"total"`
+	// BookmarkTags is the bookmark tags of the works in this page, keyed by the bookmark id
+	BookmarkTags map[string][]string `json:"bookmarkTags"`
+}
+
+func (b *bookmarksAjaxBody) bookmarksPage(uid pixiv.PixivID, private bool) *BookmarksPage {
+	page := &BookmarksPage{Total: b.Total}
+	for _, work := range b.Works {
+		pageCount := work.PageCount
+		if pageCount <= 0 {
+			pageCount = 1
+		}
+		page.Works = append(page.Works, &pixiv.IllustDigest{
+			Id:        pixiv.PixivID(work.Id),
+			UserId:    pixiv.PixivID(work.UserId),
+			PageCount: pageCount,
+		})
+		bookmark := &IllustBookmark{Pid: string(work.Id), Uid: string(uid), Private: private}
+		if work.BookmarkData != nil {
+			bookmark.Private = work.BookmarkData.Private
+			bookmark.Tags = b.BookmarkTags[string(work.BookmarkData.Id)]
+		}
+		page.Bookmarks = append(page.Bookmarks, bookmark)
+	}
+	return page
+}
+
+// bookmarksScope is a part of the user bookmarks which is requested separately, pixiv only responds the public or
+// the private bookmarks of one bookmark tag at a time
+type bookmarksScope struct {
+	uid     pixiv.PixivID
+	private bool
+	tag     string // empty means all the tags
+}
+
+// bookmarksScopes return the scopes of the user bookmarks to download by 'bookmarks-visibility' and 'bookmark-tags'
+func bookmarksScopes(options *PixivDlOptions, uid pixiv.PixivID) []bookmarksScope {
+	var privates []bool
+	switch options.BookmarksVisibility {
+	case BookmarksVisibilityPrivate:
+		privates = []bool{true}
+	case BookmarksVisibilityAll:
+		privates = []bool{false, true}
+	default:
+		privates = []bool{false}
+	}
+	tags := options.BookmarkTags
+	if len(tags) == 0 {
+		tags = []string{""}
+	}
+
+	var scopes []bookmarksScope
+	for _, private := range privates {
+		for _, tag := range tags {
+			scopes = append(scopes, bookmarksScope{uid: uid, private: private, tag: tag})
+		}
+	}
+	return scopes
+}
+
+// key is used as the page cursor and the bookmark marker key. The public bookmarks of all the tags use the uid only,
+// so that the cursors and markers saved before the scopes are supported still work.
+func (s bookmarksScope) key() pixiv.PixivID {
+	if !s.private && len(s.tag) == 0 {
+		return s.uid
+	}
+	parts := []string{string(s.uid), s.rest()}
+	if len(s.tag) > 0 {
+		parts = append(parts, s.tag)
+	}
+	return pixiv.PixivID(strings.Join(parts, ":"))
+}
+
+// rest is the 'rest' query of the bookmarks api
+func (s bookmarksScope) rest() string {
+	if s.private {
+		return "hide"
+	}
+	return "show"
+}
+
+// String return the scope for log
+func (s bookmarksScope) String() string {
+	visibility := BookmarksVisibilityPublic
+	if s.private {
+		visibility = BookmarksVisibilityPrivate
+	}
+	if len(s.tag) == 0 {
+		return fmt.Sprintf("uid '%s' (%s)", s.uid, visibility)
+	}
+	return fmt.Sprintf("uid '%s' (%s, tag '%s')", s.uid, visibility, s.tag)
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ControlServer is the HTTP API to control the downloaders in service mode:
+//
+//	GET  /api/status                 the queue depth and worker counters of all the downloaders
+//	POST /api/jobs/{kind}            enqueue the ids in body '{"ids": ["123"]}', kind is illust/bookmarks/artist/following/
+//	                                 novel/novel_artist/novel_bookmarks/search/ranking/series, the ids of search
+//	                                 are the keywords and the ids of ranking are the modes
+//	POST /api/rescan[?kind={kind}]   start the next round immediately
+//	POST /api/pause[?kind={kind}]    pause downloading
+//	POST /api/resume[?kind={kind}]   resume downloading
+//	GET  /metrics                    the metrics in prometheus format
+type ControlServer struct {
+	server      *http.Server
+	mux         *http.ServeMux
+	downloaders []PixivDownloader
+}
+
+type jobRequest struct {
+	Ids []string `json:"ids"`
+}
+
+type statusResponse struct {
+	Downloaders []*DownloaderStats `json:"downloaders"`
+}
+
+func NewControlServer(addr string, downloaders ...PixivDownloader) *ControlServer {
+	s := &ControlServer{
+		mux:         http.NewServeMux(),
+		downloaders: downloaders,
+	}
+	s.mux.HandleFunc("/api/status", s.handleStatus)
+	s.mux.HandleFunc("/api/jobs/", s.handleJobs)
+	s.mux.HandleFunc("/api/rescan", s.handleAction(PixivDownloader.Rescan))
+	s.mux.HandleFunc("/api/pause", s.handleAction(PixivDownloader.Pause))
+	s.mux.HandleFunc("/api/resume", s.handleAction(PixivDownloader.Resume))
+	s.mux.Handle("/metrics", NewMetricsHandler(downloaders...))
+	s.server = &http.Server{Addr: addr, Handler: s.mux}
+	return s
+}
+
+// Start listen and serve in background
+func (s *ControlServer) Start() {
+	go func() {
+		log.Infof("[ControlServer] Listen on %s", s.server.Addr)
+		err := s.server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("[ControlServer] Failed to serve, msg: %s", err)
+		}
+	}()
+}
+
+// Close stop accepting new requests and wait the in-flight requests finished
+func (s *ControlServer) Close(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJson(w, code, map[string]string{"error": err.Error()})
+}
+
+// findDownloaders return all the downloaders if kind is empty
+func (s *ControlServer) findDownloaders(kind string) []PixivDownloader {
+	downloaders := make([]PixivDownloader, 0)
+	for _, downloader := range s.downloaders {
+		if len(kind) == 0 || downloader.Kind() == kind {
+			downloaders = append(downloaders, downloader)
+		}
+	}
+	return downloaders
+}
+
+func (s *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	s.writeStatus(w)
+}
+
+func (s *ControlServer) writeStatus(w http.ResponseWriter) {
+	resp := statusResponse{Downloaders: make([]*DownloaderStats, 0)}
+	for _, downloader := range s.downloaders {
+		resp.Downloaders = append(resp.Downloaders, downloader.Stats())
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (s *ControlServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	kind := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+	downloaders := s.findDownloaders(kind)
+	if len(kind) == 0 || len(downloaders) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no downloader of kind '%s'", kind))
+		return
+	}
+
+	var req jobRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ids := make([]string, 0, len(req.Ids))
+	for _, id := range req.Ids {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("ids is empty"))
+		return
+	}
+
+	err = downloaders[0].Enqueue(r.Context(), ids)
+	if errors.Is(err, ErrDownloaderNotRunning) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusAccepted, map[string]int{"enqueued": len(ids)})
+}
+
+func (s *ControlServer) handleAction(action func(PixivDownloader)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		kind := r.URL.Query().Get("kind")
+		downloaders := s.findDownloaders(kind)
+		if len(downloaders) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no downloader of kind '%s'", kind))
+			return
+		}
+		for _, downloader := range downloaders {
+			action(downloader)
+		}
+		s.writeStatus(w)
+	}
+}
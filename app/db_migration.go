@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// schemaMigration is a group of statements to upgrade the database schema to the version
+type schemaMigration struct {
+	version int
+	stmts   []string
+}
+
+const (
+	createSchemaVersionTableSql = "CREATE TABLE IF NOT EXISTS schema_version (version int NOT NULL, applied_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)"
+	getSchemaVersionSql         = "SELECT COALESCE(MAX(version), 0) FROM schema_version"
+	saveSchemaVersionSql        = "INSERT INTO schema_version (version) VALUES (?)"
+)
+
+// migrateSchema apply all the migrations which version is greater than current schema version
+func migrateSchema(ctx context.Context, db *sql.DB, migrations []schemaMigration) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return migrateSchemaConn(ctx, conn, migrations)
+}
+
+func migrateSchemaConn(ctx context.Context, conn *sql.Conn, migrations []schemaMigration) error {
+	_, err := conn.ExecContext(ctx, createSchemaVersionTableSql)
+	if err != nil {
+		return err
+	}
+
+	var curVersion int
+	err = conn.QueryRowContext(ctx, getSchemaVersionSql).Scan(&curVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.version <= curVersion {
+			continue
+		}
+		err = applyMigration(ctx, conn, migration)
+		if err != nil {
+			return err
+		}
+		log.Infof("[Database] Success migrate schema from version %d to %d", curVersion, migration.version)
+		curVersion = migration.version
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, migration schemaMigration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range migration.stmts {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, saveSchemaVersionSql, migration.version)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
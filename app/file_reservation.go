@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// the owner of the file, see fileReservations.reserve
+const (
+	fileOwnerNone    = iota // the file doesn't exist
+	fileOwnerUnknown        // not recorded, e.g. 'database-type' is NONE or the process exited before saving it
+	fileOwnerSelf           // the same illust page or novel, the file is downloaded again
+	fileOwnerOther
+)
+
+// fileReservations is the filenames being written by the download workers, it's shared by all the downloaders in
+// this process so that the same file is never written in parallel
+type fileReservations struct {
+	mu    sync.Mutex
+	files map[string]*fileReservation
+}
+
+type fileReservation struct {
+	owner    FileOwner
+	released chan struct{}
+}
+
+var (
+	sharedReservations     *fileReservations
+	sharedReservationsOnce sync.Once
+)
+
+// sharedFileReservations return the file reservations shared by all the workers in this process
+func sharedFileReservations() *fileReservations {
+	sharedReservationsOnce.Do(func() {
+		sharedReservations = &fileReservations{files: make(map[string]*fileReservation)}
+	})
+	return sharedReservations
+}
+
+// reserve pick an available filename according to the collision option and reserve it for self until the returned
+// func is called. An exist file is a collision only if fileOwner returns fileOwnerOther, the file of the same illust
+// page or novel or not recorded is written again in place, except that 'skip' skips all the exist files of the
+// others. If the filename is reserved by self in the other worker, it waits until released so that the file is not
+// renamed and never written in parallel. Return empty filename if it should be skipped.
+func (r *fileReservations) reserve(ctx context.Context, filename string, self FileOwner, collision string,
+	fileOwner func(name string) int) (string, func(), error) {
+	for {
+		name, held := r.tryReserve(filename, self, collision, fileOwner)
+		if held == nil {
+			if len(name) == 0 {
+				return "", func() {}, nil
+			}
+			return name, func() {
+				r.release(name)
+			}, nil
+		}
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return "", func() {}, ctx.Err()
+		}
+	}
+}
+
+// tryReserve return the reserved filename, or the released chan if the filename is reserved by self
+func (r *fileReservations) tryReserve(filename string, self FileOwner, collision string,
+	fileOwner func(name string) int) (string, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := filename
+	for i := 1; ; i++ {
+		reserved, ok := r.files[name]
+		if ok && reserved.owner == self {
+			return "", reserved.released
+		}
+		var collide bool
+		if ok {
+			collide = true
+		} else {
+			switch owner := fileOwner(name); owner {
+			case fileOwnerNone, fileOwnerSelf:
+				collide = false
+			case fileOwnerUnknown:
+				collide = collision == FilenameCollisionSkip
+			default:
+				collide = collision != FilenameCollisionOverwrite
+			}
+		}
+		if !collide {
+			break
+		}
+		if collision == FilenameCollisionSkip {
+			return "", nil
+		}
+		name = collisionFilename(filename, i)
+	}
+	r.files[name] = &fileReservation{owner: self, released: make(chan struct{})}
+	return name, nil
+}
+
+func (r *fileReservations) release(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reserved, ok := r.files[name]; ok {
+		close(reserved.released)
+		delete(r.files, name)
+	}
+}
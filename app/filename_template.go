@@ -0,0 +1,300 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+const (
+	FilenameCollisionRename    = "rename"
+	FilenameCollisionOverwrite = "overwrite"
+	FilenameCollisionSkip      = "skip"
+)
+
+var filenameCollisions = map[string]struct{}{
+	FilenameCollisionRename:    {},
+	FilenameCollisionOverwrite: {},
+	FilenameCollisionSkip:      {},
+}
+
+func IsValidFilenameCollision(collision string) bool {
+	_, ok := filenameCollisions[collision]
+	return ok
+}
+
+// filenameShorthands map the '{name}' shorthand in filename pattern to the template action
+var filenameShorthands = map[string]string{
+	"id":         "{{.Name}}",
+	"pid":        "{{.Id}}",
+	"page":       "{{.PageIdx}}",
+	"title":      "{{.Title}}",
+	"user_id":    "{{.UserId}}",
+	"user":       "{{.UserName}}",
+	"account":    "{{.UserAccount}}",
+	"tags":       `{{join .Tags "_"}}`,
+	"r18":        "{{if .R18}}R18{{end}}",
+	"width":      "{{.Width}}",
+	"height":     "{{.Height}}",
+	"bookmarks":  "{{.BookmarkCount}}",
+	"likes":      "{{.LikeCount}}",
+	"series":     "{{.Series}}",
+	"series_id":  "{{.SeriesId}}",
+	"episode":    "{{.Episode}}",
+	"yyyy":       `{{date "2006" .CreateDate}}`,
+	"yyyy-mm":    `{{date "2006-01" .CreateDate}}`,
+	"yyyy-mm-dd": `{{date "2006-01-02" .CreateDate}}`,
+}
+
+var filenameFuncs = template.FuncMap{
+	"date":     formatDate,
+	"join":     strings.Join,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"truncate": truncateRunes,
+}
+
+// FilenameData is the data to execute the filename template. All the fields of pixiv.IllustInfo can be used,
+// the string fields are standardized so that they never contain the path separator.
+type FilenameData struct {
+	pixiv.IllustInfo
+	Name string // the original filename without extension, e.g. '12345678_p0'
+	Ext  string // the extension of the original file, e.g. '.jpg'
+	// the manga series recorded by the series source, empty if the illust is not in any series
+	Series   string
+	SeriesId string
+	Episode  int
+}
+
+// FilenameTemplate format the illust filename by the 'filename-pattern', the pattern is a go text/template
+// with some '{name}' shorthands (e.g. '{user}/{yyyy-mm}/{id}'), '/' in pattern creates subdirectories.
+type FilenameTemplate struct {
+	tmpl       *template.Template
+	maxLength  int
+	usesSeries bool
+}
+
+func NewFilenameTemplate(pattern string, maxLength int) (*FilenameTemplate, error) {
+	if len(pattern) == 0 {
+		pattern = "{id}"
+	}
+	t, err := newFilenameTemplate(pattern, maxLength, filenameShorthands, &FilenameData{})
+	if err != nil {
+		return nil, err
+	}
+	text := t.tmpl.Root.String()
+	t.usesSeries = strings.Contains(text, ".Series") || strings.Contains(text, ".Episode")
+	return t, nil
+}
+
+// UsesSeries return true if the pattern refers to the series, so that the series of the illust is needed
+func (t *FilenameTemplate) UsesSeries() bool {
+	return t.usesSeries
+}
+
+// newFilenameTemplate parse the pattern with the shorthands, sample is the empty data to find the unknown fields
+func newFilenameTemplate(pattern string, maxLength int, shorthands map[string]string, sample interface{}) (*FilenameTemplate, error) {
+	text, err := expandFilenameShorthands(pattern, shorthands)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("filename").Funcs(filenameFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename pattern '%s', msg: %w", pattern, err)
+	}
+
+	t := &FilenameTemplate{tmpl: tmpl, maxLength: maxLength}
+	// the unknown fields can only be found when executing
+	err = tmpl.Execute(io.Discard, sample)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename pattern '%s', msg: %w", pattern, err)
+	}
+	return t, nil
+}
+
+// expandFilenameShorthands replace the '{name}' shorthands by the template actions, '{{...}}' is kept as it is
+func expandFilenameShorthands(pattern string, shorthands map[string]string) (string, error) {
+	var sb strings.Builder
+	for len(pattern) > 0 {
+		if strings.HasPrefix(pattern, "{{") {
+			end := strings.Index(pattern, "}}")
+			if end < 0 {
+				return "", fmt.Errorf("unclosed action in filename pattern '%s'", pattern)
+			}
+			sb.WriteString(pattern[:end+2])
+			pattern = pattern[end+2:]
+			continue
+		}
+		if pattern[0] == '{' {
+			end := strings.IndexByte(pattern, '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed field in filename pattern '%s'", pattern)
+			}
+			action, ok := shorthands[pattern[1:end]]
+			if !ok {
+				return "", fmt.Errorf("unknown field '%s' in filename pattern", pattern[:end+1])
+			}
+			sb.WriteString(action)
+			pattern = pattern[end+1:]
+			continue
+		}
+		sb.WriteByte(pattern[0])
+		pattern = pattern[1:]
+	}
+	return sb.String(), nil
+}
+
+// Format return the relative path of the illust file, every path element is truncated to maxLength bytes
+// (the extension is kept). series is nil if the illust is not in any series.
+func (t *FilenameTemplate) Format(illust *pixiv.IllustInfo, series *SeriesEpisode) (string, error) {
+	base := path.Base(illust.Urls.Original)
+	if base == "." || base == "/" {
+		base = string(illust.Id)
+	}
+	data := FilenameData{
+		IllustInfo: *illust,
+		Ext:        path.Ext(base),
+	}
+	data.Name = strings.TrimSuffix(base, data.Ext)
+	data.Title = StandardizeFileName(illust.Title)
+	data.Description = StandardizeFileName(illust.Description)
+	data.UserName = StandardizeFileName(illust.UserName)
+	data.UserAccount = StandardizeFileName(illust.UserAccount)
+	data.Tags = make([]string, 0, len(illust.Tags))
+	for _, tag := range illust.Tags {
+		data.Tags = append(data.Tags, StandardizeFileName(tag))
+	}
+	if series != nil {
+		data.Series = StandardizeFileName(series.SeriesTitle)
+		data.SeriesId = series.SeriesId
+		data.Episode = series.Episode
+	}
+	return t.execute(&data, data.Ext, illust.Id)
+}
+
+// execute format the data to the relative path, every path element is truncated to maxLength bytes and ext is
+// appended to the last one
+func (t *FilenameTemplate) execute(data interface{}, ext string, id pixiv.PixivID) (string, error) {
+	var buf bytes.Buffer
+	err := t.tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+
+	elems := make([]string, 0)
+	for _, elem := range strings.Split(buf.String(), "/") {
+		elem = strings.TrimSpace(elem)
+		// never escape from the download path
+		if len(elem) == 0 || elem == "." || elem == ".." {
+			continue
+		}
+		elems = append(elems, truncateBytes(elem, t.maxLength))
+	}
+	if len(elems) == 0 {
+		return "", fmt.Errorf("empty filename for %s", id)
+	}
+	last := len(elems) - 1
+	elems[last] = truncateBytes(elems[last], t.maxLength-len(ext)) + ext
+	return filepath.Join(elems...), nil
+}
+
+// novelFilenameShorthands map the '{name}' shorthand in novel filename pattern to the template action
+var novelFilenameShorthands = map[string]string{
+	"id":         "{{.Id}}",
+	"title":      "{{.Title}}",
+	"user_id":    "{{.UserId}}",
+	"user":       "{{.UserName}}",
+	"tags":       `{{join .Tags "_"}}`,
+	"r18":        "{{if .R18}}R18{{end}}",
+	"series":     "{{.SeriesTitle}}",
+	"series_id":  "{{.SeriesId}}",
+	"bookmarks":  "{{.BookmarkCount}}",
+	"likes":      "{{.LikeCount}}",
+	"yyyy":       `{{date "2006" .CreateDate}}`,
+	"yyyy-mm":    `{{date "2006-01" .CreateDate}}`,
+	"yyyy-mm-dd": `{{date "2006-01-02" .CreateDate}}`,
+}
+
+// NovelFilenameData is the data to execute the novel filename template, all the fields of NovelInfo can be used
+type NovelFilenameData struct {
+	NovelInfo
+}
+
+// NovelFilenameTemplate format the novel filename by the 'novel-filename-pattern', the same as FilenameTemplate
+type NovelFilenameTemplate struct {
+	tmpl *FilenameTemplate
+}
+
+func NewNovelFilenameTemplate(pattern string, maxLength int) (*NovelFilenameTemplate, error) {
+	if len(pattern) == 0 {
+		pattern = "novel/{id}"
+	}
+	tmpl, err := newFilenameTemplate(pattern, maxLength, novelFilenameShorthands, &NovelFilenameData{})
+	if err != nil {
+		return nil, err
+	}
+	return &NovelFilenameTemplate{tmpl: tmpl}, nil
+}
+
+// Format return the relative path of the novel file with the extension of the novel format
+func (t *NovelFilenameTemplate) Format(novel *NovelInfo, format string) (string, error) {
+	data := NovelFilenameData{NovelInfo: *novel}
+	data.Title = StandardizeFileName(novel.Title)
+	data.Description = ""
+	data.Content = ""
+	data.UserName = StandardizeFileName(novel.UserName)
+	data.SeriesTitle = StandardizeFileName(novel.SeriesTitle)
+	data.Tags = make([]string, 0, len(novel.Tags))
+	for _, tag := range novel.Tags {
+		data.Tags = append(data.Tags, StandardizeFileName(tag))
+	}
+	return t.tmpl.execute(&data, "."+format, novel.Id)
+}
+
+// formatDate format the date by the layout, the date can be a time.Time or a RFC3339 string
+func formatDate(layout string, date interface{}) (string, error) {
+	switch d := date.(type) {
+	case time.Time:
+		return d.Format(layout), nil
+	case *time.Time:
+		return d.Format(layout), nil
+	case string:
+		if len(d) == 0 {
+			return "", nil
+		}
+		t, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(layout), nil
+	default:
+		return "", fmt.Errorf("not supported date type %T", date)
+	}
+}
+
+// truncateRunes keep the first n characters of the string, used as '{{.Title | truncate 20}}'
+func truncateRunes(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// truncateBytes keep the first n bytes of the string without breaking a UTF-8 character, n <= 0 means no limit
+func truncateBytes(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CheckItem is a illust page or novel record which file is missing or corrupted
+type CheckItem struct {
+	Pid      string
+	Page     int
+	Filename string
+	Novel    bool // Pid is the novel id
+}
+
+// CheckResult is the result of CheckDatabaseAndFile
+type CheckResult struct {
+	Total     int
+	Ok        int
+	Missing   []*CheckItem
+	Corrupted []*CheckItem
+	Orphaned  []string // files in download path but not recorded in database
+	Partial   []string // the '.part' files being downloaded or to be resumed, they are never removed
+}
+
+// CheckOptions control what CheckDatabaseAndFile do with the missing, corrupted and orphaned files
+type CheckOptions struct {
+	DownloadPath string
+	// Fix delete the database record of missing or corrupted files (and the corrupted files),
+	// so that the next download will download them again
+	Fix bool
+	// RemoveOrphan delete the files which are not recorded in database
+	RemoveOrphan bool
+}
+
+// illustFileRecord is the file info of an illust page or a novel in database
+type illustFileRecord struct {
+	pid           string
+	page          int
+	hash          string
+	filename      string
+	novel         bool
+	coverFilename string // the cover stored along with the novel, empty if none
+}
+
+func (r *illustFileRecord) String() string {
+	if r.novel {
+		return fmt.Sprintf("novel: %s", r.pid)
+	}
+	return fmt.Sprintf("pid: %s, page: %d", r.pid, r.page)
+}
+
+// checkIllustFiles verify every record's file exist and the sha1 match, deleteFn is called to delete the record if fix
+func checkIllustFiles(records []*illustFileRecord, options *CheckOptions, deleteFn func(record *illustFileRecord) error) (*CheckResult, error) {
+	result := &CheckResult{}
+	known := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		// the record of not found illust has no file
+		if len(record.filename) == 0 || len(record.hash) == 0 {
+			continue
+		}
+		result.Total++
+
+		fullFilename := filepath.Join(options.DownloadPath, record.filename)
+		known[filepath.Clean(fullFilename)] = struct{}{}
+		// the ugoira frames zip and metadata are stored along with the file
+		zipFilename, metaFilename := ugoiraCompanionFiles(fullFilename)
+		known[filepath.Clean(zipFilename)] = struct{}{}
+		known[filepath.Clean(metaFilename)] = struct{}{}
+		// the metadata sidecars are stored along with the file, see 'sidecar-formats'
+		for _, format := range []string{SidecarFormatJson, SidecarFormatTxt} {
+			known[filepath.Clean(sidecarFilename(fullFilename, format))] = struct{}{}
+		}
+		if len(record.coverFilename) > 0 {
+			known[filepath.Clean(filepath.Join(options.DownloadPath, record.coverFilename))] = struct{}{}
+		}
+		item := &CheckItem{Pid: record.pid, Page: record.page, Filename: record.filename, Novel: record.novel}
+
+		corrupted := false
+		hash, err := FileSha1Sum(fullFilename)
+		if os.IsNotExist(err) {
+			log.Warningf("[Checker] Missing file, %s, filename: %s", record, record.filename)
+			result.Missing = append(result.Missing, item)
+		} else if err != nil {
+			return nil, err
+		} else if hash != record.hash {
+			log.Warningf("[Checker] Corrupted file, %s, filename: %s, expect sha1: %s, actual sha1: %s",
+				record, record.filename, record.hash, hash)
+			result.Corrupted = append(result.Corrupted, item)
+			corrupted = true
+		} else {
+			result.Ok++
+			continue
+		}
+
+		if !options.Fix {
+			continue
+		}
+		err = deleteFn(record)
+		if err != nil {
+			return nil, err
+		}
+		if corrupted {
+			_ = os.Remove(fullFilename)
+		}
+		log.Infof("[Checker] Delete record, it will be downloaded next time, %s", record)
+	}
+
+	err := filepath.WalkDir(options.DownloadPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip hidden files such as .DS_Store
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if _, ok := known[filepath.Clean(path)]; ok {
+			return nil
+		}
+		if strings.HasSuffix(path, partialFileSuffix) {
+			log.Infof("[Checker] Partial file: %s", path)
+			result.Partial = append(result.Partial, path)
+			return nil
+		}
+
+		log.Warningf("[Checker] Orphaned file: %s", path)
+		result.Orphaned = append(result.Orphaned, path)
+		if options.RemoveOrphan {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return result, nil
+}
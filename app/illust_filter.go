@@ -0,0 +1,562 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+// IllustFilter is a boolean expression over the illust fields, the illust is downloaded only if the expression is
+// true, e.g. 'bookmarks > 500 && !r18 && "landscape" in tags && width >= height'.
+//
+// Supported operators (from low to high precedence): '||', '&&', '!', '==' '!=' '<' '<=' '>' '>=' 'in',
+// '+' '-', '*' '/'. 'in' checks whether a string is in a list (e.g. tags) or a substring of another string.
+// Literals are numbers, strings quoted by '"' or '\”, and true/false. See filterFields for all the fields.
+type IllustFilter struct {
+	expr string
+	root filterNode
+}
+
+type filterType int
+
+const (
+	filterNumber filterType = iota
+	filterString
+	filterBool
+	filterList
+)
+
+func (t filterType) String() string {
+	switch t {
+	case filterNumber:
+		return "number"
+	case filterString:
+		return "string"
+	case filterBool:
+		return "bool"
+	default:
+		return "list"
+	}
+}
+
+type filterValue struct {
+	num  float64
+	str  string
+	b    bool
+	list []string
+}
+
+type filterField struct {
+	typ   filterType
+	value func(illust *pixiv.IllustInfo) filterValue
+}
+
+func numberField(value func(illust *pixiv.IllustInfo) int) filterField {
+	return filterField{typ: filterNumber, value: func(illust *pixiv.IllustInfo) filterValue {
+		return filterValue{num: float64(value(illust))}
+	}}
+}
+
+func stringField(value func(illust *pixiv.IllustInfo) string) filterField {
+	return filterField{typ: filterString, value: func(illust *pixiv.IllustInfo) filterValue {
+		return filterValue{str: value(illust)}
+	}}
+}
+
+func boolField(value func(illust *pixiv.IllustInfo) bool) filterField {
+	return filterField{typ: filterBool, value: func(illust *pixiv.IllustInfo) filterValue {
+		return filterValue{b: value(illust)}
+	}}
+}
+
+var filterFields = map[string]filterField{
+	"id":         stringField(func(illust *pixiv.IllustInfo) string { return string(illust.Id) }),
+	"title":      stringField(func(illust *pixiv.IllustInfo) string { return illust.Title }),
+	"user_id":    stringField(func(illust *pixiv.IllustInfo) string { return string(illust.UserId) }),
+	"user":       stringField(func(illust *pixiv.IllustInfo) string { return illust.UserName }),
+	"account":    stringField(func(illust *pixiv.IllustInfo) string { return illust.UserAccount }),
+	"page":       numberField(func(illust *pixiv.IllustInfo) int { return illust.PageIdx }),
+	"page_count": numberField(func(illust *pixiv.IllustInfo) int { return illust.PageCount }),
+	"width":      numberField(func(illust *pixiv.IllustInfo) int { return illust.Width }),
+	"height":     numberField(func(illust *pixiv.IllustInfo) int { return illust.Height }),
+	"bookmarks":  numberField(func(illust *pixiv.IllustInfo) int { return illust.BookmarkCount }),
+	"likes":      numberField(func(illust *pixiv.IllustInfo) int { return illust.LikeCount }),
+	"comments":   numberField(func(illust *pixiv.IllustInfo) int { return illust.CommentCount }),
+	"views":      numberField(func(illust *pixiv.IllustInfo) int { return illust.ViewCount }),
+	"r18":        boolField(func(illust *pixiv.IllustInfo) bool { return illust.R18 }),
+	"ugoira":     boolField(IsUgoira),
+	"tags": {typ: filterList, value: func(illust *pixiv.IllustInfo) filterValue {
+		return filterValue{list: illust.Tags}
+	}},
+}
+
+// NewIllustFilter parse the filter expression, an empty expression matches all the illust
+func NewIllustFilter(expr string) (*IllustFilter, error) {
+	filter := &IllustFilter{expr: strings.TrimSpace(expr)}
+	if len(filter.expr) == 0 {
+		return filter, nil
+	}
+
+	tokens, err := tokenizeFilter(filter.expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter '%s', %w", filter.expr, err)
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.errorf(p.peek(), "unexpected '%s'", p.peek().text)
+	}
+	if err == nil && root.typ() != filterBool {
+		err = fmt.Errorf("the filter must be a bool expression, got %s", root.typ())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter '%s', %w", filter.expr, err)
+	}
+	filter.root = root
+	return filter, nil
+}
+
+// Match return true if the illust should be downloaded
+func (f *IllustFilter) Match(illust *pixiv.IllustInfo) bool {
+	if f.root == nil {
+		return true
+	}
+	return f.root.eval(illust).b
+}
+
+func (f *IllustFilter) String() string {
+	return f.expr
+}
+
+// FilterExpression return the 'filter' option combined with the legacy filter options ('no-r18', 'only-p0',
+// 'bookmark-gt', 'like-gt', 'pixel-gt'), which are the sugar of the filter expression
+func FilterExpression(options *PixivDlOptions) string {
+	exprs := make([]string, 0)
+	if len(strings.TrimSpace(options.Filter)) > 0 {
+		exprs = append(exprs, "("+strings.TrimSpace(options.Filter)+")")
+	}
+	if options.NoR18 {
+		exprs = append(exprs, "!r18")
+	}
+	if options.OnlyP0 {
+		exprs = append(exprs, "page == 0")
+	}
+	// the count is unknown if it's 0
+	if options.BookmarkGt > 0 {
+		exprs = append(exprs, fmt.Sprintf("(bookmarks <= 0 || bookmarks >= %d)", options.BookmarkGt))
+	}
+	if options.LikeGt > 0 {
+		exprs = append(exprs, fmt.Sprintf("(likes <= 0 || likes >= %d)", options.LikeGt))
+	}
+	if options.PixelGt > 0 {
+		exprs = append(exprs, fmt.Sprintf("(width <= 0 || height <= 0 || width >= %d || height >= %d)", options.PixelGt, options.PixelGt))
+	}
+	return strings.Join(exprs, " && ")
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int // 1-based column
+	num  float64
+}
+
+var filterOps = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")"}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	tokens := make([]filterToken, 0)
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid number '%s'", start+1, string(runes[start:i]))
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: string(runes[start:i]), pos: start + 1, num: num})
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("column %d: unterminated string", start+1)
+			}
+			i++
+			tokens = append(tokens, filterToken{kind: tokenString, text: sb.String(), pos: start + 1})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: string(runes[start:i]), pos: start + 1})
+		default:
+			op := ""
+			for _, candidate := range filterOps {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			if len(op) == 0 {
+				return nil, fmt.Errorf("column %d: unexpected character '%c'", i+1, r)
+			}
+			tokens = append(tokens, filterToken{kind: tokenOp, text: op, pos: i + 1})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, text: "end of filter", pos: len(runes) + 1}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *filterParser) isOp(ops ...string) bool {
+	token := p.peek()
+	if token.kind != tokenOp && !(token.kind == tokenIdent && token.text == "in") {
+		return false
+	}
+	for _, op := range ops {
+		if token.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) errorf(token filterToken, format string, args ...interface{}) error {
+	return fmt.Errorf("column %d: %s", token.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left, err = newBinaryNode(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left, err = newBinaryNode(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if !p.isOp("!") {
+		return p.parseCompare()
+	}
+	op := p.next()
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if operand.typ() != filterBool {
+		return nil, p.errorf(op, "'!' needs a bool operand, got %s", operand.typ())
+	}
+	return &notNode{operand: operand}, nil
+}
+
+func (p *filterParser) parseCompare() (filterNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("==", "!=", "<", "<=", ">", ">=", "in") {
+		op := p.next()
+		right, err := p.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return newBinaryNode(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAdd() (filterNode, error) {
+	left, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+", "-") {
+		op := p.next()
+		right, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left, err = newBinaryNode(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseMul() (filterNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*", "/") {
+		op := p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left, err = newBinaryNode(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenNumber:
+		return &constNode{t: filterNumber, v: filterValue{num: token.num}}, nil
+	case tokenString:
+		return &constNode{t: filterString, v: filterValue{str: token.text}}, nil
+	case tokenIdent:
+		switch token.text {
+		case "true", "false":
+			return &constNode{t: filterBool, v: filterValue{b: token.text == "true"}}, nil
+		}
+		field, ok := filterFields[token.text]
+		if !ok {
+			return nil, p.errorf(token, "unknown field '%s'", token.text)
+		}
+		return &fieldNode{field: field}, nil
+	case tokenOp:
+		if token.text == "-" {
+			operand, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			if operand.typ() != filterNumber {
+				return nil, p.errorf(token, "'-' needs a number operand, got %s", operand.typ())
+			}
+			return newBinaryNode(token, &constNode{t: filterNumber}, operand)
+		}
+		if token.text == "(" {
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); closing.text != ")" || closing.kind != tokenOp {
+				return nil, p.errorf(closing, "expect ')', got '%s'", closing.text)
+			}
+			return node, nil
+		}
+	}
+	return nil, p.errorf(token, "unexpected '%s'", token.text)
+}
+
+type filterNode interface {
+	typ() filterType
+	eval(illust *pixiv.IllustInfo) filterValue
+}
+
+type constNode struct {
+	t filterType
+	v filterValue
+}
+
+func (n *constNode) typ() filterType {
+	return n.t
+}
+
+func (n *constNode) eval(*pixiv.IllustInfo) filterValue {
+	return n.v
+}
+
+type fieldNode struct {
+	field filterField
+}
+
+func (n *fieldNode) typ() filterType {
+	return n.field.typ
+}
+
+func (n *fieldNode) eval(illust *pixiv.IllustInfo) filterValue {
+	return n.field.value(illust)
+}
+
+type notNode struct {
+	operand filterNode
+}
+
+func (n *notNode) typ() filterType {
+	return filterBool
+}
+
+func (n *notNode) eval(illust *pixiv.IllustInfo) filterValue {
+	return filterValue{b: !n.operand.eval(illust).b}
+}
+
+type binaryNode struct {
+	t           filterType
+	left, right filterNode
+	fn          func(left, right filterValue) filterValue
+}
+
+func (n *binaryNode) typ() filterType {
+	return n.t
+}
+
+func (n *binaryNode) eval(illust *pixiv.IllustInfo) filterValue {
+	return n.fn(n.left.eval(illust), n.right.eval(illust))
+}
+
+// newBinaryNode check the operand types and create the node
+func newBinaryNode(op filterToken, left, right filterNode) (filterNode, error) {
+	lt, rt := left.typ(), right.typ()
+	node := &binaryNode{left: left, right: right}
+	mismatch := fmt.Errorf("column %d: '%s' can not be used between %s and %s", op.pos, op.text, lt, rt)
+
+	switch op.text {
+	case "||", "&&":
+		if lt != filterBool || rt != filterBool {
+			return nil, mismatch
+		}
+		node.t = filterBool
+		if op.text == "||" {
+			node.fn = func(l, r filterValue) filterValue { return filterValue{b: l.b || r.b} }
+		} else {
+			node.fn = func(l, r filterValue) filterValue { return filterValue{b: l.b && r.b} }
+		}
+	case "==", "!=":
+		if lt != rt || lt == filterList {
+			return nil, mismatch
+		}
+		node.t = filterBool
+		equal := func(l, r filterValue) bool { return l.num == r.num && l.str == r.str && l.b == r.b }
+		if op.text == "==" {
+			node.fn = func(l, r filterValue) filterValue { return filterValue{b: equal(l, r)} }
+		} else {
+			node.fn = func(l, r filterValue) filterValue { return filterValue{b: !equal(l, r)} }
+		}
+	case "<", "<=", ">", ">=":
+		if lt != rt || (lt != filterNumber && lt != filterString) {
+			return nil, mismatch
+		}
+		node.t = filterBool
+		node.fn = func(l, r filterValue) filterValue {
+			c := strings.Compare(l.str, r.str)
+			if l.num < r.num {
+				c = -1
+			} else if l.num > r.num {
+				c = 1
+			}
+			switch op.text {
+			case "<":
+				return filterValue{b: c < 0}
+			case "<=":
+				return filterValue{b: c <= 0}
+			case ">":
+				return filterValue{b: c > 0}
+			default:
+				return filterValue{b: c >= 0}
+			}
+		}
+	case "in":
+		if lt != filterString || (rt != filterString && rt != filterList) {
+			return nil, mismatch
+		}
+		node.t = filterBool
+		node.fn = func(l, r filterValue) filterValue {
+			if rt == filterString {
+				return filterValue{b: strings.Contains(r.str, l.str)}
+			}
+			for _, s := range r.list {
+				if s == l.str {
+					return filterValue{b: true}
+				}
+			}
+			return filterValue{b: false}
+		}
+	case "+", "-", "*", "/":
+		if lt != filterNumber || rt != filterNumber {
+			return nil, mismatch
+		}
+		node.t = filterNumber
+		node.fn = func(l, r filterValue) filterValue {
+			switch op.text {
+			case "+":
+				return filterValue{num: l.num + r.num}
+			case "-":
+				return filterValue{num: l.num - r.num}
+			case "*":
+				return filterValue{num: l.num * r.num}
+			default:
+				return filterValue{num: l.num / r.num}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("column %d: unknown operator '%s'", op.pos, op.text)
+	}
+	return node, nil
+}
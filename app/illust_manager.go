@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -45,12 +46,113 @@ type IllustInfoManager interface {
 	IsIllustExist(pid string) (bool, error)
 	IsIllustPageExist(pid string, page int) (bool, error)
 	SaveIllust(illust *pixiv.IllustInfo, hash string, filename string) error
+	// SaveIllustStatus record the illust page which is not downloaded, e.g. not found or failed, the downloaded or
+	// duplicate illust page is kept as it is
+	SaveIllustStatus(illust *pixiv.IllustInfo, status string) error
 	GetIllustInfo(pid string, page int) (*pixiv.IllustInfo, error)
-	CheckDatabaseAndFile() error
+	// ListIllusts return the illust pages with the status, or all the illust pages if status is empty
+	ListIllusts(status string) ([]*IllustRecord, error)
+	CheckDatabaseAndFile(options *CheckOptions) (*CheckResult, error)
+	// SaveIllustPhash record the perceptual hash of the downloaded illust page, see DHash
+	SaveIllustPhash(pid string, page int, phash string) error
+	// ListIllustPhashes return all the downloaded illust pages, include the ones without hash
+	ListIllustPhashes() ([]*IllustPhash, error)
+	// GetFileOwner return the illust page or novel saved as the filename, nil if the file is not recorded
+	GetFileOwner(filename string) (*FileOwner, error)
+
+	// IsNovelExist return true if the novel is done, the same as IsIllustExist
+	IsNovelExist(id string) (bool, error)
+	SaveNovel(novel *NovelInfo, hash, filename, coverFilename string) error
+	// SaveNovelStatus record the novel which is not downloaded, e.g. not found or failed
+	SaveNovelStatus(novel *NovelInfo, status string) error
+
+	// SaveQueueJob save the pending input of a worker, so that it can be resumed after restart
+	SaveQueueJob(job *QueueJob) error
+	DeleteQueueJob(downloader, queue, key string) error
+	ListQueueJobs(downloader, queue string) ([]*QueueJob, error)
+	// SavePageCursor save the next page offset of the user bookmarks or following
+	SavePageCursor(downloader, uid string, offset int32) error
+	// GetPageCursor return 0 if no cursor saved
+	GetPageCursor(downloader, uid string) (int32, error)
+	DeletePageCursor(downloader, uid string) error
+
+	// GetBookmarkMarker return nil if the bookmarks of the user have never been scanned
+	GetBookmarkMarker(uid string) (*BookmarkMarker, error)
+	SaveBookmarkMarker(marker *BookmarkMarker) error
+
+	// GetRankingMarker return the date of the last downloaded ranking of the mode, empty if never downloaded
+	GetRankingMarker(mode string) (string, error)
+	SaveRankingMarker(mode, date string) error
+	// SaveIllustRank record the position of the illust in the ranking
+	SaveIllustRank(rank *IllustRank) error
+
+	// SaveIllustSeries record the manga series which the illust belongs to
+	SaveIllustSeries(episode *SeriesEpisode) error
+	// GetIllustSeries return nil if the illust is not in any recorded series
+	GetIllustSeries(pid string) (*SeriesEpisode, error)
+
+	// SaveIllustBookmark record the visibility and the bookmark tags of the illust bookmarked by the user
+	SaveIllustBookmark(bookmark *IllustBookmark) error
+
+	Close() error
 }
 
+// BookmarkMarker record the newest bookmark seen by last scan of the user, the bookmarks are newest-first so that
+// all the bookmarks after it have been seen
+type BookmarkMarker struct {
+	Uid              string
+	LastSeenPid      string
+	LastFullScanTime time.Time
+}
+
+// the status of the illust page in database
 const (
-	createTableSQL = `
+	IllustStatusOk         = "ok"
+	IllustStatusNotFound   = "not_found"
+	IllustStatusRestricted = "restricted" // the urls are empty, e.g. R-18 without login or visible to mypixiv only
+	IllustStatusFailed     = "failed"     // give up after max retries
+	IllustStatusDuplicate  = "duplicate"  // skipped as the near duplicate of a downloaded illust, see 'skip-near-duplicate'
+)
+
+func IsValidIllustStatus(status string) bool {
+	return status == IllustStatusOk || status == IllustStatusNotFound || status == IllustStatusRestricted ||
+		status == IllustStatusFailed || status == IllustStatusDuplicate
+}
+
+// IllustRecord is an illust page in database
+type IllustRecord struct {
+	Pid        string
+	Page       int
+	Title      string
+	UserId     string
+	UserName   string
+	Filename   string
+	Status     string
+	StatusTime time.Time
+}
+
+// FileOwner is the illust page or novel which the downloaded file belongs to, Page is always 0 for the novel
+type FileOwner struct {
+	Id    string
+	Page  int
+	Novel bool
+}
+
+// isIllustStatusDone return true if the illust page should not be processed again, the not found and restricted
+// illust are re-checked after 'recheck-interval-sec' and the failed illust are always retried
+func isIllustStatusDone(status string, statusTime time.Time, recheckInterval time.Duration) bool {
+	switch status {
+	case IllustStatusOk, IllustStatusDuplicate:
+		return true
+	case IllustStatusNotFound, IllustStatusRestricted:
+		return recheckInterval <= 0 || time.Since(statusTime) < recheckInterval
+	default:
+		return false
+	}
+}
+
+const (
+	sqliteCreateTableSQL = `
 	CREATE TABLE IF NOT EXISTS illust (
     	pid VARCHAR(64) NOT NULL, 
     	page int NOT NULL DEFAULT 0,
@@ -79,12 +181,206 @@ const (
     )`
 
 	illustCntSql        = "SELECT COUNT(1) FROM illust WHERE pid = ?"
-	illustPageCntSql    = "SELECT COUNT(1) FROM illust WHERE pid = ? AND page = ?"
-	getIllustPageCntSql = "SELECT MAX(page_count) FROM illust WHERE pid = ?"
-	saveIllustSql       = "REPLACE INTO illust VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
-	getIllustSql        = "SELECT * FROM illust WHERE pid = ? AND page = ?"
+	illustStatusSql     = "SELECT page_count, status, status_time FROM illust WHERE pid = ?"
+	illustPageStatusSql = "SELECT status, status_time FROM illust WHERE pid = ? AND page = ?"
+	saveIllustSql       = "REPLACE INTO illust (" + illustColumns + ", status, status_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, CURRENT_TIMESTAMP)"
+	// insertIllustStatusSql is saveIllustSql without replacing the exist illust page, the upsert is appended by the
+	// database, see sqliteSaveIllustStatusSql
+	insertIllustStatusSql = "INSERT INTO illust (" + illustColumns + ", status, status_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, CURRENT_TIMESTAMP)"
+	illustStatusDoneSql   = "('" + IllustStatusOk + "', '" + IllustStatusDuplicate + "')"
+	getIllustSql          = "SELECT " + illustColumns + " FROM illust WHERE pid = ? AND page = ?"
+	listIllustFilesSql    = "SELECT pid, page, sha1, filename FROM illust"
+	listIllustsSql        = "SELECT pid, page, title, user_id, user_name, filename, status, status_time FROM illust"
+	deleteIllustPageSql   = "DELETE FROM illust WHERE pid = ? AND page = ?"
+	saveIllustPhashSql    = "UPDATE illust SET phash = ? WHERE pid = ? AND page = ?"
+	listIllustPhashSql    = "SELECT pid, page, user_id, filename, phash FROM illust WHERE status = 'ok' ORDER BY pid, page"
+	illustFileOwnerSql    = "SELECT pid, page FROM illust WHERE filename = ? LIMIT 1"
+
+	illustColumns = "pid, page, title, url, r18, tags, description, width, height, page_count, bookmarks_count, like_count, " +
+		"comment_count, view_count, create_date, upload_date, user_id, user_name, user_account, sha1, filename, created_time, updated_time, " +
+		"format"
+
+	sqliteCreateQueueTableSql = `
+	CREATE TABLE IF NOT EXISTS job_queue (
+		downloader VARCHAR(32) NOT NULL,
+		queue_name VARCHAR(32) NOT NULL,
+		job_key VARCHAR(128) NOT NULL,
+		payload TEXT NOT NULL,
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(downloader, queue_name, job_key)
+	)`
+	sqliteCreateCursorTableSql = `
+	CREATE TABLE IF NOT EXISTS page_cursor (
+		downloader VARCHAR(32) NOT NULL,
+		uid VARCHAR(64) NOT NULL,
+		page_offset int NOT NULL DEFAULT 0,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(downloader, uid)
+	)`
+
+	sqliteCreateMarkerTableSql = `
+	CREATE TABLE IF NOT EXISTS bookmark_marker (
+		uid VARCHAR(64) NOT NULL,
+		last_seen_pid VARCHAR(64) NOT NULL DEFAULT '',
+		last_full_scan_time DATETIME NOT NULL DEFAULT '1970-01-01',
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(uid)
+	)`
+
+	saveQueueJobSql     = "REPLACE INTO job_queue (downloader, queue_name, job_key, payload, created_time) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)"
+	deleteQueueJobSql   = "DELETE FROM job_queue WHERE downloader = ? AND queue_name = ? AND job_key = ?"
+	listQueueJobsSql    = "SELECT job_key, payload FROM job_queue WHERE downloader = ? AND queue_name = ? ORDER BY created_time"
+	savePageCursorSql   = "REPLACE INTO page_cursor (downloader, uid, page_offset, updated_time) VALUES (?, ?, ?, CURRENT_TIMESTAMP)"
+	getPageCursorSql    = "SELECT page_offset FROM page_cursor WHERE downloader = ? AND uid = ?"
+	deletePageCursorSql = "DELETE FROM page_cursor WHERE downloader = ? AND uid = ?"
+
+	getBookmarkMarkerSql  = "SELECT last_seen_pid, last_full_scan_time FROM bookmark_marker WHERE uid = ?"
+	saveBookmarkMarkerSql = "REPLACE INTO bookmark_marker (uid, last_seen_pid, last_full_scan_time, updated_time) VALUES (?, ?, ?, CURRENT_TIMESTAMP)"
+
+	// addFormatColumnSql record the file format, e.g. 'jpg', 'png' and the ugoira format 'zip', 'gif'
+	addFormatColumnSql = "ALTER TABLE illust ADD COLUMN format VARCHAR(16) NOT NULL DEFAULT ''"
+
+	// the not found illust used to be recorded with the title 'NOT FOUND' and empty sha1
+	addStatusColumnSql           = "ALTER TABLE illust ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'ok'"
+	sqliteAddStatusTimeColumnSql = "ALTER TABLE illust ADD COLUMN status_time DATETIME NOT NULL DEFAULT '1970-01-01'"
+	initStatusSql                = "UPDATE illust SET status = 'not_found', title = '' WHERE title = 'NOT FOUND' AND sha1 = '' AND filename = ''"
+	initStatusTimeSql            = "UPDATE illust SET status_time = updated_time"
+	createStatusIndexSql         = "CREATE INDEX idx_illust_status ON illust (status)"
+
+	// addPhashColumnSql record the DHash of the downloaded illust page in 16 hex digits
+	addPhashColumnSql = "ALTER TABLE illust ADD COLUMN phash VARCHAR(16) NOT NULL DEFAULT ''"
+
+	// the filename index is used to find the owner of the exist file when the filenames collide
+	sqliteCreateIllustFilenameIndexSql = "CREATE INDEX idx_illust_filename ON illust (filename)"
+	sqliteCreateNovelFilenameIndexSql  = "CREATE INDEX idx_novel_filename ON novel (filename)"
+)
+
+const (
+	sqliteCreateNovelTableSql = `
+	CREATE TABLE IF NOT EXISTS novel (
+		id VARCHAR(64) NOT NULL,
+		title VARCHAR(255) NOT NULL DEFAULT '',
+		r18 int NOT NULL DEFAULT 0,
+		tags TEXT,
+		description TEXT,
+		language VARCHAR(16) NOT NULL DEFAULT '',
+		text_count int NOT NULL DEFAULT 0,
+		bookmarks_count int NOT NULL DEFAULT 0,
+		like_count int NOT NULL DEFAULT 0,
+		view_count int NOT NULL DEFAULT 0,
+		series_id VARCHAR(64) NOT NULL DEFAULT '',
+		series_title VARCHAR(255) NOT NULL DEFAULT '',
+		create_date DATETIME NOT NULL DEFAULT '1970-01-01',
+		upload_date DATETIME NOT NULL DEFAULT '1970-01-01',
+		user_id VARCHAR(64) NOT NULL DEFAULT '',
+		user_name VARCHAR(128) NOT NULL DEFAULT '',
+		sha1 VARCHAR(256) NOT NULL DEFAULT '',
+		filename VARCHAR(256) NOT NULL DEFAULT '',
+		cover_filename VARCHAR(256) NOT NULL DEFAULT '',
+		format VARCHAR(16) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL DEFAULT 'ok',
+		status_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(id)
+	)`
+
+	novelColumns = "id, title, r18, tags, description, language, text_count, bookmarks_count, like_count, view_count, " +
+		"series_id, series_title, create_date, upload_date, user_id, user_name, sha1, filename, cover_filename, format, status"
+
+	novelStatusSql    = "SELECT status, status_time FROM novel WHERE id = ?"
+	saveNovelSql      = "REPLACE INTO novel (" + novelColumns + ", status_time, created_time, updated_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
+	listNovelFilesSql = "SELECT id, sha1, filename, cover_filename FROM novel"
+	novelFileOwnerSql = "SELECT id FROM novel WHERE filename = ? LIMIT 1"
+	deleteNovelSql    = "DELETE FROM novel WHERE id = ?"
+)
+
+const (
+	sqliteCreateRankingMarkerTableSql = `
+	CREATE TABLE IF NOT EXISTS ranking_marker (
+		mode VARCHAR(32) NOT NULL,
+		last_date VARCHAR(16) NOT NULL DEFAULT '',
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(mode)
+	)`
+	sqliteCreateIllustRankTableSql = `
+	CREATE TABLE IF NOT EXISTS illust_rank (
+		mode VARCHAR(32) NOT NULL,
+		rank_date VARCHAR(16) NOT NULL,
+		rank_no int NOT NULL,
+		pid VARCHAR(64) NOT NULL,
+		user_id VARCHAR(64) NOT NULL DEFAULT '',
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(mode, rank_date, rank_no)
+	)`
+	createIllustRankIndexSql = "CREATE INDEX idx_illust_rank_pid ON illust_rank (pid)"
+
+	getRankingMarkerSql  = "SELECT last_date FROM ranking_marker WHERE mode = ?"
+	saveRankingMarkerSql = "REPLACE INTO ranking_marker (mode, last_date, updated_time) VALUES (?, ?, CURRENT_TIMESTAMP)"
+	saveIllustRankSql    = "REPLACE INTO illust_rank (mode, rank_date, rank_no, pid, user_id, created_time) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)"
+)
+
+const (
+	sqliteCreateIllustSeriesTableSql = `
+	CREATE TABLE IF NOT EXISTS illust_series (
+		pid VARCHAR(64) NOT NULL,
+		series_id VARCHAR(64) NOT NULL,
+		series_title VARCHAR(255) NOT NULL DEFAULT '',
+		episode int NOT NULL DEFAULT 0,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(pid)
+	)`
+	createIllustSeriesIndexSql = "CREATE INDEX idx_illust_series_id ON illust_series (series_id)"
+
+	saveIllustSeriesSql = "REPLACE INTO illust_series (pid, series_id, series_title, episode, updated_time) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)"
+	getIllustSeriesSql  = "SELECT series_id, series_title, episode FROM illust_series WHERE pid = ?"
 )
 
+const (
+	sqliteCreateIllustBookmarkTableSql = `
+	CREATE TABLE IF NOT EXISTS illust_bookmark (
+		pid VARCHAR(64) NOT NULL,
+		uid VARCHAR(64) NOT NULL,
+		private int NOT NULL DEFAULT 0,
+		tags TEXT,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(pid, uid)
+	)`
+
+	saveIllustBookmarkSql = "REPLACE INTO illust_bookmark (pid, uid, private, tags, updated_time) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)"
+)
+
+var sqliteMigrations = []schemaMigration{
+	{version: 1, stmts: []string{sqliteCreateTableSQL}},
+	{version: 2, stmts: []string{addFormatColumnSql}},
+	{version: 3, stmts: []string{sqliteCreateQueueTableSql, sqliteCreateCursorTableSql}},
+	{version: 4, stmts: []string{sqliteCreateMarkerTableSql}},
+	{version: 5, stmts: []string{addStatusColumnSql, sqliteAddStatusTimeColumnSql, initStatusSql, initStatusTimeSql, createStatusIndexSql}},
+	{version: 6, stmts: []string{sqliteCreateNovelTableSql}},
+	{version: 7, stmts: []string{sqliteCreateRankingMarkerTableSql, sqliteCreateIllustRankTableSql, createIllustRankIndexSql}},
+	{version: 8, stmts: []string{sqliteCreateIllustSeriesTableSql, createIllustSeriesIndexSql}},
+	{version: 9, stmts: []string{sqliteCreateIllustBookmarkTableSql}},
+	{version: 10, stmts: []string{addPhashColumnSql}},
+	{version: 11, stmts: []string{sqliteCreateIllustFilenameIndexSql, sqliteCreateNovelFilenameIndexSql}},
+}
+
+// illustStatusUpdateColumns is the columns updated by SaveIllustStatus if the illust page exists, status is the last
+// one because MySQL evaluates the assignments in order and the others are guarded by the old status
+var illustStatusUpdateColumns = []string{"title", "url", "r18", "tags", "description", "width", "height", "page_count",
+	"bookmarks_count", "like_count", "comment_count", "view_count", "create_date", "upload_date", "user_id", "user_name",
+	"user_account", "sha1", "filename", "format", "updated_time", "status_time", "status"}
+
+// sqliteSaveIllustStatusSql return the upsert which never replaces the done illust page with the not downloaded
+// status, e.g. the downloaded page of a multi-page illust whose other pages are not found later
+func sqliteSaveIllustStatusSql() string {
+	sets := make([]string, 0, len(illustStatusUpdateColumns))
+	for _, column := range illustStatusUpdateColumns {
+		sets = append(sets, column+" = excluded."+column)
+	}
+	return insertIllustStatusSql + " ON CONFLICT(pid, page) DO UPDATE SET " + strings.Join(sets, ", ") +
+		" WHERE illust.status NOT IN " + illustStatusDoneSql
+}
+
 func GetIllustInfoManager(options *PixivDlOptions) (IllustInfoManager, error) {
 	dbType := GetDatabaseType(options.DatabaseType)
 	switch dbType {
@@ -92,6 +388,8 @@ func GetIllustInfoManager(options *PixivDlOptions) (IllustInfoManager, error) {
 		return NewDummyIllustInfoMgr(), nil
 	case DatabaseTypeSqlite:
 		return NewSqliteIllustInfoMgr(options), nil
+	case DatabaseTypeMysql:
+		return NewMysqlIllustInfoMgr(options), nil
 	default:
 		log.Errorf("Not supported database type '%s'", options.DatabaseType)
 	}
@@ -122,59 +420,124 @@ func (d *DummyIllustInfoMgr) SaveIllust(*pixiv.IllustInfo, string, string) error
 	return nil
 }
 
+func (d *DummyIllustInfoMgr) SaveIllustStatus(*pixiv.IllustInfo, string) error {
+	return nil
+}
+
 func (d *DummyIllustInfoMgr) GetIllustInfo(string, int) (*pixiv.IllustInfo, error) {
 	return nil, errors.New("not found")
 }
 
-func (d *DummyIllustInfoMgr) CheckDatabaseAndFile() error {
+func (d *DummyIllustInfoMgr) ListIllusts(string) ([]*IllustRecord, error) {
+	return nil, nil
+}
+
+func (d *DummyIllustInfoMgr) CheckDatabaseAndFile(*CheckOptions) (*CheckResult, error) {
+	return &CheckResult{}, nil
+}
+
+func (d *DummyIllustInfoMgr) SaveIllustPhash(string, int, string) error {
 	return nil
 }
 
-type SqliteIllustInfoMgr struct {
-	db *sql.DB
+func (d *DummyIllustInfoMgr) ListIllustPhashes() ([]*IllustPhash, error) {
+	return nil, nil
 }
 
-func NewSqliteIllustInfoMgr(options *PixivDlOptions) *SqliteIllustInfoMgr {
-	err := CheckAndMkdir(options.SqlitePath)
-	if err != nil {
-		log.Fatalf("Failed to create database dir, msg: %s", err)
-	}
+func (d *DummyIllustInfoMgr) GetFileOwner(string) (*FileOwner, error) {
+	return nil, nil
+}
 
-	db, err := sql.Open("sqlite", filepath.Join(options.SqlitePath, "pixiv.db"))
-	if err != nil {
-		log.Fatalf("Failed to open illustMgr, msg: %s", err)
-	}
+func (d *DummyIllustInfoMgr) IsNovelExist(string) (bool, error) {
+	return false, nil
+}
 
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
-		log.Fatalf("Failed to create table, msg: %s", err)
-	}
+func (d *DummyIllustInfoMgr) SaveNovel(*NovelInfo, string, string, string) error {
+	return nil
+}
 
-	return &SqliteIllustInfoMgr{db: db}
+func (d *DummyIllustInfoMgr) SaveNovelStatus(*NovelInfo, string) error {
+	return nil
 }
 
-func (ps *SqliteIllustInfoMgr) GetIllustCount(id string) (int32, error) {
-	rows, err := ps.db.Query(illustCntSql, id)
-	if err != nil {
-		return 0, err
-	}
-	defer func() {
-		_ = rows.Close()
-	}()
+func (d *DummyIllustInfoMgr) SaveQueueJob(*QueueJob) error {
+	return nil
+}
 
-	var count int32 = 0
-	for rows.Next() {
-		err := rows.Scan(&count)
-		if err != nil {
-			return 0, err
-		}
-	}
+func (d *DummyIllustInfoMgr) DeleteQueueJob(string, string, string) error {
+	return nil
+}
 
-	return count, nil
+func (d *DummyIllustInfoMgr) ListQueueJobs(string, string) ([]*QueueJob, error) {
+	return nil, nil
 }
 
-func (ps *SqliteIllustInfoMgr) getIllustPageCnt(pid string) (int32, error) {
-	rows, err := ps.db.Query(getIllustPageCntSql, pid)
+func (d *DummyIllustInfoMgr) SavePageCursor(string, string, int32) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) GetPageCursor(string, string) (int32, error) {
+	return 0, nil
+}
+
+func (d *DummyIllustInfoMgr) DeletePageCursor(string, string) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) GetBookmarkMarker(string) (*BookmarkMarker, error) {
+	return nil, nil
+}
+
+func (d *DummyIllustInfoMgr) SaveBookmarkMarker(*BookmarkMarker) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) GetRankingMarker(string) (string, error) {
+	return "", nil
+}
+
+func (d *DummyIllustInfoMgr) SaveRankingMarker(string, string) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) SaveIllustRank(*IllustRank) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) SaveIllustSeries(*SeriesEpisode) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) GetIllustSeries(string) (*SeriesEpisode, error) {
+	return nil, nil
+}
+
+func (d *DummyIllustInfoMgr) SaveIllustBookmark(*IllustBookmark) error {
+	return nil
+}
+
+func (d *DummyIllustInfoMgr) Close() error {
+	return nil
+}
+
+// sqlIllustInfoMgr implement IllustInfoManager with database/sql, it's shared by all the sql database
+type sqlIllustInfoMgr struct {
+	db              *sql.DB
+	recheckInterval time.Duration
+	// saveIllustStatusSql is the upsert of SaveIllustStatus, the syntax is different in every database
+	saveIllustStatusSql string
+}
+
+func newSqlIllustInfoMgr(db *sql.DB, options *PixivDlOptions, saveIllustStatusSql string) *sqlIllustInfoMgr {
+	return &sqlIllustInfoMgr{
+		db:                  db,
+		recheckInterval:     time.Duration(options.RecheckIntervalSec) * time.Second,
+		saveIllustStatusSql: saveIllustStatusSql,
+	}
+}
+
+func (ps *sqlIllustInfoMgr) GetIllustCount(id string) (int32, error) {
+	rows, err := ps.db.Query(illustCntSql, id)
 	if err != nil {
 		return 0, err
 	}
@@ -189,11 +552,13 @@ func (ps *SqliteIllustInfoMgr) getIllustPageCnt(pid string) (int32, error) {
 			return 0, err
 		}
 	}
+
 	return count, nil
 }
 
-func (ps *SqliteIllustInfoMgr) IsIllustExist(pid string) (bool, error) {
-	rows, err := ps.db.Query(illustCntSql, pid)
+// IsIllustExist return true if all the pages of the illust are done, see isIllustStatusDone
+func (ps *sqlIllustInfoMgr) IsIllustExist(pid string) (bool, error) {
+	rows, err := ps.db.Query(illustStatusSql, pid)
 	if err != nil {
 		return false, err
 	}
@@ -201,57 +566,76 @@ func (ps *SqliteIllustInfoMgr) IsIllustExist(pid string) (bool, error) {
 		_ = rows.Close()
 	}()
 
-	var count int32 = 0
+	var doneCount, pageCount int32 = 0, 0
 	for rows.Next() {
-		err := rows.Scan(&count)
+		var (
+			count      int32
+			status     string
+			statusTime time.Time
+		)
+		err := rows.Scan(&count, &status, &statusTime)
 		if err != nil {
 			return false, err
 		}
+		if count > pageCount {
+			pageCount = count
+		}
+		if isIllustStatusDone(status, statusTime, ps.recheckInterval) {
+			doneCount++
+		}
 	}
-	if count == 0 {
-		return false, nil
-	}
-
-	pageCount, err := ps.getIllustPageCnt(pid)
-	if err != nil {
+	if err = rows.Err(); err != nil {
 		return false, err
 	}
-	return count == pageCount, nil
+	return doneCount > 0 && doneCount == pageCount, nil
 }
 
-func (ps *SqliteIllustInfoMgr) IsIllustPageExist(pid string, page int) (bool, error) {
-	rows, err := ps.db.Query(illustPageCntSql, pid, page)
+func (ps *sqlIllustInfoMgr) IsIllustPageExist(pid string, page int) (bool, error) {
+	var (
+		status     string
+		statusTime time.Time
+	)
+	err := ps.db.QueryRow(illustPageStatusSql, pid, page).Scan(&status, &statusTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
-	defer func() {
-		_ = rows.Close()
-	}()
+	return isIllustStatusDone(status, statusTime, ps.recheckInterval), nil
+}
 
-	var count int32 = 0
-	for rows.Next() {
-		err := rows.Scan(&count)
-		if err != nil {
-			return false, err
-		}
+// dateValue map the zero time to the column default, the zero time is bound as '0000-00-00 00:00:00' which is
+// rejected by MySQL in the strict mode, e.g. the not found illust has no create date
+func dateValue(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Unix(0, 0).UTC()
 	}
-
-	return count == 1, nil
+	return t
 }
 
-func (ps *SqliteIllustInfoMgr) SaveIllust(illust *pixiv.IllustInfo, hash string, filename string) error {
+func (ps *sqlIllustInfoMgr) SaveIllust(illust *pixiv.IllustInfo, hash string, filename string) error {
 	tags, _ := json.Marshal(illust.Tags)
 	_, err := ps.db.Exec(saveIllustSql,
 		illust.Id, illust.PageIdx, illust.Title, illust.Urls.Original, illust.R18, tags, illust.Description, illust.Width, illust.Height,
-		illust.PageCount, illust.BookmarkCount, illust.LikeCount, illust.CommentCount, illust.ViewCount, illust.CreateDate, illust.UploadDate,
-		illust.UserId, illust.UserName, illust.UserAccount, hash, filename)
+		illust.PageCount, illust.BookmarkCount, illust.LikeCount, illust.CommentCount, illust.ViewCount,
+		dateValue(illust.CreateDate), dateValue(illust.UploadDate), illust.UserId, illust.UserName, illust.UserAccount, hash, filename, FileFormat(filename), IllustStatusOk)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (ps *SqliteIllustInfoMgr) GetIllustInfo(id string, page int) (*pixiv.IllustInfo, error) {
+func (ps *sqlIllustInfoMgr) SaveIllustStatus(illust *pixiv.IllustInfo, status string) error {
+	tags, _ := json.Marshal(illust.Tags)
+	_, err := ps.db.Exec(ps.saveIllustStatusSql,
+		illust.Id, illust.PageIdx, illust.Title, illust.Urls.Original, illust.R18, tags, illust.Description, illust.Width, illust.Height,
+		illust.PageCount, illust.BookmarkCount, illust.LikeCount, illust.CommentCount, illust.ViewCount,
+		dateValue(illust.CreateDate), dateValue(illust.UploadDate), illust.UserId, illust.UserName, illust.UserAccount, "", "", "", status)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) GetIllustInfo(id string, page int) (*pixiv.IllustInfo, error) {
 	rows, err := ps.db.Query(getIllustSql, id, page)
 	if err != nil {
 		return nil, err
@@ -266,12 +650,13 @@ func (ps *SqliteIllustInfoMgr) GetIllustInfo(id string, page int) (*pixiv.Illust
 		filename string
 		ctime    time.Time
 		utime    time.Time
+		format   string
 	)
 	for rows.Next() {
 		var tags string
 		err := rows.Scan(&illust.Id, &illust.PageIdx, &illust.Title, &illust.Urls.Original, &illust.R18, &tags, &illust.Description, &illust.Width, &illust.Height,
 			&illust.PageCount, &illust.BookmarkCount, &illust.LikeCount, &illust.CommentCount, &illust.ViewCount, &illust.CreateDate, &illust.UploadDate,
-			&illust.UserId, illust.UserName, &illust.UserAccount, &hash, &filename, &ctime, &utime)
+			&illust.UserId, &illust.UserName, &illust.UserAccount, &hash, &filename, &ctime, &utime, &format)
 		if err != nil {
 			return nil, err
 		}
@@ -281,6 +666,302 @@ func (ps *SqliteIllustInfoMgr) GetIllustInfo(id string, page int) (*pixiv.Illust
 	return &illust, nil
 }
 
-func (ps *SqliteIllustInfoMgr) CheckDatabaseAndFile() error {
-	return nil
+func (ps *sqlIllustInfoMgr) ListIllusts(status string) ([]*IllustRecord, error) {
+	query := listIllustsSql
+	var args []interface{}
+	if len(status) > 0 {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	rows, err := ps.db.Query(query+" ORDER BY status_time", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var records []*IllustRecord
+	for rows.Next() {
+		var record IllustRecord
+		err := rows.Scan(&record.Pid, &record.Page, &record.Title, &record.UserId, &record.UserName, &record.Filename,
+			&record.Status, &record.StatusTime)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	return records, rows.Err()
+}
+
+func (ps *sqlIllustInfoMgr) SaveIllustPhash(pid string, page int, phash string) error {
+	_, err := ps.db.Exec(saveIllustPhashSql, phash, pid, page)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) ListIllustPhashes() ([]*IllustPhash, error) {
+	rows, err := ps.db.Query(listIllustPhashSql)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var phashes []*IllustPhash
+	for rows.Next() {
+		var phash IllustPhash
+		err := rows.Scan(&phash.Pid, &phash.Page, &phash.UserId, &phash.Filename, &phash.Phash)
+		if err != nil {
+			return nil, err
+		}
+		phashes = append(phashes, &phash)
+	}
+	return phashes, rows.Err()
+}
+
+func (ps *sqlIllustInfoMgr) GetFileOwner(filename string) (*FileOwner, error) {
+	var owner FileOwner
+	err := ps.db.QueryRow(illustFileOwnerSql, filename).Scan(&owner.Id, &owner.Page)
+	if err == nil {
+		return &owner, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	owner = FileOwner{Novel: true}
+	err = ps.db.QueryRow(novelFileOwnerSql, filename).Scan(&owner.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// listFileRecords return the files of all the illust pages and novels
+func (ps *sqlIllustInfoMgr) listFileRecords() ([]*illustFileRecord, error) {
+	rows, err := ps.db.Query(listIllustFilesSql)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var records []*illustFileRecord
+	for rows.Next() {
+		var record illustFileRecord
+		err := rows.Scan(&record.pid, &record.page, &record.hash, &record.filename)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	novelRows, err := ps.db.Query(listNovelFilesSql)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = novelRows.Close()
+	}()
+	for novelRows.Next() {
+		record := illustFileRecord{novel: true}
+		err := novelRows.Scan(&record.pid, &record.hash, &record.filename, &record.coverFilename)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	return records, novelRows.Err()
+}
+
+func (ps *sqlIllustInfoMgr) deleteFileRecord(record *illustFileRecord) error {
+	if record.novel {
+		_, err := ps.db.Exec(deleteNovelSql, record.pid)
+		return err
+	}
+	_, err := ps.db.Exec(deleteIllustPageSql, record.pid, record.page)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) CheckDatabaseAndFile(options *CheckOptions) (*CheckResult, error) {
+	records, err := ps.listFileRecords()
+	if err != nil {
+		return nil, err
+	}
+	return checkIllustFiles(records, options, ps.deleteFileRecord)
+}
+
+func (ps *sqlIllustInfoMgr) IsNovelExist(id string) (bool, error) {
+	var (
+		status     string
+		statusTime time.Time
+	)
+	err := ps.db.QueryRow(novelStatusSql, id).Scan(&status, &statusTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isIllustStatusDone(status, statusTime, ps.recheckInterval), nil
+}
+
+func (ps *sqlIllustInfoMgr) SaveNovel(novel *NovelInfo, hash, filename, coverFilename string) error {
+	return ps.saveNovel(novel, hash, filename, coverFilename, FileFormat(filename), IllustStatusOk)
+}
+
+func (ps *sqlIllustInfoMgr) SaveNovelStatus(novel *NovelInfo, status string) error {
+	return ps.saveNovel(novel, "", "", "", "", status)
+}
+
+func (ps *sqlIllustInfoMgr) saveNovel(novel *NovelInfo, hash, filename, coverFilename, format, status string) error {
+	tags, _ := json.Marshal(novel.Tags)
+	_, err := ps.db.Exec(saveNovelSql,
+		novel.Id, novel.Title, novel.R18, tags, novel.Description, novel.Language, novel.TextCount, novel.BookmarkCount,
+		novel.LikeCount, novel.ViewCount, novel.SeriesId, novel.SeriesTitle, dateValue(novel.CreateDate), dateValue(novel.UploadDate),
+		novel.UserId, novel.UserName, hash, filename, coverFilename, format, status)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) SaveQueueJob(job *QueueJob) error {
+	_, err := ps.db.Exec(saveQueueJobSql, job.Downloader, job.Queue, job.Key, job.Payload)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) DeleteQueueJob(downloader, queue, key string) error {
+	_, err := ps.db.Exec(deleteQueueJobSql, downloader, queue, key)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) ListQueueJobs(downloader, queue string) ([]*QueueJob, error) {
+	rows, err := ps.db.Query(listQueueJobsSql, downloader, queue)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var jobs []*QueueJob
+	for rows.Next() {
+		job := QueueJob{Downloader: downloader, Queue: queue}
+		err := rows.Scan(&job.Key, &job.Payload)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+func (ps *sqlIllustInfoMgr) SavePageCursor(downloader, uid string, offset int32) error {
+	_, err := ps.db.Exec(savePageCursorSql, downloader, uid, offset)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) GetPageCursor(downloader, uid string) (int32, error) {
+	var offset int32
+	err := ps.db.QueryRow(getPageCursorSql, downloader, uid).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return offset, err
+}
+
+func (ps *sqlIllustInfoMgr) DeletePageCursor(downloader, uid string) error {
+	_, err := ps.db.Exec(deletePageCursorSql, downloader, uid)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) GetBookmarkMarker(uid string) (*BookmarkMarker, error) {
+	marker := BookmarkMarker{Uid: uid}
+	err := ps.db.QueryRow(getBookmarkMarkerSql, uid).Scan(&marker.LastSeenPid, &marker.LastFullScanTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &marker, nil
+}
+
+func (ps *sqlIllustInfoMgr) SaveBookmarkMarker(marker *BookmarkMarker) error {
+	_, err := ps.db.Exec(saveBookmarkMarkerSql, marker.Uid, marker.LastSeenPid, marker.LastFullScanTime)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) GetRankingMarker(mode string) (string, error) {
+	var date string
+	err := ps.db.QueryRow(getRankingMarkerSql, mode).Scan(&date)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return date, err
+}
+
+func (ps *sqlIllustInfoMgr) SaveRankingMarker(mode, date string) error {
+	_, err := ps.db.Exec(saveRankingMarkerSql, mode, date)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) SaveIllustRank(rank *IllustRank) error {
+	_, err := ps.db.Exec(saveIllustRankSql, rank.Mode, rank.Date, rank.Rank, rank.Pid, rank.UserId)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) SaveIllustSeries(episode *SeriesEpisode) error {
+	_, err := ps.db.Exec(saveIllustSeriesSql, episode.Pid, episode.SeriesId, episode.SeriesTitle, episode.Episode)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) GetIllustSeries(pid string) (*SeriesEpisode, error) {
+	episode := SeriesEpisode{Pid: pid}
+	err := ps.db.QueryRow(getIllustSeriesSql, pid).Scan(&episode.SeriesId, &episode.SeriesTitle, &episode.Episode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &episode, nil
+}
+
+func (ps *sqlIllustInfoMgr) SaveIllustBookmark(bookmark *IllustBookmark) error {
+	tags, _ := json.Marshal(bookmark.Tags)
+	_, err := ps.db.Exec(saveIllustBookmarkSql, bookmark.Pid, bookmark.Uid, bookmark.Private, tags)
+	return err
+}
+
+func (ps *sqlIllustInfoMgr) Close() error {
+	return ps.db.Close()
+}
+
+type SqliteIllustInfoMgr struct {
+	*sqlIllustInfoMgr
+}
+
+func NewSqliteIllustInfoMgr(options *PixivDlOptions) *SqliteIllustInfoMgr {
+	err := CheckAndMkdir(options.SqlitePath)
+	if err != nil {
+		log.Fatalf("Failed to create database dir, msg: %s", err)
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(options.SqlitePath, "pixiv.db"))
+	if err != nil {
+		log.Fatalf("Failed to open illustMgr, msg: %s", err)
+	}
+
+	err = migrateSchema(context.Background(), db, sqliteMigrations)
+	if err != nil {
+		log.Fatalf("Failed to migrate database schema, msg: %s", err)
+	}
+
+	return &SqliteIllustInfoMgr{sqlIllustInfoMgr: newSqlIllustInfoMgr(db, options, sqliteSaveIllustStatusSql())}
 }
@@ -0,0 +1,238 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mysqlCreateTableSQL = `
+	CREATE TABLE IF NOT EXISTS illust (
+		pid VARCHAR(64) NOT NULL,
+		page int NOT NULL DEFAULT 0,
+		title VARCHAR(255) NOT NULL DEFAULT '',
+		url VARCHAR(512) NOT NULL,
+		r18 int NOT NULL DEFAULT 0,
+		tags TEXT,
+		description TEXT,
+		width int NOT NULL DEFAULT 0,
+		height int NOT NULL DEFAULT 0,
+		page_count int NOT NULL DEFAULT 1,
+		bookmarks_count int NOT NULL DEFAULT 0,
+		like_count int NOT NULL DEFAULT 0,
+		comment_count int NOT NULL DEFAULT 0,
+		view_count int NOT NULL DEFAULT 0,
+		create_date DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+		upload_date DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+		user_id VARCHAR(64) NOT NULL DEFAULT '',
+		user_name VARCHAR(128) NOT NULL DEFAULT '',
+		user_account VARCHAR(64) NOT NULL DEFAULT '',
+		sha1 VARCHAR(256) NOT NULL,
+		filename VARCHAR(256) NOT NULL,
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(pid, page)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+	mysqlCreateQueueTableSql = `
+	CREATE TABLE IF NOT EXISTS job_queue (
+		downloader VARCHAR(32) NOT NULL,
+		queue_name VARCHAR(32) NOT NULL,
+		job_key VARCHAR(128) NOT NULL,
+		payload MEDIUMTEXT NOT NULL,
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(downloader, queue_name, job_key)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	mysqlCreateCursorTableSql = `
+	CREATE TABLE IF NOT EXISTS page_cursor (
+		downloader VARCHAR(32) NOT NULL,
+		uid VARCHAR(64) NOT NULL,
+		page_offset int NOT NULL DEFAULT 0,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(downloader, uid)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+	mysqlCreateMarkerTableSql = `
+	CREATE TABLE IF NOT EXISTS bookmark_marker (
+		uid VARCHAR(64) NOT NULL,
+		last_seen_pid VARCHAR(64) NOT NULL DEFAULT '',
+		last_full_scan_time DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(uid)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+	mysqlCreateNovelTableSql = `
+	CREATE TABLE IF NOT EXISTS novel (
+		id VARCHAR(64) NOT NULL,
+		title VARCHAR(255) NOT NULL DEFAULT '',
+		r18 int NOT NULL DEFAULT 0,
+		tags TEXT,
+		description TEXT,
+		language VARCHAR(16) NOT NULL DEFAULT '',
+		text_count int NOT NULL DEFAULT 0,
+		bookmarks_count int NOT NULL DEFAULT 0,
+		like_count int NOT NULL DEFAULT 0,
+		view_count int NOT NULL DEFAULT 0,
+		series_id VARCHAR(64) NOT NULL DEFAULT '',
+		series_title VARCHAR(255) NOT NULL DEFAULT '',
+		create_date DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+		upload_date DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00',
+		user_id VARCHAR(64) NOT NULL DEFAULT '',
+		user_name VARCHAR(128) NOT NULL DEFAULT '',
+		sha1 VARCHAR(256) NOT NULL DEFAULT '',
+		filename VARCHAR(256) NOT NULL DEFAULT '',
+		cover_filename VARCHAR(256) NOT NULL DEFAULT '',
+		format VARCHAR(16) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL DEFAULT 'ok',
+		status_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+	mysqlCreateRankingMarkerTableSql = `
+	CREATE TABLE IF NOT EXISTS ranking_marker (
+		mode VARCHAR(32) NOT NULL,
+		last_date VARCHAR(16) NOT NULL DEFAULT '',
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(mode)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	mysqlCreateIllustRankTableSql = `
+	CREATE TABLE IF NOT EXISTS illust_rank (
+		mode VARCHAR(32) NOT NULL,
+		rank_date VARCHAR(16) NOT NULL,
+		rank_no int NOT NULL,
+		pid VARCHAR(64) NOT NULL,
+		user_id VARCHAR(64) NOT NULL DEFAULT '',
+		created_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(mode, rank_date, rank_no)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+	mysqlCreateIllustSeriesTableSql = `
+	CREATE TABLE IF NOT EXISTS illust_series (
+		pid VARCHAR(64) NOT NULL,
+		series_id VARCHAR(64) NOT NULL,
+		series_title VARCHAR(255) NOT NULL DEFAULT '',
+		episode int NOT NULL DEFAULT 0,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(pid)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+	mysqlCreateIllustBookmarkTableSql = `
+	CREATE TABLE IF NOT EXISTS illust_bookmark (
+		pid VARCHAR(64) NOT NULL,
+		uid VARCHAR(64) NOT NULL,
+		private int NOT NULL DEFAULT 0,
+		tags TEXT,
+		updated_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(pid, uid)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+	// the cursor and marker key of the bookmarks scope and the search keyword may be longer than the uid
+	mysqlWidenCursorUidSql = "ALTER TABLE page_cursor MODIFY uid VARCHAR(255) NOT NULL"
+	mysqlWidenMarkerUidSql = "ALTER TABLE bookmark_marker MODIFY uid VARCHAR(255) NOT NULL"
+
+	// the prefix index is enough to find the file owner and fits the max key length of the old InnoDB
+	mysqlCreateIllustFilenameIndexSql = "CREATE INDEX idx_illust_filename ON illust (filename(191))"
+	mysqlCreateNovelFilenameIndexSql  = "CREATE INDEX idx_novel_filename ON novel (filename(191))"
+
+	mysqlAddStatusTimeColumnSql = "ALTER TABLE illust ADD COLUMN status_time DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00'"
+
+	// mysqlMigrationLock is a named lock to prevent multi instance migrate the schema at the same time
+	mysqlMigrationLock        = "pixiv_dl_schema_migration"
+	mysqlMigrationLockTimeout = 60
+)
+
+var mysqlMigrations = []schemaMigration{
+	{version: 1, stmts: []string{mysqlCreateTableSQL}},
+	{version: 2, stmts: []string{addFormatColumnSql}},
+	{version: 3, stmts: []string{mysqlCreateQueueTableSql, mysqlCreateCursorTableSql}},
+	{version: 4, stmts: []string{mysqlCreateMarkerTableSql}},
+	{version: 5, stmts: []string{addStatusColumnSql, mysqlAddStatusTimeColumnSql, initStatusSql, initStatusTimeSql, createStatusIndexSql}},
+	{version: 6, stmts: []string{mysqlCreateNovelTableSql}},
+	{version: 7, stmts: []string{mysqlCreateRankingMarkerTableSql, mysqlCreateIllustRankTableSql, createIllustRankIndexSql}},
+	{version: 8, stmts: []string{mysqlCreateIllustSeriesTableSql, createIllustSeriesIndexSql}},
+	{version: 9, stmts: []string{mysqlCreateIllustBookmarkTableSql, mysqlWidenCursorUidSql, mysqlWidenMarkerUidSql}},
+	{version: 10, stmts: []string{addPhashColumnSql}},
+	{version: 11, stmts: []string{mysqlCreateIllustFilenameIndexSql, mysqlCreateNovelFilenameIndexSql}},
+}
+
+// mysqlSaveIllustStatusSql is sqliteSaveIllustStatusSql in MySQL, the update has no WHERE clause so that every
+// column keeps the old value if the illust page is done
+func mysqlSaveIllustStatusSql() string {
+	sets := make([]string, 0, len(illustStatusUpdateColumns))
+	for _, column := range illustStatusUpdateColumns {
+		sets = append(sets, fmt.Sprintf("%s = IF(status IN %s, %s, VALUES(%s))", column, illustStatusDoneSql, column, column))
+	}
+	return insertIllustStatusSql + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+// MysqlIllustInfoMgr store the illust info in mysql, it can be shared by multi pixiv-dl instance
+type MysqlIllustInfoMgr struct {
+	*sqlIllustInfoMgr
+}
+
+func NewMysqlIllustInfoMgr(options *PixivDlOptions) *MysqlIllustInfoMgr {
+	if len(options.MysqlDsn) == 0 {
+		log.Fatalf("Must set mysql-dsn if use mysql database")
+	}
+
+	cfg, err := mysql.ParseDSN(options.MysqlDsn)
+	if err != nil {
+		log.Fatalf("Failed to parse mysql dsn, msg: %s", err)
+	}
+	// we need scan DATETIME to time.Time
+	cfg.ParseTime = true
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		log.Fatalf("Failed to open illustMgr, msg: %s", err)
+	}
+	if options.MysqlMaxOpenConns > 0 {
+		db.SetMaxOpenConns(options.MysqlMaxOpenConns)
+	}
+	if options.MysqlMaxIdleConns > 0 {
+		db.SetMaxIdleConns(options.MysqlMaxIdleConns)
+	}
+	if options.MysqlConnMaxLifetimeSec > 0 {
+		db.SetConnMaxLifetime(time.Duration(options.MysqlConnMaxLifetimeSec) * time.Second)
+	}
+
+	err = mysqlMigrateSchema(context.Background(), db)
+	if err != nil {
+		log.Fatalf("Failed to migrate database schema, msg: %s", err)
+	}
+
+	return &MysqlIllustInfoMgr{sqlIllustInfoMgr: newSqlIllustInfoMgr(db, options, mysqlSaveIllustStatusSql())}
+}
+
+// mysqlMigrateSchema migrate the schema with a named lock, because multi instance may start at the same time
+func mysqlMigrateSchema(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	var locked sql.NullInt32
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", mysqlMigrationLock, mysqlMigrationLockTimeout).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked.Valid || locked.Int32 != 1 {
+		log.Warningf("[Database] Failed to get schema migration lock in %ds, migrate without lock", mysqlMigrationLockTimeout)
+	} else {
+		defer func() {
+			_, _ = conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", mysqlMigrationLock)
+		}()
+	}
+
+	return migrateSchemaConn(ctx, conn, mysqlMigrations)
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// QueueJob is an input of a worker persisted in the database until it's processed, so that the downloader can
+// resume it after restart
+type QueueJob struct {
+	Downloader string // the kind of the downloader
+	Queue      string // the queue name, e.g. 'uid', 'basic_illust', 'full_illust'
+	Key        string
+	Payload    string // the input in json
+}
+
+// jobQueue persist the inputs of a channel, the inputs are saved before sending to the channel and deleted after
+// the worker processed them. A nil jobQueue does nothing.
+type jobQueue struct {
+	illustMgr  IllustInfoManager
+	downloader string
+	name       string
+}
+
+func newJobQueue(illustMgr IllustInfoManager, downloader, name string) *jobQueue {
+	return &jobQueue{
+		illustMgr:  illustMgr,
+		downloader: downloader,
+		name:       name,
+	}
+}
+
+// jobKey return the unique key of the input in the queue
+func jobKey(input interface{}) string {
+	switch v := input.(type) {
+	case pixiv.PixivID:
+		return string(v)
+	case *pixiv.IllustDigest:
+		return string(v.Id)
+	case *pixiv.IllustInfo:
+		return fmt.Sprintf("%s_p%d", v.Id, v.PageIdx)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// push save the input before sending it to the channel
+func (q *jobQueue) push(input interface{}) {
+	if q == nil {
+		return
+	}
+	payload, err := json.Marshal(input)
+	if err != nil {
+		log.Warningf("[JobQueue] Failed to marshal %s job, msg: %s", q.name, err)
+		return
+	}
+	job := &QueueJob{Downloader: q.downloader, Queue: q.name, Key: jobKey(input), Payload: string(payload)}
+	err = Retry(func() error {
+		return q.illustMgr.SaveQueueJob(job)
+	}, 3)
+	if err != nil {
+		log.Warningf("[JobQueue] Failed to save %s job '%s', msg: %s", q.name, job.Key, err)
+	}
+}
+
+// done delete the input after it's processed
+func (q *jobQueue) done(input interface{}) {
+	if q == nil {
+		return
+	}
+	q.delete(jobKey(input))
+}
+
+func (q *jobQueue) delete(key string) {
+	err := Retry(func() error {
+		return q.illustMgr.DeleteQueueJob(q.downloader, q.name, key)
+	}, 3)
+	if err != nil {
+		log.Warningf("[JobQueue] Failed to delete %s job '%s', msg: %s", q.name, key, err)
+	}
+}
+
+func (q *jobQueue) list() []*QueueJob {
+	if q == nil {
+		return nil
+	}
+	var jobs []*QueueJob
+	err := Retry(func() error {
+		var err error
+		jobs, err = q.illustMgr.ListQueueJobs(q.downloader, q.name)
+		return err
+	}, 3)
+	if err != nil {
+		log.Errorf("[JobQueue] Failed to list %s jobs, msg: %s", q.name, err)
+	}
+	return jobs
+}
+
+// cursor return the page offset of the uid saved last time, 0 if not found
+func (q *jobQueue) cursor(uid pixiv.PixivID) int32 {
+	if q == nil {
+		return 0
+	}
+	offset, err := q.illustMgr.GetPageCursor(q.downloader, string(uid))
+	if err != nil {
+		log.Warningf("[JobQueue] Failed to get page cursor of uid '%s', msg: %s", uid, err)
+		return 0
+	}
+	return offset
+}
+
+// saveCursor save the page offset of the uid to be processed next
+func (q *jobQueue) saveCursor(uid pixiv.PixivID, offset int32) {
+	if q == nil {
+		return
+	}
+	err := Retry(func() error {
+		return q.illustMgr.SavePageCursor(q.downloader, string(uid), offset)
+	}, 3)
+	if err != nil {
+		log.Warningf("[JobQueue] Failed to save page cursor of uid '%s', msg: %s", uid, err)
+	}
+}
+
+// deleteCursor delete the page cursor after all the pages of the uid are processed
+func (q *jobQueue) deleteCursor(uid pixiv.PixivID) {
+	if q == nil {
+		return
+	}
+	err := Retry(func() error {
+		return q.illustMgr.DeletePageCursor(q.downloader, string(uid))
+	}, 3)
+	if err != nil {
+		log.Warningf("[JobQueue] Failed to delete page cursor of uid '%s', msg: %s", uid, err)
+	}
+}
+
+// resumeJobs send the jobs left by last run to the channel, skip returns true for the inputs which will be sent
+// anyway. sent is called for each job sent so that the downloader can count it. Return false if the ctx is done.
+func resumeJobs[T any](ctx context.Context, q *jobQueue, ch chan<- T, skip func(key string) bool, sent func()) bool {
+	jobs := q.list()
+	resumed := 0
+	for _, job := range jobs {
+		if skip != nil && skip(job.Key) {
+			continue
+		}
+		var input T
+		err := json.Unmarshal([]byte(job.Payload), &input)
+		if err != nil {
+			log.Warningf("[JobQueue] Drop invalid %s job '%s', msg: %s", q.name, job.Key, err)
+			q.delete(job.Key)
+			continue
+		}
+		select {
+		case ch <- input:
+			sent()
+			resumed++
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if resumed > 0 {
+		log.Infof("[JobQueue] Resume %d %s jobs of %s downloader", resumed, q.name, q.downloader)
+	}
+	return true
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+// errEmbedNotSupported means the file is not a JPEG or PNG, e.g. the converted ugoira
+var errEmbedNotSupported = errors.New("embed metadata not supported")
+
+var (
+	jpegSoi      = []byte{0xff, 0xd8}
+	pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+	jpegExifHeader = []byte("Exif\x00\x00")
+	jpegXmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+const (
+	jpegMarkerApp0 = 0xe0
+	jpegMarkerApp1 = 0xe1
+	jpegMarkerSos  = 0xda
+	// jpegMaxSegment is the max length of the segment data, the 2 bytes length itself is included
+	jpegMaxSegment = 0xffff
+
+	exifDateLayout = "2006:01:02 15:04:05"
+)
+
+// illustMetadata is the metadata embedded into the image, the title, artist, tags and source are the same for all
+// the formats
+type illustMetadata struct {
+	Id          string
+	Title       string
+	Artist      string
+	Description string
+	Tags        []string
+	Source      string
+	CreateDate  time.Time
+}
+
+func newIllustMetadata(illust *pixiv.IllustInfo) *illustMetadata {
+	return &illustMetadata{
+		Id:          string(illust.Id),
+		Title:       illust.Title,
+		Artist:      illust.UserName,
+		Description: illust.Description,
+		Tags:        illust.Tags,
+		Source:      "https://www.pixiv.net/artworks/" + string(illust.Id),
+		CreateDate:  illust.CreateDate,
+	}
+}
+
+// EmbedIllustMetadata write the metadata of the illust into the JPEG or PNG file without re-encoding the pixels, the
+// file is replaced only after the new one is completely written. Return errEmbedNotSupported for the other formats.
+func EmbedIllustMetadata(illust *pixiv.IllustInfo, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	meta := newIllustMetadata(illust)
+	var embedded []byte
+	switch {
+	case bytes.HasPrefix(data, jpegSoi):
+		embedded, err = embedJpegMetadata(data, meta)
+	case bytes.HasPrefix(data, pngSignature):
+		embedded, err = embedPngMetadata(data, meta)
+	default:
+		return errEmbedNotSupported
+	}
+	if err != nil {
+		return err
+	}
+
+	partFilename := filename + partialFileSuffix
+	err = os.WriteFile(partFilename, embedded, 0644)
+	if err == nil {
+		err = os.Rename(partFilename, filename)
+	}
+	if err != nil {
+		_ = os.Remove(partFilename)
+		return err
+	}
+	return nil
+}
+
+// xmpPacket return the XMP packet with the Dublin Core properties
+// See https://developer.adobe.com/xmp/docs/XMPNamespaces/dc/
+func (m *illustMetadata) xmpPacket() []byte {
+	var b bytes.Buffer
+	escape := func(s string) string {
+		var e bytes.Buffer
+		_ = xml.EscapeText(&e, []byte(s))
+		return e.String()
+	}
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\">\n")
+	fmt.Fprintf(&b, "   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", escape(m.Title))
+	fmt.Fprintf(&b, "   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", escape(m.Artist))
+	if len(m.Description) > 0 {
+		fmt.Fprintf(&b, "   <dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", escape(m.Description))
+	}
+	if len(m.Tags) > 0 {
+		b.WriteString("   <dc:subject><rdf:Bag>")
+		for _, tag := range m.Tags {
+			fmt.Fprintf(&b, "<rdf:li>%s</rdf:li>", escape(tag))
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+	}
+	fmt.Fprintf(&b, "   <dc:source>%s</dc:source>\n", escape(m.Source))
+	fmt.Fprintf(&b, "   <dc:identifier>%s</dc:identifier>\n", escape(m.Id))
+	if !m.CreateDate.IsZero() {
+		fmt.Fprintf(&b, "   <xmp:CreateDate>%s</xmp:CreateDate>\n", m.CreateDate.Format(time.RFC3339))
+	}
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return b.Bytes()
+}
+
+// exifEntry is an entry of the EXIF IFD0, the value is already encoded by the type
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+const (
+	exifTypeByte  = 1
+	exifTypeAscii = 2
+)
+
+func exifAscii(tag uint16, s string) exifEntry {
+	value := append([]byte(s), 0)
+	return exifEntry{tag: tag, typ: exifTypeAscii, count: uint32(len(value)), value: value}
+}
+
+// exifXp encode the Windows XP tags in UTF-16LE, they are the only EXIF tags support unicode
+func exifXp(tag uint16, s string) exifEntry {
+	codes := append(utf16.Encode([]rune(s)), 0)
+	value := make([]byte, len(codes)*2)
+	for i, code := range codes {
+		binary.LittleEndian.PutUint16(value[i*2:], code)
+	}
+	return exifEntry{tag: tag, typ: exifTypeByte, count: uint32(len(value)), value: value}
+}
+
+// exifTiff return the little endian TIFF structure with only IFD0
+func (m *illustMetadata) exifTiff() []byte {
+	// the entries must be sorted by tag
+	entries := []exifEntry{
+		exifAscii(0x010e, m.Title), // ImageDescription
+	}
+	if !m.CreateDate.IsZero() {
+		entries = append(entries, exifAscii(0x0132, m.CreateDate.Format(exifDateLayout))) // DateTime
+	}
+	entries = append(entries,
+		exifAscii(0x013b, m.Artist),               // Artist
+		exifXp(0x9c9b, m.Title),                   // XPTitle
+		exifXp(0x9c9c, m.Source),                  // XPComment
+		exifXp(0x9c9d, m.Artist),                  // XPAuthor
+		exifXp(0x9c9e, strings.Join(m.Tags, ";")), // XPKeywords
+	)
+
+	ifdSize := 2 + len(entries)*12 + 4
+	header := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	ifd := make([]byte, ifdSize)
+	binary.LittleEndian.PutUint16(ifd, uint16(len(entries)))
+	var extra []byte
+	for i, entry := range entries {
+		p := ifd[2+i*12:]
+		binary.LittleEndian.PutUint16(p, entry.tag)
+		binary.LittleEndian.PutUint16(p[2:], entry.typ)
+		binary.LittleEndian.PutUint32(p[4:], entry.count)
+		if len(entry.value) <= 4 {
+			copy(p[8:12], entry.value)
+			continue
+		}
+		binary.LittleEndian.PutUint32(p[8:], uint32(len(header)+ifdSize+len(extra)))
+		extra = append(extra, entry.value...)
+		// the offset must be word aligned
+		if len(extra)%2 == 1 {
+			extra = append(extra, 0)
+		}
+	}
+	return append(append(header, ifd...), extra...)
+}
+
+// jpegSegment return the APPn segment with the marker and length
+func jpegSegment(marker byte, header, data []byte) ([]byte, error) {
+	length := 2 + len(header) + len(data)
+	if length > jpegMaxSegment {
+		return nil, fmt.Errorf("segment too large: %d", length)
+	}
+	segment := []byte{0xff, marker, byte(length >> 8), byte(length)}
+	return append(append(segment, header...), data...), nil
+}
+
+// embedJpegMetadata insert the EXIF and XMP APP1 segments after SOI and JFIF, the exist XMP is replaced and the exist
+// EXIF is kept as it is
+func embedJpegMetadata(data []byte, meta *illustMetadata) ([]byte, error) {
+	var (
+		app0     [][]byte
+		exif     []byte
+		others   [][]byte
+		pos      = len(jpegSoi)
+		imageEnd = -1
+	)
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("invalid jpeg marker at %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// fill byte
+			pos++
+			continue
+		}
+		if marker == jpegMarkerSos {
+			imageEnd = pos
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("invalid jpeg segment length at %d", pos)
+		}
+		segment := data[pos : pos+2+length]
+		payload := segment[4:]
+		switch {
+		case marker == jpegMarkerApp0 && len(others) == 0:
+			app0 = append(app0, segment)
+		case marker == jpegMarkerApp1 && bytes.HasPrefix(payload, jpegExifHeader) && exif == nil:
+			exif = segment
+		case marker == jpegMarkerApp1 && bytes.HasPrefix(payload, jpegXmpHeader):
+			// replaced by the new one
+		default:
+			others = append(others, segment)
+		}
+		pos += 2 + length
+	}
+	if imageEnd < 0 {
+		return nil, errors.New("no image data in jpeg")
+	}
+
+	var out bytes.Buffer
+	out.Write(jpegSoi)
+	for _, segment := range app0 {
+		out.Write(segment)
+	}
+	if exif == nil {
+		var err error
+		if exif, err = jpegSegment(jpegMarkerApp1, jpegExifHeader, meta.exifTiff()); err != nil {
+			return nil, err
+		}
+	}
+	// EXIF must be the first APP1 segment
+	out.Write(exif)
+	xmp, err := jpegSegment(jpegMarkerApp1, jpegXmpHeader, meta.xmpPacket())
+	if err != nil {
+		return nil, err
+	}
+	out.Write(xmp)
+	for _, segment := range others {
+		out.Write(segment)
+	}
+	out.Write(data[imageEnd:])
+	return out.Bytes(), nil
+}
+
+// pngChunk return the chunk with the length and crc
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngITxt return the uncompressed iTXt chunk
+func pngITxt(keyword, text string) []byte {
+	data := []byte(keyword)
+	// null separator, compression flag and method, empty language tag and translated keyword
+	data = append(data, 0, 0, 0, 0, 0)
+	return pngChunk("iTXt", append(data, text...))
+}
+
+// embedPngMetadata insert the iTXt chunks after IHDR, the exist text chunks are kept
+// See https://www.w3.org/TR/png/#11keywords
+func embedPngMetadata(data []byte, meta *illustMetadata) ([]byte, error) {
+	pos := len(pngSignature)
+	if pos+8 > len(data) || string(data[pos+4:pos+8]) != "IHDR" {
+		return nil, errors.New("no IHDR in png")
+	}
+	ihdrEnd := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+	if ihdrEnd > len(data) {
+		return nil, errors.New("invalid IHDR length in png")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	out.Write(pngITxt("Title", meta.Title))
+	out.Write(pngITxt("Author", meta.Artist))
+	if len(meta.Description) > 0 {
+		out.Write(pngITxt("Description", meta.Description))
+	}
+	if !meta.CreateDate.IsZero() {
+		out.Write(pngITxt("Creation Time", meta.CreateDate.Format(time.RFC1123Z)))
+	}
+	out.Write(pngITxt("Comment", meta.Source))
+	out.Write(pngITxt("XML:com.adobe.xmp", string(meta.xmpPacket())))
+	out.Write(data[ihdrEnd:])
+	return out.Bytes(), nil
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "pixiv_dl"
+
+var (
+	illustParsedCnt = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "illusts_parsed_total",
+		Help:      "The number of illust pages parsed.",
+	})
+	illustFilteredCnt = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "illusts_filtered_total",
+		Help:      "The number of illust skipped by the filters.",
+	}, []string{"reason"})
+	illustDownloadedCnt = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "illusts_downloaded_total",
+		Help:      "The number of illust pages downloaded.",
+	})
+	illustFailedCnt = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "illusts_failed_total",
+		Help:      "The number of illust failed and skipped.",
+	}, []string{"stage"})
+	novelDownloadedCnt = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "novels_downloaded_total",
+		Help:      "The number of novels downloaded.",
+	})
+	downloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "The bytes of the downloaded files.",
+	})
+	downloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "download_duration_seconds",
+		Help:      "The latency of downloading an illust page.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+	})
+	retryCnt = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "retries_total",
+		Help:      "The number of retries of all the workers.",
+	})
+	httpResponseCnt = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_responses_total",
+		Help:      "The HTTP responses from pixiv by status code, code is 'error' if the request failed.",
+	}, []string{"host", "code"})
+	apiRequestCnt = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "The requests of the pixiv api client by result.",
+	}, []string{"api", "result"})
+	accountBenchedCnt = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "accounts_benched_total",
+		Help:      "The number of times the account is benched because it's forbidden or rate limited.",
+	}, []string{"account"})
+	accountLoggedIn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "account_logged_in",
+		Help:      "Whether the cookie of the account is logged in, checked periodically in service mode.",
+	}, []string{"account"})
+)
+
+const (
+	FilterReasonUser   = "user"
+	FilterReasonTag    = "tag"
+	FilterReasonFilter = "filter"
+
+	FailedStageParse    = "parse"
+	FailedStageDownload = "download"
+)
+
+// observeApiRequest count the result of the pixiv api client request, the status code is not exposed by the client
+func observeApiRequest(api string, err error) {
+	result := "ok"
+	if errors.Is(err, pixiv.ErrNotFound) {
+		result = "not_found"
+	} else if err != nil {
+		result = "error"
+	}
+	apiRequestCnt.WithLabelValues(api, result).Inc()
+}
+
+// metricsTransport count the HTTP status codes of the requests
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		httpResponseCnt.WithLabelValues(req.URL.Host, "error").Inc()
+		return nil, err
+	}
+	httpResponseCnt.WithLabelValues(req.URL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// downloaderCollector collect the queue backlog and worker counters of the downloaders when scraping
+type downloaderCollector struct {
+	downloaders []PixivDownloader
+	queueLen    *prometheus.Desc
+	queueCap    *prometheus.Desc
+	consumeCnt  *prometheus.Desc
+	produceCnt  *prometheus.Desc
+	paused      *prometheus.Desc
+}
+
+func newDownloaderCollector(downloaders ...PixivDownloader) *downloaderCollector {
+	labels := []string{"downloader", "queue"}
+	workerLabels := []string{"downloader", "worker"}
+	return &downloaderCollector{
+		downloaders: downloaders,
+		queueLen:    prometheus.NewDesc(metricsNamespace+"_queue_length", "The number of items in the channel.", labels, nil),
+		queueCap:    prometheus.NewDesc(metricsNamespace+"_queue_capacity", "The capacity of the channel.", labels, nil),
+		consumeCnt:  prometheus.NewDesc(metricsNamespace+"_worker_consumed_total", "The number of inputs processed by the worker.", workerLabels, nil),
+		produceCnt:  prometheus.NewDesc(metricsNamespace+"_worker_produced_total", "The number of outputs produced by the worker.", workerLabels, nil),
+		paused:      prometheus.NewDesc(metricsNamespace+"_downloader_paused", "Whether the downloader is paused.", []string{"downloader"}, nil),
+	}
+}
+
+func (c *downloaderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queueLen
+	ch <- c.queueCap
+	ch <- c.consumeCnt
+	ch <- c.produceCnt
+	ch <- c.paused
+}
+
+func (c *downloaderCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, downloader := range c.downloaders {
+		stats := downloader.Stats()
+		for _, queue := range stats.Queues {
+			ch <- prometheus.MustNewConstMetric(c.queueLen, prometheus.GaugeValue, float64(queue.Len), stats.Kind, queue.Name)
+			ch <- prometheus.MustNewConstMetric(c.queueCap, prometheus.GaugeValue, float64(queue.Cap), stats.Kind, queue.Name)
+		}
+		for _, worker := range stats.Workers {
+			ch <- prometheus.MustNewConstMetric(c.consumeCnt, prometheus.CounterValue, float64(worker.ConsumeCnt), stats.Kind, worker.Name)
+			ch <- prometheus.MustNewConstMetric(c.produceCnt, prometheus.CounterValue, float64(worker.ProduceCnt), stats.Kind, worker.Name)
+		}
+		paused := 0.0
+		if stats.Paused {
+			paused = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.paused, prometheus.GaugeValue, paused, stats.Kind)
+	}
+}
+
+// NewMetricsHandler return the handler of '/metrics' in prometheus format
+func NewMetricsHandler(downloaders ...PixivDownloader) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		illustParsedCnt,
+		illustFilteredCnt,
+		illustDownloadedCnt,
+		illustFailedCnt,
+		novelDownloadedCnt,
+		downloadedBytes,
+		downloadDuration,
+		retryCnt,
+		httpResponseCnt,
+		apiRequestCnt,
+		accountBenchedCnt,
+		accountLoggedIn,
+		newDownloaderCollector(downloaders...),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
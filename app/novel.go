@@ -0,0 +1,246 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+const (
+	NovelFormatTxt      = "txt"
+	NovelFormatMarkdown = "md"
+	NovelFormatEpub     = "epub"
+
+	novelCoverSuffix = ".cover"
+)
+
+var novelFormats = map[string]struct{}{
+	NovelFormatTxt:      {},
+	NovelFormatMarkdown: {},
+	NovelFormatEpub:     {},
+}
+
+func IsValidNovelFormat(format string) bool {
+	_, ok := novelFormats[format]
+	return ok
+}
+
+// NovelInfo is the novel with the text content
+type NovelInfo struct {
+	Id            pixiv.PixivID
+	Title         string
+	Description   string // in html
+	Content       string // in pixiv novel markup, e.g. '[newpage]', '[chapter:title]', '[[rb:漢字 > かんじ]]'
+	CoverUrl      string
+	UserId        pixiv.PixivID
+	UserName      string
+	Tags          []string
+	R18           bool
+	Language      string
+	TextCount     int
+	BookmarkCount int
+	LikeCount     int
+	ViewCount     int
+	SeriesId      string
+	SeriesTitle   string
+	CreateDate    time.Time
+	UploadDate    time.Time
+	// EmbeddedImages is the url of the images uploaded with the novel, referred by '[uploadedimage:id]'
+	EmbeddedImages map[string]string
+}
+
+func (n *NovelInfo) DigestString() string {
+	return fmt.Sprintf("[id: %s, title: %s, uid: %s, user: %s, text: %d]", n.Id, n.Title, n.UserId, n.UserName, n.TextCount)
+}
+
+// Url return the page of the novel on pixiv
+func (n *NovelInfo) Url() string {
+	return fmt.Sprintf("%s/novel/show.php?id=%s", pixivHost, n.Id)
+}
+
+// novelAjaxBody is the response of '/ajax/novel/{id}'
+type novelAjaxBody struct {
+	Id          json.Number `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Content     string      `json:"content"`
+	CoverUrl    string      `json:"coverUrl"`
+	UserId      json.Number `json:"userId"`
+	UserName    string      `json:"userName"`
+	XRestrict   int         `json:"xRestrict"`
+	Language    string      `json:"language"`
+	Tags        struct {
+		Tags []struct {
+			Tag string `json:"tag"`
+		} `json:"tags"`
+	} `json:"tags"`
+	CharacterCount int       `json:"characterCount"`
+	BookmarkCount  int       `json:"bookmarkCount"`
+	LikeCount      int       `json:"likeCount"`
+	ViewCount      int       `json:"viewCount"`
+	CreateDate     time.Time `json:"createDate"`
+	UploadDate     time.Time `json:"uploadDate"`
+	SeriesNavData  *struct {
+		SeriesId json.Number `json:"seriesId"`
+		Title    string      `json:"title"`
+	} `json:"seriesNavData"`
+	// it's an empty array instead of an object if there is no image
+	TextEmbeddedImages json.RawMessage `json:"textEmbeddedImages"`
+}
+
+type novelEmbeddedImage struct {
+	Urls struct {
+		Original string `json:"original"`
+	} `json:"urls"`
+}
+
+func (b *novelAjaxBody) novelInfo() *NovelInfo {
+	novel := &NovelInfo{
+		Id:             pixiv.PixivID(b.Id),
+		Title:          b.Title,
+		Description:    b.Description,
+		Content:        b.Content,
+		CoverUrl:       b.CoverUrl,
+		UserId:         pixiv.PixivID(b.UserId),
+		UserName:       b.UserName,
+		R18:            b.XRestrict > 0,
+		Language:       b.Language,
+		TextCount:      b.CharacterCount,
+		BookmarkCount:  b.BookmarkCount,
+		LikeCount:      b.LikeCount,
+		ViewCount:      b.ViewCount,
+		CreateDate:     b.CreateDate,
+		UploadDate:     b.UploadDate,
+		EmbeddedImages: make(map[string]string),
+	}
+	for _, tag := range b.Tags.Tags {
+		novel.Tags = append(novel.Tags, tag.Tag)
+	}
+	if b.SeriesNavData != nil {
+		novel.SeriesId = string(b.SeriesNavData.SeriesId)
+		novel.SeriesTitle = b.SeriesNavData.Title
+	}
+	var images map[string]novelEmbeddedImage
+	if isJsonObject(b.TextEmbeddedImages) && json.Unmarshal(b.TextEmbeddedImages, &images) == nil {
+		for id, image := range images {
+			novel.EmbeddedImages[id] = image.Urls.Original
+		}
+	}
+	if novel.TextCount == 0 {
+		novel.TextCount = utf8.RuneCountInString(b.Content)
+	}
+	return novel
+}
+
+// NovelBookmarks is a page of the user's novel bookmarks
+type NovelBookmarks struct {
+	Ids   []pixiv.PixivID
+	Total int32
+}
+
+// novelInlineKind is the kind of the inline element in a line of the novel
+type novelInlineKind int
+
+const (
+	novelText novelInlineKind = iota
+	novelRuby
+	novelLink
+	novelImage
+)
+
+type novelInline struct {
+	kind novelInlineKind
+	text string // the text, the ruby base, or the link text
+	attr string // the ruby text, the link url, or the image url
+}
+
+// novelLine is a line of the novel, or a chapter heading
+type novelLine struct {
+	heading string
+	inlines []novelInline
+}
+
+// novelPage is the content between '[newpage]'
+type novelPage []novelLine
+
+var (
+	novelChapterRegexp = regexp.MustCompile(`^\s*\[chapter:(.*)\]\s*$`)
+	novelInlineRegexp  = regexp.MustCompile(`\[\[rb:(.*?)>(.*?)\]\]|\[\[jumpuri:(.*?)>(.*?)\]\]|\[jump:(\d+)\]|\[pixivimage:([\d-]+)\]|\[uploadedimage:(\d+)\]`)
+	htmlBreakRegexp    = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlTagRegexp      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseNovelContent parse the pixiv novel markup into pages, the images are referred by the url
+func parseNovelContent(novel *NovelInfo) []novelPage {
+	content := strings.ReplaceAll(novel.Content, "\r\n", "\n")
+	pages := make([]novelPage, 0)
+	for _, pageContent := range strings.Split(content, "[newpage]") {
+		page := make(novelPage, 0)
+		for _, line := range strings.Split(strings.Trim(pageContent, "\n"), "\n") {
+			if m := novelChapterRegexp.FindStringSubmatch(line); m != nil {
+				page = append(page, novelLine{heading: strings.TrimSpace(m[1])})
+				continue
+			}
+			page = append(page, novelLine{inlines: parseNovelInlines(novel, line)})
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+func parseNovelInlines(novel *NovelInfo, line string) []novelInline {
+	inlines := make([]novelInline, 0)
+	for len(line) > 0 {
+		loc := novelInlineRegexp.FindStringSubmatchIndex(line)
+		if loc == nil {
+			inlines = append(inlines, novelInline{kind: novelText, text: line})
+			break
+		}
+		if loc[0] > 0 {
+			inlines = append(inlines, novelInline{kind: novelText, text: line[:loc[0]]})
+		}
+		group := func(i int) string {
+			if loc[2*i] < 0 {
+				return ""
+			}
+			return strings.TrimSpace(line[loc[2*i]:loc[2*i+1]])
+		}
+		switch {
+		case loc[2] >= 0:
+			inlines = append(inlines, novelInline{kind: novelRuby, text: group(1), attr: group(2)})
+		case loc[6] >= 0:
+			inlines = append(inlines, novelInline{kind: novelLink, text: group(3), attr: group(4)})
+		case loc[10] >= 0:
+			// the jump to another page does not work out of pixiv
+			inlines = append(inlines, novelInline{kind: novelText, text: fmt.Sprintf("(p.%s)", group(5))})
+		case loc[12] >= 0:
+			id, _, _ := strings.Cut(group(6), "-")
+			url := fmt.Sprintf("%s/artworks/%s", pixivHost, id)
+			inlines = append(inlines, novelInline{kind: novelLink, text: url, attr: url})
+		case loc[14] >= 0:
+			if url, ok := novel.EmbeddedImages[group(7)]; ok && len(url) > 0 {
+				inlines = append(inlines, novelInline{kind: novelImage, attr: url})
+			}
+		}
+		line = line[loc[1]:]
+	}
+	return inlines
+}
+
+// isJsonObject return false for null and the empty array which pixiv responds instead of the empty object
+func isJsonObject(data json.RawMessage) bool {
+	return strings.HasPrefix(strings.TrimSpace(string(data)), "{")
+}
+
+// plainDescription convert the description in html to plain text
+func plainDescription(description string) string {
+	text := htmlBreakRegexp.ReplaceAllString(description, "\n")
+	text = htmlTagRegexp.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+// NovelDownloader download the novel by id
+type NovelDownloader struct {
+	*pixivDownloader
+
+	novelDownloadWorker *NovelDownloadWorker
+
+	novelChan chan pixiv.PixivID
+}
+
+func NewNovelDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *NovelDownloader {
+	novelChan := make(chan pixiv.PixivID, 50)
+
+	downloader := &NovelDownloader{
+		pixivDownloader:     newPixivDownloader(DownloaderKindNovel, "NovelDownloader", options, illustMgr, queueNovel),
+		novelDownloadWorker: NewNovelDownloadWorker(options, illustMgr, novelChan),
+		novelChan:           novelChan,
+	}
+	downloader.novelDownloadWorker.SetJobQueues(downloader.inputQueue, nil)
+	return downloader
+}
+
+func (d *NovelDownloader) waitDone(ctx context.Context) {
+	for {
+		if d.novelDownloadWorker.GetConsumeCnt() == d.getInputCnt() {
+			return
+		}
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+func (d *NovelDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadNovelIds) == 0 && !d.options.ServiceMode {
+		return
+	}
+
+	ctx = d.run(ctx)
+	d.novelDownloadWorker.Run(ctx)
+	if !resumeInputs(ctx, d.pixivDownloader, d.novelChan, d.options.DownloadNovelIds) {
+		return
+	}
+
+	for {
+		for _, id := range pixivIds(d.options.DownloadNovelIds) {
+			if !sendInput(ctx, d.pixivDownloader, d.novelChan, id) {
+				return
+			}
+		}
+
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
+			break
+		}
+	}
+}
+
+func (d *NovelDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.novelChan, pixivIds(ids))
+}
+
+func (d *NovelDownloader) Pause() {
+	d.novelDownloadWorker.Pause()
+}
+
+func (d *NovelDownloader) Resume() {
+	d.novelDownloadWorker.Resume()
+}
+
+func (d *NovelDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueNovel, d.novelChan),
+		},
+		[]WorkerStats{
+			workerStats("novel_download", d.novelDownloadWorker.pixivWorker),
+		},
+		d.novelDownloadWorker.IsPaused())
+}
+
+func (d *NovelDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.novelDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.novelDownloadWorker.RemovePartialFiles()
+		return
+	}
+	close(d.novelChan)
+}
+
+// NovelUserDownloader download all the novels or the bookmarked novels of users
+type NovelUserDownloader struct {
+	*pixivDownloader
+
+	uids                []string // the 'dl-novel-artist-uids' or 'dl-novel-bookmarks-uids'
+	novelUserWorker     *NovelUserWorker
+	novelDownloadWorker *NovelDownloadWorker
+
+	uidChan   chan pixiv.PixivID
+	novelChan chan pixiv.PixivID
+}
+
+// NewNovelArtistDownloader download all the novels of users
+func NewNovelArtistDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *NovelUserDownloader {
+	uidChan := make(chan pixiv.PixivID, 10)
+	novelChan := make(chan pixiv.PixivID, 50)
+	return newNovelUserDownloader(
+		newPixivDownloader(DownloaderKindNovelArtist, "NovelArtistDownloader", options, illustMgr, queueUid),
+		options.DownloadNovelArtistUserIds,
+		NewNovelArtistWorker(options, illustMgr, uidChan, novelChan),
+		NewNovelDownloadWorker(options, illustMgr, novelChan),
+		uidChan, novelChan)
+}
+
+// NewNovelBookmarksDownloader download the bookmarked novels of users
+func NewNovelBookmarksDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *NovelUserDownloader {
+	uidChan := make(chan pixiv.PixivID, 10)
+	novelChan := make(chan pixiv.PixivID, 50)
+	return newNovelUserDownloader(
+		newPixivDownloader(DownloaderKindNovelBookmarks, "NovelBookmarksDownloader", options, illustMgr, queueUid),
+		options.DownloadNovelBookmarksUserIds,
+		NewNovelBookmarksWorker(options, illustMgr, uidChan, novelChan),
+		NewNovelDownloadWorker(options, illustMgr, novelChan),
+		uidChan, novelChan)
+}
+
+func newNovelUserDownloader(base *pixivDownloader, uids []string, userWorker *NovelUserWorker,
+	downloadWorker *NovelDownloadWorker, uidChan, novelChan chan pixiv.PixivID) *NovelUserDownloader {
+	downloader := &NovelUserDownloader{
+		pixivDownloader:     base,
+		uids:                uids,
+		novelUserWorker:     userWorker,
+		novelDownloadWorker: downloadWorker,
+		uidChan:             uidChan,
+		novelChan:           novelChan,
+	}
+	downloader.novelUserWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueNovel))
+	downloader.novelDownloadWorker.SetJobQueues(downloader.jobQueue(queueNovel), nil)
+	return downloader
+}
+
+// resume send the jobs left by last run, the downstream first
+func (d *NovelUserDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.novelDownloadWorker.inputQueue, d.novelChan, nil, d.novelUserWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.uidChan, d.uids)
+}
+
+func (d *NovelUserDownloader) waitDone(ctx context.Context) {
+	for {
+		if d.novelUserWorker.GetConsumeCnt() == d.getInputCnt() &&
+			d.novelDownloadWorker.GetConsumeCnt() == d.novelUserWorker.GetProduceCnt() {
+			return
+		}
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+func (d *NovelUserDownloader) Start(ctx context.Context) {
+	if len(d.uids) == 0 && !d.options.ServiceMode {
+		return
+	}
+
+	ctx = d.run(ctx)
+	d.novelUserWorker.Run(ctx)
+	d.novelDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
+
+	for {
+		for _, uid := range pixivIds(d.uids) {
+			if !sendInput(ctx, d.pixivDownloader, d.uidChan, uid) {
+				return
+			}
+		}
+
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
+			break
+		}
+	}
+}
+
+func (d *NovelUserDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.uidChan, pixivIds(ids))
+}
+
+func (d *NovelUserDownloader) Pause() {
+	d.novelDownloadWorker.Pause()
+}
+
+func (d *NovelUserDownloader) Resume() {
+	d.novelDownloadWorker.Resume()
+}
+
+func (d *NovelUserDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueUid, d.uidChan),
+			queueStats(queueNovel, d.novelChan),
+		},
+		[]WorkerStats{
+			workerStats("novel_"+d.novelUserWorker.source, d.novelUserWorker.pixivWorker),
+			workerStats("novel_download", d.novelDownloadWorker.pixivWorker),
+		},
+		d.novelDownloadWorker.IsPaused())
+}
+
+func (d *NovelUserDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.novelUserWorker, d.novelDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.novelDownloadWorker.RemovePartialFiles()
+		return
+	}
+	close(d.uidChan)
+	close(d.novelChan)
+}
@@ -0,0 +1,440 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const NovelBookmarksPageLimit = 24
+
+// the sources of the novel ids of a user
+const (
+	novelSourceArtist    = "artist"
+	novelSourceBookmarks = "bookmarks"
+)
+
+func (w *pixivWorker) checkNovelExist(id pixiv.PixivID) (bool, error) {
+	exist := false
+	err := Retry(func() error {
+		var err error
+		exist, err = w.illustMgr.IsNovelExist(string(id))
+		return err
+	}, 3)
+	return exist, err
+}
+
+// markNovelStatus record the novel is not downloaded with the reason, see markIllustStatus
+func (w *pixivWorker) markNovelStatus(novel *NovelInfo, status string) {
+	err := Retry(func() error {
+		return w.illustMgr.SaveNovelStatus(novel, status)
+	}, 3)
+	if err != nil {
+		log.Warningf("[PixivWorker] Failed to save novel status '%s', %s, msg: %s", status, novel.DigestString(), err)
+	}
+}
+
+// NovelUserWorker process the input user id and output the id of all the novels or the bookmarked novels of the user
+type NovelUserWorker struct {
+	*pixivWorker
+
+	source string
+	input  <-chan pixiv.PixivID // input user id
+	output chan<- pixiv.PixivID // novel id
+}
+
+func newNovelUserWorker(options *PixivDlOptions, illustMgr IllustInfoManager, source string,
+	input <-chan pixiv.PixivID, output chan<- pixiv.PixivID) *NovelUserWorker {
+	return &NovelUserWorker{
+		pixivWorker: newPixivWorker(options, illustMgr, options.ParseTimeoutMs),
+		source:      source,
+		input:       input,
+		output:      output,
+	}
+}
+
+// NewNovelArtistWorker output all the novels of the input user
+func NewNovelArtistWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
+	input <-chan pixiv.PixivID, output chan<- pixiv.PixivID) *NovelUserWorker {
+	return newNovelUserWorker(options, illustMgr, novelSourceArtist, input, output)
+}
+
+// NewNovelBookmarksWorker output all the bookmarked novels of the input user
+func NewNovelBookmarksWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
+	input <-chan pixiv.PixivID, output chan<- pixiv.PixivID) *NovelUserWorker {
+	return newNovelUserWorker(options, illustMgr, novelSourceBookmarks, input, output)
+}
+
+func (w *NovelUserWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case uid, ok := <-w.input:
+				if !ok {
+					return
+				}
+				if w.source == novelSourceBookmarks {
+					w.processBookmarks(ctx, uid)
+				} else {
+					w.processArtist(ctx, uid)
+				}
+				w.inputDone(ctx, uid)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
+		}
+	}()
+}
+
+func (w *NovelUserWorker) processArtist(ctx context.Context, uid pixiv.PixivID) {
+	if w.filterByUid(uid) {
+		return
+	}
+	w.retry(ctx, func() bool {
+		account, ok := w.acquireAccount(ctx)
+		if !ok {
+			return false
+		}
+		novelIds, err := account.webClient.GetUserNovels(ctx, uid)
+		w.reportAccount(account, err)
+		observeApiRequest("get_user_novels", err)
+		if errors.Is(err, pixiv.ErrNotFound) {
+			log.Warningf("[NovelUserWorker] Skip user: %s, msg: %s", uid, err)
+			return true
+		}
+		if err != nil {
+			log.Warningf("[NovelUserWorker] Failed to get novels of user %s, retry, msg: %s", uid, err)
+			return false
+		}
+
+		log.Infof("[NovelUserWorker] Success get user all novels, uid: %s, count: %d", uid, len(novelIds))
+		_, err = w.processOutput(ctx, novelIds)
+		if err != nil {
+			log.Warningf("[NovelUserWorker] Failed to process novels of user %s, retry, msg: %s", uid, err)
+			return false
+		}
+		return true
+	})
+}
+
+func (w *NovelUserWorker) processBookmarks(ctx context.Context, uid pixiv.PixivID) {
+	offset := w.inputQueue.cursor(uid)
+	if offset > 0 {
+		log.Infof("[NovelUserWorker] Resume novel bookmarks of uid '%s' from offset %d", uid, offset)
+	}
+	total := int32(-1)
+	for total < 0 || offset < total {
+		if ctx.Err() != nil {
+			return
+		}
+		ok := w.retry(ctx, func() bool {
+			account, ok := w.acquireAccount(ctx)
+			if !ok {
+				return false
+			}
+			bookmarks, err := account.webClient.GetNovelBookmarks(ctx, uid, offset, NovelBookmarksPageLimit)
+			w.reportAccount(account, err)
+			observeApiRequest("get_novel_bookmarks", err)
+			if errors.Is(err, pixiv.ErrNotFound) {
+				log.Warningf("[NovelUserWorker] Skip novel bookmarks of uid '%s', msg: %s", uid, err)
+				total = 0
+				return true
+			}
+			if err != nil {
+				log.Warningf("[NovelUserWorker] Failed to get novel bookmarks, offset: %d, retry, msg: %s", offset, err)
+				return false
+			}
+			_, err = w.processOutput(ctx, bookmarks.Ids)
+			if err != nil {
+				log.Warningf("[NovelUserWorker] Failed to process novel bookmarks, offset: %d, retry, msg: %s", offset, err)
+				return false
+			}
+			total = bookmarks.Total
+			if len(bookmarks.Ids) == 0 {
+				// never loop forever if the total is wrong
+				total = offset
+			}
+			log.Infof("[NovelUserWorker] Success get novel bookmarks, offset: %d, total: %d", offset, total)
+			return true
+		})
+		if !ok && ctx.Err() != nil {
+			return
+		}
+		if !ok && total < 0 {
+			// skip the user if the first page can not be got
+			break
+		}
+		offset += NovelBookmarksPageLimit
+		w.inputQueue.saveCursor(uid, offset)
+	}
+	log.Infof("[NovelUserWorker] End scan all novel bookmarks for uid '%s'", uid)
+	w.inputQueue.deleteCursor(uid)
+}
+
+func (w *NovelUserWorker) processOutput(ctx context.Context, novelIds []pixiv.PixivID) (int, error) {
+	newCnt := 0
+	for _, id := range novelIds {
+		exist, err := w.checkNovelExist(id)
+		if err != nil {
+			log.Errorf("[NovelUserWorker] Failed to check novel exist, id: %s, msg: %s", id, err)
+			return newCnt, err
+		}
+		if exist {
+			log.Debugf("[NovelUserWorker] Skip exist novel, id: %s", id)
+			continue
+		}
+
+		w.outputQueue.push(id)
+		select {
+		case w.output <- id:
+			atomic.AddUint64(&w.produceCnt, 1)
+			newCnt++
+		case <-ctx.Done():
+			return newCnt, ctx.Err()
+		}
+	}
+	return newCnt, nil
+}
+
+// NovelDownloadWorker process the input novel id, get the novel and save it to disk in 'novel-format'
+type NovelDownloadWorker struct {
+	*pixivWorker
+	input <-chan pixiv.PixivID
+
+	filenameTemplate *NovelFilenameTemplate
+	pause            pauseGate
+}
+
+func NewNovelDownloadWorker(options *PixivDlOptions, illustMgr IllustInfoManager, input <-chan pixiv.PixivID) *NovelDownloadWorker {
+	filenameTemplate, err := NewNovelFilenameTemplate(options.NovelFilenamePattern, options.FilenameMaxLength)
+	if err != nil {
+		log.Fatalf("Failed to parse novel filename pattern, msg: %s", err)
+	}
+	return &NovelDownloadWorker{
+		pixivWorker:      newPixivWorker(options, illustMgr, options.DownloadTimeoutMs),
+		input:            input,
+		filenameTemplate: filenameTemplate,
+	}
+}
+
+func (w *NovelDownloadWorker) Run(ctx context.Context) {
+	for i := int32(0); i < w.options.DownloadParallel; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			defer log.Info("[NovelDownloadWorker] exit")
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id, ok := <-w.input:
+					if !ok {
+						return
+					}
+					if !w.pause.Wait(ctx) {
+						return
+					}
+					w.processInput(ctx, id)
+					w.inputDone(ctx, id)
+					atomic.AddUint64(&w.consumeCnt, 1)
+				}
+			}
+		}()
+	}
+}
+
+func (w *NovelDownloadWorker) Pause() {
+	if w.pause.Pause() {
+		log.Info("[NovelDownloadWorker] Paused")
+	}
+}
+
+func (w *NovelDownloadWorker) Resume() {
+	if w.pause.Resume() {
+		log.Info("[NovelDownloadWorker] Resumed")
+	}
+}
+
+func (w *NovelDownloadWorker) IsPaused() bool {
+	return w.pause.IsPaused()
+}
+
+// filterNovel return true if the novel should be skipped by the user lists, tag lists and 'no-r18', 'bookmark-gt',
+// 'like-gt'. The 'filter' expression is only for the illust.
+func (w *NovelDownloadWorker) filterNovel(novel *NovelInfo) bool {
+	if w.filterByUid(novel.UserId) {
+		return true
+	}
+	if w.options.NoR18 && novel.R18 {
+		log.Infof("[NovelDownloadWorker] Skip R-18 novel: %s", novel.DigestString())
+		return true
+	}
+	if (w.options.BookmarkGt > 0 && novel.BookmarkCount > 0 && novel.BookmarkCount < w.options.BookmarkGt) ||
+		(w.options.LikeGt > 0 && novel.LikeCount > 0 && novel.LikeCount < w.options.LikeGt) {
+		log.Infof("[NovelDownloadWorker] Skip novel by bookmarks or likes: %s", novel.DigestString())
+		return true
+	}
+	if !w.tagFilter.Enabled() {
+		return false
+	}
+	// the translated tags of the novel are not supported
+	if skip, reason := w.tagFilter.Filter(IllustTagsFromNames(novel.Tags)); skip {
+		log.Infof("[NovelDownloadWorker] Skip novel by tags: %s, reason: %s", novel.DigestString(), reason)
+		return true
+	}
+	return false
+}
+
+func (w *NovelDownloadWorker) processInput(ctx context.Context, id pixiv.PixivID) {
+	novel := &NovelInfo{Id: id}
+	// the filename is resolved only once at the first time the novel info is got, the retries write the same file
+	var (
+		filename string
+		release  = func() {}
+	)
+	defer func() {
+		release()
+	}()
+	ok := w.retry(ctx, func() bool {
+		exist, err := w.checkNovelExist(id)
+		if err != nil {
+			log.Warningf("[NovelDownloadWorker] Failed to check novel exist, id: %s, msg: %s", id, err)
+			// ignore error and download
+		} else if exist {
+			log.Debugf("[NovelDownloadWorker] Skip exist novel, id: %s", id)
+			return true
+		}
+
+		account, ok := w.acquireAccount(ctx)
+		if !ok {
+			return false
+		}
+		info, err := account.webClient.GetNovelInfo(ctx, id)
+		w.reportAccount(account, err)
+		observeApiRequest("get_novel_info", err)
+		if errors.Is(err, pixiv.ErrNotFound) {
+			log.Warningf("[NovelDownloadWorker] Skip novel: %s, msg: %s", novel.DigestString(), err)
+			w.markNovelStatus(novel, IllustStatusNotFound)
+			return true
+		}
+		if err != nil {
+			log.Warningf("[NovelDownloadWorker] Failed to get novel info, id: %s, msg: %s", id, err)
+			return false
+		}
+		novel = info
+		if w.filterNovel(novel) {
+			return true
+		}
+		if len(novel.Content) == 0 {
+			log.Warningf("[NovelDownloadWorker] Skip empty content novel: %s", novel.DigestString())
+			w.markNovelStatus(novel, IllustStatusRestricted)
+			return true
+		}
+
+		if len(filename) == 0 {
+			patternFilename, err := w.filenameTemplate.Format(novel, w.options.NovelFormat)
+			if err != nil {
+				log.Errorf("[NovelDownloadWorker] Failed to format filename, skip novel: %s, msg: %s", novel.DigestString(), err)
+				return true
+			}
+			filename, release, err = w.reserveFilename(ctx, patternFilename, FileOwner{Id: string(novel.Id), Novel: true})
+			if err != nil {
+				return false
+			}
+			if len(filename) == 0 {
+				log.Warningf("[NovelDownloadWorker] Skip novel because the file already exists, novel: %s, filename: %s", novel.DigestString(), patternFilename)
+				return true
+			}
+		}
+		fullFilename := filepath.Join(w.options.DownloadPath, filename)
+		err = os.MkdirAll(filepath.Dir(fullFilename), 0755)
+		if err != nil {
+			log.Errorf("[NovelDownloadWorker] Failed to create directory and retry, %s, msg: %s", novel.DigestString(), err)
+			return false
+		}
+
+		start := time.Now()
+		cover, err := w.downloadCover(ctx, account, novel)
+		if err != nil {
+			log.Warningf("[NovelDownloadWorker] Failed to download novel cover and retry, %s, url: %s, msg: %s", novel.DigestString(), novel.CoverUrl, err)
+			return false
+		}
+		size, hash, coverFilename, err := w.saveNovel(novel, cover, filename)
+		if err != nil {
+			log.Errorf("[NovelDownloadWorker] Failed to save novel and retry, %s, msg: %s", novel.DigestString(), err)
+			return false
+		}
+		err = Retry(func() error {
+			return w.illustMgr.SaveNovel(novel, hash, filename, coverFilename)
+		}, 3)
+		if err != nil {
+			log.Errorf("[NovelDownloadWorker] Failed to save novel info and retry, %s, msg: %s", novel.DigestString(), err)
+			return false
+		}
+		elapsed := time.Since(start)
+		novelDownloadedCnt.Inc()
+		downloadedBytes.Add(float64(size))
+		log.Infof("[NovelDownloadWorker] Success download novel: %s, cost: %s, size: %dKB, filename: %s", novel.DigestString(), elapsed, size/1024, filename)
+		return true
+	})
+	if !ok && ctx.Err() == nil {
+		log.Errorf("[NovelDownloadWorker] Give up novel after %d retries: %s", w.options.MaxRetries, novel.DigestString())
+		w.markNovelStatus(novel, IllustStatusFailed)
+	}
+}
+
+// downloadCover return nil if the novel has no cover
+func (w *NovelDownloadWorker) downloadCover(ctx context.Context, account *accountClient, novel *NovelInfo) (*novelCover, error) {
+	if len(novel.CoverUrl) == 0 {
+		return nil, nil
+	}
+	data, err := account.webClient.DownloadBytes(ctx, novel.CoverUrl)
+	w.reportAccount(account, err)
+	if errors.Is(err, pixiv.ErrNotFound) {
+		log.Warningf("[NovelDownloadWorker] Novel cover not found: %s, url: %s", novel.DigestString(), novel.CoverUrl)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &novelCover{data: data, ext: novelCoverExt(novel.CoverUrl)}, nil
+}
+
+// saveNovel write the novel file and the cover file (except epub which embeds the cover), return the size and hash
+// of the novel file and the relative cover filename
+func (w *NovelDownloadWorker) saveNovel(novel *NovelInfo, cover *novelCover, filename string) (int64, string, string, error) {
+	fullFilename := filepath.Join(w.options.DownloadPath, filename)
+	coverFilename, coverName := "", ""
+	if cover != nil && w.options.NovelFormat != NovelFormatEpub {
+		coverFilename = novelCoverFilename(filename, cover.ext)
+		coverName = filepath.Base(coverFilename)
+		err := os.WriteFile(filepath.Join(w.options.DownloadPath, coverFilename), cover.data, 0644)
+		if err != nil {
+			return 0, "", "", err
+		}
+	}
+
+	defer w.trackInflightFile(fullFilename + partialFileSuffix)()
+	err := WriteNovelFile(fullFilename, novel, w.options.NovelFormat, cover, coverName)
+	if err != nil {
+		return 0, "", "", err
+	}
+	stat, err := os.Stat(fullFilename)
+	if err != nil {
+		return 0, "", "", err
+	}
+	hash, err := FileSha1Sum(fullFilename)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return stat.Size(), hash, coverFilename, nil
+}
@@ -0,0 +1,415 @@
+package app
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const novelDateLayout = "2006-01-02 15:04:05"
+
+// novelCover is the cover image of the novel, it's embedded in epub and saved as '<name>.cover.jpg' along with
+// the markdown and plain text
+type novelCover struct {
+	data []byte
+	ext  string // e.g. '.jpg'
+}
+
+func (c *novelCover) mediaType() string {
+	switch strings.ToLower(c.ext) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// novelCoverFilename return the cover file stored along with the novel file
+func novelCoverFilename(filename, ext string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + novelCoverSuffix + ext
+}
+
+// novelCoverExt return the extension of the cover url, '.jpg' if unknown
+func novelCoverExt(coverUrl string) string {
+	ext := path.Ext(coverUrl)
+	if len(ext) == 0 || len(ext) > 5 {
+		return ".jpg"
+	}
+	return ext
+}
+
+// WriteNovelFile write the novel to filename in the format. The content is written to 'filename.part' first and
+// renamed to filename after done. coverName is the cover file referred by the markdown, empty if no cover.
+func WriteNovelFile(filename string, novel *NovelInfo, format string, cover *novelCover, coverName string) error {
+	partFilename := filename + partialFileSuffix
+	file, err := os.Create(partFilename)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	switch format {
+	case NovelFormatTxt:
+		err = writeNovelTxt(writer, novel)
+	case NovelFormatMarkdown:
+		err = writeNovelMarkdown(writer, novel, coverName)
+	case NovelFormatEpub:
+		err = writeNovelEpub(writer, novel, cover)
+	default:
+		err = fmt.Errorf("not supported novel format '%s'", format)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partFilename, filename)
+	}
+	if err != nil {
+		_ = os.Remove(partFilename)
+		return err
+	}
+	return nil
+}
+
+// novelMeta return the metadata lines of the novel in 'name: value' order, the empty values are skipped
+func novelMeta(novel *NovelInfo) [][2]string {
+	meta := [][2]string{
+		{"Author", fmt.Sprintf("%s (%s/users/%s)", novel.UserName, pixivHost, novel.UserId)},
+	}
+	if len(novel.SeriesId) > 0 {
+		meta = append(meta, [2]string{"Series", fmt.Sprintf("%s (%s/novel/series/%s)", novel.SeriesTitle, pixivHost, novel.SeriesId)})
+	}
+	if len(novel.Tags) > 0 {
+		meta = append(meta, [2]string{"Tags", strings.Join(novel.Tags, ", ")})
+	}
+	if novel.R18 {
+		meta = append(meta, [2]string{"R-18", "yes"})
+	}
+	if !novel.CreateDate.IsZero() {
+		meta = append(meta, [2]string{"Date", novel.CreateDate.Format(novelDateLayout)})
+	}
+	if !novel.UploadDate.IsZero() && !novel.UploadDate.Equal(novel.CreateDate) {
+		meta = append(meta, [2]string{"Updated", novel.UploadDate.Format(novelDateLayout)})
+	}
+	meta = append(meta,
+		[2]string{"Characters", fmt.Sprintf("%d", novel.TextCount)},
+		[2]string{"Bookmarks", fmt.Sprintf("%d", novel.BookmarkCount)},
+		[2]string{"URL", novel.Url()},
+	)
+	return meta
+}
+
+// writeNovelTxt write the novel as plain text, the ruby is written as 'base(ruby)'
+func writeNovelTxt(w io.Writer, novel *NovelInfo) error {
+	var sb strings.Builder
+	sb.WriteString(novel.Title + "\n\n")
+	for _, kv := range novelMeta(novel) {
+		sb.WriteString(kv[0] + ": " + kv[1] + "\n")
+	}
+	if description := plainDescription(novel.Description); len(description) > 0 {
+		sb.WriteString("\n" + description + "\n")
+	}
+	sb.WriteString("\n" + strings.Repeat("=", 40) + "\n\n")
+
+	for idx, page := range parseNovelContent(novel) {
+		if idx > 0 {
+			sb.WriteString("\n" + strings.Repeat("-", 40) + "\n\n")
+		}
+		for _, line := range page {
+			if len(line.heading) > 0 {
+				sb.WriteString("[" + line.heading + "]\n")
+				continue
+			}
+			for _, inline := range line.inlines {
+				switch inline.kind {
+				case novelRuby:
+					sb.WriteString(inline.text + "(" + inline.attr + ")")
+				case novelLink:
+					if inline.text == inline.attr {
+						sb.WriteString(inline.attr)
+					} else {
+						sb.WriteString(inline.text + "(" + inline.attr + ")")
+					}
+				case novelImage:
+					sb.WriteString("[image: " + inline.attr + "]")
+				default:
+					sb.WriteString(inline.text)
+				}
+			}
+			sb.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "#", `\#`, "|", `\|`, "~", `\~`,
+	"<", "&lt;", ">", "&gt;",
+)
+
+// writeNovelMarkdown write the novel as markdown, the ruby is written as html '<ruby>' which most renderers support
+func writeNovelMarkdown(w io.Writer, novel *NovelInfo, coverName string) error {
+	var sb strings.Builder
+	sb.WriteString("# " + markdownEscaper.Replace(novel.Title) + "\n\n")
+	if len(coverName) > 0 {
+		sb.WriteString("![cover](<" + filepath.ToSlash(coverName) + ">)\n\n")
+	}
+	for _, kv := range novelMeta(novel) {
+		sb.WriteString("- **" + kv[0] + "**: " + markdownEscaper.Replace(kv[1]) + "\n")
+	}
+	if description := plainDescription(novel.Description); len(description) > 0 {
+		sb.WriteString("\n")
+		for _, line := range strings.Split(description, "\n") {
+			sb.WriteString(strings.TrimRight("> "+markdownEscaper.Replace(line), " ") + "\n")
+		}
+	}
+
+	for _, page := range parseNovelContent(novel) {
+		sb.WriteString("\n---\n\n")
+		for idx, line := range page {
+			if len(line.heading) > 0 {
+				sb.WriteString("## " + markdownEscaper.Replace(line.heading) + "\n\n")
+				continue
+			}
+			text := markdownInlines(line.inlines)
+			sb.WriteString(text)
+			// keep the line breaks inside a paragraph by the hard line break
+			if len(text) > 0 && idx+1 < len(page) && len(page[idx+1].heading) == 0 && len(page[idx+1].inlines) > 0 {
+				sb.WriteString("\\")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func markdownInlines(inlines []novelInline) string {
+	var sb strings.Builder
+	for _, inline := range inlines {
+		switch inline.kind {
+		case novelRuby:
+			sb.WriteString("<ruby>" + html.EscapeString(inline.text) + "<rt>" + html.EscapeString(inline.attr) + "</rt></ruby>")
+		case novelLink:
+			sb.WriteString("[" + markdownEscaper.Replace(inline.text) + "](<" + inline.attr + ">)")
+		case novelImage:
+			sb.WriteString("![](<" + inline.attr + ">)")
+		default:
+			sb.WriteString(markdownEscaper.Replace(inline.text))
+		}
+	}
+	return sb.String()
+}
+
+const (
+	epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+	epubXhtmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%[1]s" lang="%[1]s">
+<head>
+  <meta charset="UTF-8"/>
+  <title>%[2]s</title>
+</head>
+<body>
+`
+	epubXhtmlFooter = "</body>\n</html>\n"
+)
+
+// epubTocEntry is an entry of the table of contents
+type epubTocEntry struct {
+	title string
+	href  string
+}
+
+// writeNovelEpub write the novel as EPUB 3, a page of the novel is a xhtml file. The zip entries use the upload
+// date of the novel, so that the same novel always has the same sha1.
+func writeNovelEpub(w io.Writer, novel *NovelInfo, cover *novelCover) error {
+	language := novel.Language
+	if len(language) == 0 {
+		language = "ja"
+	}
+	modified := novel.UploadDate
+	if modified.IsZero() {
+		modified = novel.CreateDate
+	}
+	modified = modified.UTC()
+	if modified.Year() < 1980 {
+		// the minimum date of zip
+		modified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	zw := zip.NewWriter(w)
+	writeEntry := func(name string, method uint16, data []byte) error {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = entry.Write(data)
+		return err
+	}
+	// the mimetype must be the first entry and not compressed
+	err := writeEntry("mimetype", zip.Store, []byte("application/epub+zip"))
+	if err != nil {
+		return err
+	}
+	err = writeEntry("META-INF/container.xml", zip.Deflate, []byte(epubContainer))
+	if err != nil {
+		return err
+	}
+
+	title := html.EscapeString(novel.Title)
+	var manifest, spine strings.Builder
+	toc := []epubTocEntry{{title: novel.Title, href: "title.xhtml"}}
+
+	// the title page with the cover and metadata
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(epubXhtmlHeader, language, title))
+	if cover != nil {
+		coverHref := "cover" + cover.ext
+		err = writeEntry("OEBPS/"+coverHref, zip.Deflate, cover.data)
+		if err != nil {
+			return err
+		}
+		manifest.WriteString(fmt.Sprintf(`    <item id="cover-image" href="%s" media-type="%s" properties="cover-image"/>`+"\n",
+			coverHref, cover.mediaType()))
+		sb.WriteString(fmt.Sprintf(`  <p><img src="%s" alt="cover"/></p>`+"\n", coverHref))
+	}
+	sb.WriteString("  <h1>" + title + "</h1>\n  <dl>\n")
+	for _, kv := range novelMeta(novel) {
+		sb.WriteString("    <dt>" + html.EscapeString(kv[0]) + "</dt><dd>" + html.EscapeString(kv[1]) + "</dd>\n")
+	}
+	sb.WriteString("  </dl>\n")
+	if description := plainDescription(novel.Description); len(description) > 0 {
+		for _, line := range strings.Split(description, "\n") {
+			sb.WriteString("  <p>" + html.EscapeString(line) + "</p>\n")
+		}
+	}
+	sb.WriteString(epubXhtmlFooter)
+	err = writeEntry("OEBPS/title.xhtml", zip.Deflate, []byte(sb.String()))
+	if err != nil {
+		return err
+	}
+	manifest.WriteString(`    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>` + "\n")
+	spine.WriteString(`    <itemref idref="title"/>` + "\n")
+
+	pages := parseNovelContent(novel)
+	for pageIdx, page := range pages {
+		href := fmt.Sprintf("page-%d.xhtml", pageIdx+1)
+		sb.Reset()
+		sb.WriteString(fmt.Sprintf(epubXhtmlHeader, language, title))
+		headings := 0
+		for _, line := range page {
+			if len(line.heading) > 0 {
+				headings++
+				anchor := fmt.Sprintf("chapter-%d", headings)
+				toc = append(toc, epubTocEntry{title: line.heading, href: href + "#" + anchor})
+				sb.WriteString(fmt.Sprintf(`  <h2 id="%s">%s</h2>`+"\n", anchor, html.EscapeString(line.heading)))
+				continue
+			}
+			text := epubInlines(line.inlines)
+			if len(text) == 0 {
+				text = "<br/>"
+			}
+			sb.WriteString("  <p>" + text + "</p>\n")
+		}
+		if headings == 0 && len(pages) > 1 {
+			toc = append(toc, epubTocEntry{title: fmt.Sprintf("%d", pageIdx+1), href: href})
+		}
+		sb.WriteString(epubXhtmlFooter)
+		err = writeEntry("OEBPS/"+href, zip.Deflate, []byte(sb.String()))
+		if err != nil {
+			return err
+		}
+		id := fmt.Sprintf("page-%d", pageIdx+1)
+		manifest.WriteString(fmt.Sprintf(`    <item id="%s" href="%s" media-type="application/xhtml+xml"/>`+"\n", id, href))
+		spine.WriteString(fmt.Sprintf(`    <itemref idref="%s"/>`+"\n", id))
+	}
+
+	// the navigation document
+	sb.Reset()
+	sb.WriteString(fmt.Sprintf(epubXhtmlHeader, language, title))
+	sb.WriteString("  <nav epub:type=\"toc\" id=\"toc\">\n    <ol>\n")
+	for _, entry := range toc {
+		sb.WriteString(fmt.Sprintf(`      <li><a href="%s">%s</a></li>`+"\n", entry.href, html.EscapeString(entry.title)))
+	}
+	sb.WriteString("    </ol>\n  </nav>\n" + epubXhtmlFooter)
+	err = writeEntry("OEBPS/nav.xhtml", zip.Deflate, []byte(sb.String()))
+	if err != nil {
+		return err
+	}
+	manifest.WriteString(`    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+
+	// the package document
+	sb.Reset()
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(fmt.Sprintf(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="%s">`+"\n", language))
+	sb.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	sb.WriteString("    <dc:identifier id=\"book-id\">" + html.EscapeString(novel.Url()) + "</dc:identifier>\n")
+	sb.WriteString("    <dc:title>" + title + "</dc:title>\n")
+	sb.WriteString("    <dc:creator>" + html.EscapeString(novel.UserName) + "</dc:creator>\n")
+	sb.WriteString("    <dc:language>" + html.EscapeString(language) + "</dc:language>\n")
+	sb.WriteString("    <dc:source>" + html.EscapeString(novel.Url()) + "</dc:source>\n")
+	if description := plainDescription(novel.Description); len(description) > 0 {
+		sb.WriteString("    <dc:description>" + html.EscapeString(description) + "</dc:description>\n")
+	}
+	for _, tag := range novel.Tags {
+		sb.WriteString("    <dc:subject>" + html.EscapeString(tag) + "</dc:subject>\n")
+	}
+	if !novel.CreateDate.IsZero() {
+		sb.WriteString("    <dc:date>" + novel.CreateDate.UTC().Format(time.RFC3339) + "</dc:date>\n")
+	}
+	if len(novel.SeriesId) > 0 {
+		sb.WriteString("    <meta property=\"belongs-to-collection\" id=\"series\">" + html.EscapeString(novel.SeriesTitle) + "</meta>\n")
+		sb.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+	}
+	sb.WriteString("    <meta property=\"dcterms:modified\">" + modified.Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	if cover != nil {
+		// for the EPUB 2 readers
+		sb.WriteString("    <meta name=\"cover\" content=\"cover-image\"/>\n")
+	}
+	sb.WriteString("  </metadata>\n  <manifest>\n" + manifest.String() + "  </manifest>\n")
+	sb.WriteString("  <spine>\n" + spine.String() + "  </spine>\n</package>\n")
+	err = writeEntry("OEBPS/content.opf", zip.Deflate, []byte(sb.String()))
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// epubInlines write the inlines as xhtml, the images are linked instead of embedded
+func epubInlines(inlines []novelInline) string {
+	var sb strings.Builder
+	for _, inline := range inlines {
+		switch inline.kind {
+		case novelRuby:
+			sb.WriteString("<ruby>" + html.EscapeString(inline.text) + "<rt>" + html.EscapeString(inline.attr) + "</rt></ruby>")
+		case novelLink:
+			sb.WriteString(`<a href="` + html.EscapeString(inline.attr) + `">` + html.EscapeString(inline.text) + "</a>")
+		case novelImage:
+			sb.WriteString(`<a href="` + html.EscapeString(inline.attr) + `">[image]</a>`)
+		default:
+			sb.WriteString(html.EscapeString(inline.text))
+		}
+	}
+	return sb.String()
+}
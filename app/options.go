@@ -7,34 +7,97 @@ type PixivDlOptions struct {
 	UserAgent string `mapstructure:"user-agent"`
 	Proxy     string `mapstructure:"proxy"`
 
-	ServiceMode bool `mapstructure:"service-mode"`
+	Accounts        []PixivAccount `mapstructure:"accounts"`
+	AccountBenchSec int32          `mapstructure:"account-bench-sec"`
+
+	AuthCheckIntervalSec int32 `mapstructure:"auth-check-interval-sec"`
+
+	ServiceMode bool   `mapstructure:"service-mode"`
+	ListenAddr  string `mapstructure:"listen-addr"`
 
 	DatabaseType    string `mapstructure:"database-type"`
 	SqlitePath      string `mapstructure:"sqlite-path"`
 	DownloadPath    string `mapstructure:"download-path"`
 	FilenamePattern string `mapstructure:"filename-pattern"`
+	UgoiraFormat    string `mapstructure:"ugoira-format"`
+
+	FilenameMaxLength int      `mapstructure:"filename-max-length"`
+	FilenameCollision string   `mapstructure:"filename-collision"`
+	SidecarFormats    []string `mapstructure:"sidecar-formats"`
+	EmbedMetadata     bool     `mapstructure:"embed-metadata"`
+
+	PhashMaxDistance  int  `mapstructure:"phash-max-distance"`
+	SkipNearDuplicate bool `mapstructure:"skip-near-duplicate"`
+
+	MysqlDsn                string `mapstructure:"mysql-dsn"`
+	MysqlMaxOpenConns       int    `mapstructure:"mysql-max-open-conns"`
+	MysqlMaxIdleConns       int    `mapstructure:"mysql-max-idle-conns"`
+	MysqlConnMaxLifetimeSec int32  `mapstructure:"mysql-conn-max-lifetime-sec"`
 
 	ScanIntervalSec   int32 `mapstructure:"scan-interval-sec"`
 	ParseParallel     int32 `mapstructure:"parse-parallel"`
 	DownloadParallel  int32 `mapstructure:"download-parallel"`
 	MaxRetries        int32 `mapstructure:"max-retries"`
 	RetryBackoffMs    int32 `mapstructure:"retry-backoff-ms"`
+	RetryMaxBackoffMs int32 `mapstructure:"retry-max-backoff-ms"`
 	ParseTimeoutMs    int32 `mapstructure:"parse-timeout-ms"`
 	DownloadTimeoutMs int32 `mapstructure:"download-timeout-ms"`
 
+	ShutdownTimeoutSec int32 `mapstructure:"shutdown-timeout-sec"`
+
+	ApiRateLimit        float64 `mapstructure:"api-rate-limit"`
+	DownloadRateLimitKB int     `mapstructure:"download-rate-limit-kb"`
+
+	IncrementalScanPages int32 `mapstructure:"incremental-scan-pages"`
+	FullScanIntervalSec  int32 `mapstructure:"full-scan-interval-sec"`
+
+	RecheckIntervalSec int32 `mapstructure:"recheck-interval-sec"`
+
+	NovelFormat          string `mapstructure:"novel-format"`
+	NovelFilenamePattern string `mapstructure:"novel-filename-pattern"`
+
+	SearchOrder     string `mapstructure:"search-order"`
+	SearchMode      string `mapstructure:"search-mode"`
+	SearchType      string `mapstructure:"search-type"`
+	SearchMatch     string `mapstructure:"search-match"`
+	SearchStartDate string `mapstructure:"search-start-date"`
+	SearchEndDate   string `mapstructure:"search-end-date"`
+	SearchMaxPages  int32  `mapstructure:"search-max-pages"`
+
+	RankingDate     string `mapstructure:"ranking-date"`
+	RankingSaveRank bool   `mapstructure:"ranking-save-rank"`
+
+	BookmarksVisibility string   `mapstructure:"bookmarks-visibility"`
+	BookmarkTags        []string `mapstructure:"bookmark-tags"`
+
 	DownloadBookmarksUserIds []string `mapstructure:"dl-bookmarks-uids"`
 	DownloadFollowingUserIds []string `mapstructure:"dl-following-uids"`
 	DownloadArtistUserIds    []string `mapstructure:"dl-artist-uids"`
 	DownloadIllustIds        []string `mapstructure:"dl-illust-ids"`
 
+	DownloadNovelIds              []string `mapstructure:"dl-novel-ids"`
+	DownloadNovelArtistUserIds    []string `mapstructure:"dl-novel-artist-uids"`
+	DownloadNovelBookmarksUserIds []string `mapstructure:"dl-novel-bookmarks-uids"`
+
+	DownloadSearchKeywords []string `mapstructure:"dl-search-keywords"`
+	DownloadRankingModes   []string `mapstructure:"dl-ranking-modes"`
+	DownloadSeriesIds      []string `mapstructure:"dl-series-ids"`
+
 	UserWhiteList []string `mapstructure:"user-white-list"`
 	UserBlockList []string `mapstructure:"user-block-list"`
 
-	NoR18      bool `mapstructure:"no-r18"`
-	OnlyP0     bool `mapstructure:"only-p0"`
-	BookmarkGt int  `mapstructure:"bookmark-gt"`
-	LikeGt     int  `mapstructure:"like-gt"`
-	PixelGt    int  `mapstructure:"pixel-gt"`
+	TagWhiteList     []string `mapstructure:"tag-white-list"`
+	TagBlockList     []string `mapstructure:"tag-block-list"`
+	TagWhiteListMode string   `mapstructure:"tag-white-list-mode"`
+	TagBlockListMode string   `mapstructure:"tag-block-list-mode"`
+	TagMatch         string   `mapstructure:"tag-match"`
+
+	Filter     string `mapstructure:"filter"`
+	NoR18      bool   `mapstructure:"no-r18"`
+	OnlyP0     bool   `mapstructure:"only-p0"`
+	BookmarkGt int    `mapstructure:"bookmark-gt"`
+	LikeGt     int    `mapstructure:"like-gt"`
+	PixelGt    int    `mapstructure:"pixel-gt"`
 }
 
 func (p *PixivDlOptions) ToJson(indent bool) string {
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+	// dHashSamples is the max sampled pixels of every cell in a row or column, it's enough to shrink the image
+	dHashSamples = 32
+)
+
+// DHash compute the 64 bits difference hash of the image. The image is shrunk to 9x8 gray cells by averaging the
+// sampled pixels, and every bit is whether a cell is brighter than the right one, so that the resized and
+// recompressed images have the same or close hashes.
+func DHash(img image.Image) uint64 {
+	var (
+		sums   [dHashHeight][dHashWidth]uint64
+		counts [dHashHeight][dHashWidth]uint64
+	)
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return 0
+	}
+	stepX := width/(dHashWidth*dHashSamples) + 1
+	stepY := height/(dHashHeight*dHashSamples) + 1
+	for y := 0; y < height; y += stepY {
+		cy := y * dHashHeight / height
+		for x := 0; x < width; x += stepX {
+			cx := x * dHashWidth / width
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sums[cy][cx] += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+			counts[cy][cx]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < dHashHeight; y++ {
+		for x := 0; x < dHashWidth-1; x++ {
+			hash <<= 1
+			// compare the averages without division, the counts may differ at the edges
+			if sums[y][x]*counts[y][x+1] > sums[y][x+1]*counts[y][x] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// FileDHash decode the image file and compute the DHash
+func FileDHash(filename string) (uint64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+// HashDistance return the number of different bits of the two hashes
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatPhash format the hash as 16 hex digits to store in database
+func FormatPhash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func ParsePhash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// IllustPhash is the perceptual hash of a downloaded illust page, Phash is empty if not computed
+type IllustPhash struct {
+	Pid      string
+	Page     int
+	UserId   string
+	Filename string
+	Phash    string
+}
+
+// NearDuplicate is an illust page in the cluster, Distance is to the first page of the cluster
+type NearDuplicate struct {
+	*IllustPhash
+	Distance int
+}
+
+// DupesOptions control how FindNearDuplicates find the clusters
+type DupesOptions struct {
+	DownloadPath string
+	MaxDistance  int
+	// ComputeMissing compute and save the hash of the pages downloaded before the hash is supported
+	ComputeMissing bool
+}
+
+// FindNearDuplicates group the downloaded illust pages whose hashes are within the max distance, the clusters only
+// have the pages of the same illust are ignored because the pages of an illust are often similar
+func FindNearDuplicates(illustMgr IllustInfoManager, options *DupesOptions) ([][]*NearDuplicate, error) {
+	records, err := illustMgr.ListIllustPhashes()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		phashes []*IllustPhash
+		hashes  []uint64
+	)
+	for _, record := range records {
+		if len(record.Phash) == 0 {
+			if !options.ComputeMissing {
+				continue
+			}
+			hash, err := FileDHash(filepath.Join(options.DownloadPath, record.Filename))
+			if err != nil {
+				log.Warningf("[Dupes] Failed to compute hash, skip illust: %s, page: %d, file: %s, msg: %s",
+					record.Pid, record.Page, record.Filename, err)
+				continue
+			}
+			record.Phash = FormatPhash(hash)
+			if err := illustMgr.SaveIllustPhash(record.Pid, record.Page, record.Phash); err != nil {
+				return nil, err
+			}
+		}
+		hash, err := ParsePhash(record.Phash)
+		if err != nil {
+			log.Warningf("[Dupes] Invalid hash '%s', illust: %s, page: %d", record.Phash, record.Pid, record.Page)
+			continue
+		}
+		phashes = append(phashes, record)
+		hashes = append(hashes, hash)
+	}
+
+	// union all the pairs within the max distance, the candidates are found by the multi-index hashing: if two
+	// hashes are within distance d, at least one of the d+1 blocks of them are the same
+	parents := make([]int, len(hashes))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	blocks := options.MaxDistance + 1
+	if blocks > 64 {
+		blocks = 64
+	}
+	for block := 0; block < blocks; block++ {
+		start, end := block*64/blocks, (block+1)*64/blocks
+		mask := (^uint64(0) >> (64 - (end - start))) << start
+		buckets := make(map[uint64][]int)
+		for i, hash := range hashes {
+			buckets[hash&mask] = append(buckets[hash&mask], i)
+		}
+		for _, bucket := range buckets {
+			for i := 0; i < len(bucket); i++ {
+				for j := i + 1; j < len(bucket); j++ {
+					a, b := bucket[i], bucket[j]
+					if find(a) != find(b) && HashDistance(hashes[a], hashes[b]) <= options.MaxDistance {
+						parents[find(a)] = find(b)
+					}
+				}
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range hashes {
+		root := find(i)
+		groups[root] = append(groups[root], i)
+	}
+	var clusters [][]*NearDuplicate
+	for _, group := range groups {
+		pids := make(map[string]struct{})
+		for _, i := range group {
+			pids[phashes[i].Pid] = struct{}{}
+		}
+		if len(pids) < 2 {
+			continue
+		}
+		cluster := make([]*NearDuplicate, 0, len(group))
+		for _, i := range group {
+			cluster = append(cluster, &NearDuplicate{IllustPhash: phashes[i], Distance: HashDistance(hashes[group[0]], hashes[i])})
+		}
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0].Pid < clusters[j][0].Pid
+	})
+	return clusters, nil
+}
+
+// phashIndex is the hashes of all the downloaded illust pages to find the near duplicate before saving a new one,
+// it's loaded from database at the first use and shared by all the download workers
+type phashIndex struct {
+	mu        sync.Mutex
+	illustMgr IllustInfoManager
+	loaded    bool
+	phashes   []*IllustPhash
+	hashes    []uint64
+}
+
+var (
+	sharedPhash     *phashIndex
+	sharedPhashOnce sync.Once
+)
+
+func sharedPhashIndex(illustMgr IllustInfoManager) *phashIndex {
+	sharedPhashOnce.Do(func() {
+		sharedPhash = &phashIndex{illustMgr: illustMgr}
+	})
+	return sharedPhash
+}
+
+func (idx *phashIndex) load() error {
+	if idx.loaded {
+		return nil
+	}
+	records, err := idx.illustMgr.ListIllustPhashes()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if hash, err := ParsePhash(record.Phash); err == nil {
+			idx.phashes = append(idx.phashes, record)
+			idx.hashes = append(idx.hashes, hash)
+		}
+	}
+	idx.loaded = true
+	log.Infof("[PhashIndex] Loaded %d illust page hashes", len(idx.hashes))
+	return nil
+}
+
+// find return the nearest page of the other illust within the max distance, nil if not found
+func (idx *phashIndex) find(hash uint64, pid string, maxDistance int) (*IllustPhash, int, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := idx.load(); err != nil {
+		return nil, 0, err
+	}
+	var (
+		nearest  *IllustPhash
+		distance = maxDistance + 1
+	)
+	for i, other := range idx.hashes {
+		if d := HashDistance(hash, other); d < distance && idx.phashes[i].Pid != pid {
+			nearest, distance = idx.phashes[i], d
+		}
+	}
+	return nearest, distance, nil
+}
+
+func (idx *phashIndex) add(phash *IllustPhash, hash uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.loaded {
+		// it's loaded from database at the first find
+		return
+	}
+	idx.phashes = append(idx.phashes, phash)
+	idx.hashes = append(idx.hashes, hash)
+}
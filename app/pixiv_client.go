@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"errors"
 	pixiv "github.com/littleneko/pixiv-api-go"
 )
@@ -13,6 +14,11 @@ type pixivPageClient struct {
 	curOffset int32
 }
 
+// SetClient change the client to request the next page, e.g. rotate to another account
+func (pc *pixivPageClient) SetClient(client *pixiv.PixivClient) {
+	pc.client = client
+}
+
 func (pc *pixivPageClient) CurOffset() int32 {
 	return pc.curOffset
 }
@@ -21,6 +27,11 @@ func (pc *pixivPageClient) Total() int32 {
 	return pc.total
 }
 
+// SetOffset start from the offset instead of the first page, e.g. resume from the page cursor
+func (pc *pixivPageClient) SetOffset(offset int32) {
+	pc.curOffset = offset
+}
+
 func (pc *pixivPageClient) MoveToNextPage() {
 	pc.curOffset += pc.limit
 }
@@ -29,25 +40,37 @@ func (pc *pixivPageClient) HasMorePage() bool {
 	return pc.total == -1 || pc.curOffset < pc.total
 }
 
-// PixivBookmarksPageClient is a wrapper of PixivClient.GetUserBookmarks, it records the bookmarks page offset and num
+// PixivBookmarksPageClient is a wrapper of PixivWebClient.GetUserBookmarks, it records the bookmarks page offset and
+// num of a scope of the user bookmarks
 type PixivBookmarksPageClient struct {
 	pixivPageClient
+	webClient *PixivWebClient
+	tag       string
+	private   bool
 }
 
-func NewBookmarksPageClient(client *pixiv.PixivClient, uid string, limit int32) *PixivBookmarksPageClient {
+func NewBookmarksPageClient(webClient *PixivWebClient, uid, tag string, private bool, limit int32) *PixivBookmarksPageClient {
 	return &PixivBookmarksPageClient{
 		pixivPageClient: pixivPageClient{
-			client:    client,
 			uid:       uid,
 			limit:     limit,
 			total:     -1,
 			curOffset: 0,
 		},
+		webClient: webClient,
+		tag:       tag,
+		private:   private,
 	}
 }
 
-func (bpc *PixivBookmarksPageClient) GetNextPageBookmarks() (*pixiv.BookmarksInfo, error) {
-	bmInfo, err := bpc.client.GetUserBookmarks(bpc.uid, bpc.curOffset, bpc.limit)
+// SetWebClient change the client to request the next page, e.g. rotate to another account
+func (bpc *PixivBookmarksPageClient) SetWebClient(webClient *PixivWebClient) {
+	bpc.webClient = webClient
+}
+
+func (bpc *PixivBookmarksPageClient) GetNextPageBookmarks(ctx context.Context) (*BookmarksPage, error) {
+	bmPage, err := bpc.webClient.GetUserBookmarks(ctx, pixiv.PixivID(bpc.uid), bpc.tag, bpc.private, bpc.curOffset, bpc.limit)
+	observeApiRequest("get_user_bookmarks", err)
 	// mark this user as invalid user, it has no next page
 	if errors.Is(err, pixiv.ErrNotFound) {
 		bpc.total = 0
@@ -55,10 +78,10 @@ func (bpc *PixivBookmarksPageClient) GetNextPageBookmarks() (*pixiv.BookmarksInf
 	if err != nil {
 		return nil, err
 	}
-	if bmInfo.Total > bpc.total {
-		bpc.total = bmInfo.Total
+	if bmPage.Total > bpc.total {
+		bpc.total = bmPage.Total
 	}
-	return bmInfo, nil
+	return bmPage, nil
 }
 
 // PixivFollowingPageClient is a wrapper of PixivClient.GetUserFollowing, it records the bookmarks page offset and num
@@ -80,6 +103,7 @@ func NewFollowingPageClient(client *pixiv.PixivClient, uid string, limit int32)
 
 func (fpc *PixivFollowingPageClient) GetNextPageFollowing() (*pixiv.FollowingInfo, error) {
 	bmInfo, err := fpc.client.GetUserFollowing(fpc.uid, fpc.curOffset, fpc.limit)
+	observeApiRequest("get_user_following", err)
 	// mark this user as invalid user, it has no next page
 	if errors.Is(err, pixiv.ErrNotFound) {
 		fpc.total = 0
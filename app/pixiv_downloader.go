@@ -1,24 +1,291 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	pixiv "github.com/littleneko/pixiv-api-go"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	DownloaderKindIllust    = "illust"
+	DownloaderKindBookmarks = "bookmarks"
+	DownloaderKindArtist    = "artist"
+	DownloaderKindFollowing = "following"
+	DownloaderKindSearch    = "search"
+	DownloaderKindRanking   = "ranking"
+	DownloaderKindSeries    = "series"
+
+	DownloaderKindNovel          = "novel"
+	DownloaderKindNovelArtist    = "novel_artist"
+	DownloaderKindNovelBookmarks = "novel_bookmarks"
+)
+
+// the queue names in stats and job queue
+const (
+	queueUid         = "uid"
+	queueArtistUid   = "artist_uid"
+	queueBasicIllust = "basic_illust"
+	queueFullIllust  = "full_illust"
+	queueNovel       = "novel"
+	queueKeyword     = "keyword"
+	queueRankingMode = "ranking_mode"
+	queueSeriesId    = "series_id"
+)
+
+var ErrDownloaderNotRunning = errors.New("downloader is not running")
+
 type PixivDownloader interface {
-	Start()
+	// Start run the workers and download the illust, it returns when all the illust are downloaded,
+	// or the ctx is done in service mode
+	Start(ctx context.Context)
+	// Close stop the workers and wait them exit
 	Close()
+	// Kind return the kind of the downloader, one of the DownloaderKind*
+	Kind() string
+	// Enqueue download the illust ids or user ids (depends on the kind) immediately, it's only available
+	// when the downloader is running
+	Enqueue(ctx context.Context, ids []string) error
+	// Rescan start the next round immediately instead of waiting 'scan-interval-sec' in service mode
+	Rescan()
+	// Pause stop downloading new illust until Resume, the in-flight downloads are not affected
+	Pause()
+	Resume()
+	Stats() *DownloaderStats
+}
+
+type QueueStats struct {
+	Name string `json:"name"`
+	Len  int    `json:"len"`
+	Cap  int    `json:"cap"`
+}
+
+type WorkerStats struct {
+	Name       string `json:"name"`
+	ConsumeCnt uint64 `json:"consume_cnt"`
+	ProduceCnt uint64 `json:"produce_cnt"`
+}
+
+type DownloaderStats struct {
+	Kind     string        `json:"kind"`
+	Running  bool          `json:"running"`
+	Paused   bool          `json:"paused"`
+	InputCnt uint64        `json:"input_cnt"`
+	Queues   []QueueStats  `json:"queues"`
+	Workers  []WorkerStats `json:"workers"`
+}
+
+func queueStats[T any](name string, ch chan T) QueueStats {
+	return QueueStats{Name: name, Len: len(ch), Cap: cap(ch)}
+}
+
+func workerStats(name string, worker *pixivWorker) WorkerStats {
+	return WorkerStats{Name: name, ConsumeCnt: worker.GetConsumeCnt(), ProduceCnt: worker.GetProduceCnt()}
+}
+
+// closeWorkers cancel the workers ctx and wait all the workers exit, return false if timeout
+func closeWorkers(cancel context.CancelFunc, timeout time.Duration, workers ...PixivWorker) bool {
+	if cancel != nil {
+		cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, worker := range workers {
+			worker.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.Warningf("[PixivDownloader] Workers not exit after %s", timeout)
+		return false
+	}
+}
+
+// pixivDownloader is the common part of all the downloaders
+type pixivDownloader struct {
+	kind      string
+	name      string // used in log
+	options   *PixivDlOptions
+	illustMgr IllustInfoManager
+
+	mu     sync.Mutex
+	runCtx context.Context
+	cancel context.CancelFunc
+
+	inputCnt   uint64    // the inputs sent to the first worker, include the enqueued
+	inputQueue *jobQueue // persist the inputs sent to the first worker
+	rescanCh   chan struct{}
+}
+
+func newPixivDownloader(kind, name string, options *PixivDlOptions, illustMgr IllustInfoManager, inputQueue string) *pixivDownloader {
+	d := &pixivDownloader{
+		kind:      kind,
+		name:      name,
+		options:   options,
+		illustMgr: illustMgr,
+		rescanCh:  make(chan struct{}, 1),
+	}
+	d.inputQueue = d.jobQueue(inputQueue)
+	return d
+}
+
+// jobQueue return the job queue to persist the inputs of the channel
+func (d *pixivDownloader) jobQueue(name string) *jobQueue {
+	return newJobQueue(d.illustMgr, d.kind, name)
+}
+
+// run create the ctx for the workers, it's canceled by Close
+func (d *pixivDownloader) run(ctx context.Context) context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runCtx, d.cancel = context.WithCancel(ctx)
+	return d.runCtx
+}
+
+func (d *pixivDownloader) running() context.Context {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.runCtx == nil || d.runCtx.Err() != nil {
+		return nil
+	}
+	return d.runCtx
+}
+
+func (d *pixivDownloader) stop(timeout time.Duration, workers ...PixivWorker) bool {
+	d.mu.Lock()
+	cancel := d.cancel
+	d.mu.Unlock()
+	return closeWorkers(cancel, timeout, workers...)
+}
+
+func (d *pixivDownloader) Kind() string {
+	return d.kind
+}
+
+func (d *pixivDownloader) Rescan() {
+	select {
+	case d.rescanCh <- struct{}{}:
+	default:
+	}
+}
+
+// waitNextRound wait 'scan-interval-sec' or Rescan, return false if the ctx is done
+func (d *pixivDownloader) waitNextRound(ctx context.Context) bool {
+	duration := time.Duration(d.options.ScanIntervalSec) * time.Second
+	log.Infof("[%s] wait for next round after %s", d.name, duration)
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	case <-d.rescanCh:
+		log.Infof("[%s] rescan now", d.name)
+		return true
+	}
+}
+
+// sendInput send the input to the first worker and count it, return false if the ctx is done
+func sendInput[T any](ctx context.Context, d *pixivDownloader, ch chan<- T, input T) bool {
+	d.inputQueue.push(input)
+	select {
+	case ch <- input:
+		atomic.AddUint64(&d.inputCnt, 1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// enqueueInputs send the inputs to the first worker of a running downloader
+func enqueueInputs[T any](ctx context.Context, d *pixivDownloader, ch chan<- T, inputs []T) error {
+	runCtx := d.running()
+	if runCtx == nil {
+		return ErrDownloaderNotRunning
+	}
+	for _, input := range inputs {
+		d.inputQueue.push(input)
+		select {
+		case ch <- input:
+			atomic.AddUint64(&d.inputCnt, 1)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-runCtx.Done():
+			return ErrDownloaderNotRunning
+		}
+	}
+	log.Infof("[%s] Enqueue %d inputs", d.name, len(inputs))
+	return nil
+}
+
+func (d *pixivDownloader) addInputCnt() {
+	atomic.AddUint64(&d.inputCnt, 1)
+}
+
+func (d *pixivDownloader) getInputCnt() uint64 {
+	return atomic.LoadUint64(&d.inputCnt)
+}
+
+// resumeInputs send the inputs of the first worker left by last run, the ids which are sent in each round are skipped
+func resumeInputs[T any](ctx context.Context, d *pixivDownloader, ch chan<- T, ids []string) bool {
+	skip := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		skip[id] = struct{}{}
+	}
+	return resumeJobs(ctx, d.inputQueue, ch, func(key string) bool {
+		_, ok := skip[key]
+		return ok
+	}, d.addInputCnt)
+}
+
+func (d *pixivDownloader) stats(queues []QueueStats, workers []WorkerStats, paused bool) *DownloaderStats {
+	return &DownloaderStats{
+		Kind:     d.kind,
+		Running:  d.running() != nil,
+		Paused:   paused,
+		InputCnt: d.getInputCnt(),
+		Queues:   queues,
+		Workers:  workers,
+	}
+}
+
+func illustDigests(pids []string) []*pixiv.IllustDigest {
+	digests := make([]*pixiv.IllustDigest, 0, len(pids))
+	for _, pid := range pids {
+		digests = append(digests, &pixiv.IllustDigest{
+			Id:        pixiv.PixivID(pid),
+			PageCount: 1,
+		})
+	}
+	return digests
+}
+
+func pixivIds(ids []string) []pixiv.PixivID {
+	pixivIds := make([]pixiv.PixivID, 0, len(ids))
+	for _, id := range ids {
+		pixivIds = append(pixivIds, pixiv.PixivID(id))
+	}
+	return pixivIds
 }
 
 // IllustDownloader download the illust by pid
 type IllustDownloader struct {
+	*pixivDownloader
+
 	illustInfoWorker     *IllustInfoWorker
 	illustDownloadWorker *IllustDownloadWorker
 
-	options *PixivDlOptions
-
 	basicIllustChan chan *pixiv.IllustDigest
 	fullIllustChan  chan *pixiv.IllustInfo
 }
@@ -28,66 +295,108 @@ func NewIllustDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *
 	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
 
 	downloader := &IllustDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindIllust, "IllustDownloader", options, illustMgr, queueBasicIllust),
 		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
 		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
-		options:              options,
 		basicIllustChan:      basicIllustChan,
 		fullIllustChan:       fullIllustChan,
 	}
+	downloader.illustInfoWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
 	return downloader
 }
 
-func (d *IllustDownloader) waitDone(illustCnt uint64) {
+// resume send the jobs left by last run, the downstream first
+func (d *IllustDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.basicIllustChan, d.options.DownloadIllustIds)
+}
+
+func (d *IllustDownloader) waitDone(ctx context.Context) {
 	for {
-		if d.illustInfoWorker.GetConsumeCnt() == illustCnt &&
+		if d.illustInfoWorker.GetConsumeCnt() == d.getInputCnt() &&
 			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
-			d.illustInfoWorker.ResetCnt()
-			d.illustDownloadWorker.ResetCnt()
 			return
 		}
-		time.Sleep(1 * time.Second)
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
 	}
 }
 
-func (d *IllustDownloader) Start() {
-	if len(d.options.DownloadIllustIds) == 0 {
+func (d *IllustDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadIllustIds) == 0 && !d.options.ServiceMode {
 		return
 	}
 
-	d.illustInfoWorker.Run()
-	d.illustDownloadWorker.Run()
+	ctx = d.run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
 
 	for {
-		for _, pid := range d.options.DownloadIllustIds {
-			d.basicIllustChan <- &pixiv.IllustDigest{
-				Id:        pixiv.PixivID(pid),
-				PageCount: 1,
+		for _, digest := range illustDigests(d.options.DownloadIllustIds) {
+			if !sendInput(ctx, d.pixivDownloader, d.basicIllustChan, digest) {
+				return
 			}
 		}
 
-		d.waitDone(uint64(len(d.options.DownloadIllustIds)))
-		if !d.options.ServiceMode {
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
 			break
 		}
-		duration := time.Duration(d.options.ScanIntervalSec) * time.Second
-		log.Infof("[IllustDownloader] wait for next round after %s", duration)
-		time.Sleep(duration)
 	}
 }
 
+func (d *IllustDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.basicIllustChan, illustDigests(ids))
+}
+
+func (d *IllustDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *IllustDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *IllustDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
 func (d *IllustDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
 	close(d.basicIllustChan)
 	close(d.fullIllustChan)
 }
 
 // BookmarksDownloader download the illust of users bookmarks
 type BookmarksDownloader struct {
+	*pixivDownloader
+
 	bookmarksWorker      *BookmarksWorker
 	illustInfoWorker     *IllustInfoWorker
 	illustDownloadWorker *IllustDownloadWorker
 
-	options *PixivDlOptions
-
 	uidChan         chan pixiv.PixivID
 	basicIllustChan chan *pixiv.IllustDigest
 	fullIllustChan  chan *pixiv.IllustInfo
@@ -99,56 +408,104 @@ func NewBookmarksDownloader(options *PixivDlOptions, illustMgr IllustInfoManager
 	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
 
 	downloader := &BookmarksDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindBookmarks, "BookmarksDownloader", options, illustMgr, queueUid),
 		bookmarksWorker:      NewBookmarksWorker(options, illustMgr, uidChan, basicIllustChan),
 		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
 		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
-		options:              options,
 		uidChan:              uidChan,
 		basicIllustChan:      basicIllustChan,
 		fullIllustChan:       fullIllustChan,
 	}
+	downloader.bookmarksWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueBasicIllust))
+	downloader.illustInfoWorker.SetJobQueues(downloader.jobQueue(queueBasicIllust), downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
 	return downloader
 }
 
-func (d *BookmarksDownloader) waitDone(userCnt uint64) {
+// resume send the jobs left by last run, the downstream first
+func (d *BookmarksDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.illustInfoWorker.inputQueue, d.basicIllustChan, nil, d.bookmarksWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.uidChan, d.options.DownloadBookmarksUserIds)
+}
+
+func (d *BookmarksDownloader) waitDone(ctx context.Context) {
 	for {
-		if d.bookmarksWorker.GetConsumeCnt() == userCnt &&
+		if d.bookmarksWorker.GetConsumeCnt() == d.getInputCnt() &&
 			d.illustInfoWorker.GetConsumeCnt() == d.bookmarksWorker.GetProduceCnt() &&
 			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
-			d.bookmarksWorker.ResetCnt()
-			d.illustInfoWorker.ResetCnt()
-			d.illustDownloadWorker.ResetCnt()
 			return
 		}
-		time.Sleep(1 * time.Second)
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
 	}
 }
 
-func (d *BookmarksDownloader) Start() {
-	if len(d.options.DownloadBookmarksUserIds) == 0 {
+func (d *BookmarksDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadBookmarksUserIds) == 0 && !d.options.ServiceMode {
 		return
 	}
 
-	d.bookmarksWorker.Run()
-	d.illustInfoWorker.Run()
-	d.illustDownloadWorker.Run()
+	ctx = d.run(ctx)
+	d.bookmarksWorker.Run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
 
 	for {
-		for _, uid := range d.options.DownloadBookmarksUserIds {
-			d.uidChan <- pixiv.PixivID(uid)
+		for _, uid := range pixivIds(d.options.DownloadBookmarksUserIds) {
+			if !sendInput(ctx, d.pixivDownloader, d.uidChan, uid) {
+				return
+			}
 		}
 
-		d.waitDone(uint64(len(d.options.DownloadBookmarksUserIds)))
-		if !d.options.ServiceMode {
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
 			break
 		}
-		duration := time.Duration(d.options.ScanIntervalSec) * time.Second
-		log.Infof("[BookmarksDownloader] wait for next round after %s", duration)
-		time.Sleep(duration)
 	}
 }
 
+func (d *BookmarksDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.uidChan, pixivIds(ids))
+}
+
+func (d *BookmarksDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *BookmarksDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *BookmarksDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueUid, d.uidChan),
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("bookmarks", d.bookmarksWorker.pixivWorker),
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
 func (d *BookmarksDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.bookmarksWorker, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
 	close(d.uidChan)
 	close(d.basicIllustChan)
 	close(d.fullIllustChan)
@@ -156,12 +513,12 @@ func (d *BookmarksDownloader) Close() {
 
 // ArtistDownloader download all the illust of users
 type ArtistDownloader struct {
+	*pixivDownloader
+
 	artistWorker         *ArtistWorker
 	illustInfoWorker     *IllustInfoWorker
 	illustDownloadWorker *IllustDownloadWorker
 
-	options *PixivDlOptions
-
 	uidChan         chan pixiv.PixivID
 	basicIllustChan chan *pixiv.IllustDigest
 	fullIllustChan  chan *pixiv.IllustInfo
@@ -173,57 +530,612 @@ func NewArtistDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *
 	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
 
 	downloader := &ArtistDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindArtist, "ArtistDownloader", options, illustMgr, queueUid),
 		artistWorker:         NewArtistWorker(options, illustMgr, uidChan, basicIllustChan),
 		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
 		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
-		options:              options,
 		uidChan:              uidChan,
 		basicIllustChan:      basicIllustChan,
 		fullIllustChan:       fullIllustChan,
 	}
+	downloader.artistWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueBasicIllust))
+	downloader.illustInfoWorker.SetJobQueues(downloader.jobQueue(queueBasicIllust), downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
 	return downloader
 }
 
-func (d *ArtistDownloader) waitDone(userCnt uint64) {
+// resume send the jobs left by last run, the downstream first
+func (d *ArtistDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.illustInfoWorker.inputQueue, d.basicIllustChan, nil, d.artistWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.uidChan, d.options.DownloadArtistUserIds)
+}
+
+func (d *ArtistDownloader) waitDone(ctx context.Context) {
 	for {
-		if d.artistWorker.GetConsumeCnt() == userCnt &&
+		if d.artistWorker.GetConsumeCnt() == d.getInputCnt() &&
 			d.illustInfoWorker.GetConsumeCnt() == d.artistWorker.GetProduceCnt() &&
 			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
-			d.artistWorker.ResetCnt()
-			d.illustInfoWorker.ResetCnt()
-			d.illustDownloadWorker.ResetCnt()
 			return
 		}
-		time.Sleep(1 * time.Second)
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
 	}
 }
 
-func (d *ArtistDownloader) Start() {
-	if len(d.options.DownloadArtistUserIds) == 0 {
+func (d *ArtistDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadArtistUserIds) == 0 && !d.options.ServiceMode {
 		return
 	}
 
-	d.artistWorker.Run()
-	d.illustInfoWorker.Run()
-	d.illustDownloadWorker.Run()
+	ctx = d.run(ctx)
+	d.artistWorker.Run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
 
 	for {
-		for _, uid := range d.options.DownloadArtistUserIds {
-			d.uidChan <- pixiv.PixivID(uid)
+		for _, uid := range pixivIds(d.options.DownloadArtistUserIds) {
+			if !sendInput(ctx, d.pixivDownloader, d.uidChan, uid) {
+				return
+			}
 		}
 
-		d.waitDone(uint64(len(d.options.DownloadArtistUserIds)))
-		if !d.options.ServiceMode {
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
 			break
 		}
-		duration := time.Duration(d.options.ScanIntervalSec) * time.Second
-		log.Infof("[ArtistDownloader] wait for next round after %s", duration)
-		time.Sleep(duration)
 	}
 }
 
+func (d *ArtistDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.uidChan, pixivIds(ids))
+}
+
+func (d *ArtistDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *ArtistDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *ArtistDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueUid, d.uidChan),
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("artist", d.artistWorker.pixivWorker),
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
 func (d *ArtistDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.artistWorker, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
 	close(d.uidChan)
 	close(d.basicIllustChan)
 	close(d.fullIllustChan)
 }
+
+// FollowingDownloader download all the illust of users following users
+type FollowingDownloader struct {
+	*pixivDownloader
+
+	followingWorker      *FollowingWorker
+	artistWorker         *ArtistWorker
+	illustInfoWorker     *IllustInfoWorker
+	illustDownloadWorker *IllustDownloadWorker
+
+	uidChan         chan pixiv.PixivID
+	artistUidChan   chan pixiv.PixivID
+	basicIllustChan chan *pixiv.IllustDigest
+	fullIllustChan  chan *pixiv.IllustInfo
+}
+
+func NewFollowingDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *FollowingDownloader {
+	uidChan := make(chan pixiv.PixivID, 10)
+	artistUidChan := make(chan pixiv.PixivID, 50)
+	basicIllustChan := make(chan *pixiv.IllustDigest, 50)
+	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
+
+	downloader := &FollowingDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindFollowing, "FollowingDownloader", options, illustMgr, queueUid),
+		followingWorker:      NewFollowingWorker(options, illustMgr, uidChan, artistUidChan),
+		artistWorker:         NewArtistWorker(options, illustMgr, artistUidChan, basicIllustChan),
+		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
+		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
+		uidChan:              uidChan,
+		artistUidChan:        artistUidChan,
+		basicIllustChan:      basicIllustChan,
+		fullIllustChan:       fullIllustChan,
+	}
+	downloader.followingWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueArtistUid))
+	downloader.artistWorker.SetJobQueues(downloader.jobQueue(queueArtistUid), downloader.jobQueue(queueBasicIllust))
+	downloader.illustInfoWorker.SetJobQueues(downloader.jobQueue(queueBasicIllust), downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
+	return downloader
+}
+
+// resume send the jobs left by last run, the downstream first
+func (d *FollowingDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.illustInfoWorker.inputQueue, d.basicIllustChan, nil, d.artistWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.artistWorker.inputQueue, d.artistUidChan, nil, d.followingWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.uidChan, d.options.DownloadFollowingUserIds)
+}
+
+func (d *FollowingDownloader) waitDone(ctx context.Context) {
+	for {
+		if d.followingWorker.GetConsumeCnt() == d.getInputCnt() &&
+			d.artistWorker.GetConsumeCnt() == d.followingWorker.GetProduceCnt() &&
+			d.illustInfoWorker.GetConsumeCnt() == d.artistWorker.GetProduceCnt() &&
+			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
+			return
+		}
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+func (d *FollowingDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadFollowingUserIds) == 0 && !d.options.ServiceMode {
+		return
+	}
+
+	ctx = d.run(ctx)
+	d.followingWorker.Run(ctx)
+	d.artistWorker.Run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
+
+	for {
+		for _, uid := range pixivIds(d.options.DownloadFollowingUserIds) {
+			if !sendInput(ctx, d.pixivDownloader, d.uidChan, uid) {
+				return
+			}
+		}
+
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
+			break
+		}
+	}
+}
+
+func (d *FollowingDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.uidChan, pixivIds(ids))
+}
+
+func (d *FollowingDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *FollowingDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *FollowingDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueUid, d.uidChan),
+			queueStats(queueArtistUid, d.artistUidChan),
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("following", d.followingWorker.pixivWorker),
+			workerStats("artist", d.artistWorker.pixivWorker),
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
+func (d *FollowingDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.followingWorker, d.artistWorker, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
+	close(d.uidChan)
+	close(d.artistUidChan)
+	close(d.basicIllustChan)
+	close(d.fullIllustChan)
+}
+
+// SearchDownloader download the illust of the keyword search result
+type SearchDownloader struct {
+	*pixivDownloader
+
+	searchWorker         *SearchWorker
+	illustInfoWorker     *IllustInfoWorker
+	illustDownloadWorker *IllustDownloadWorker
+
+	keywordChan     chan string
+	basicIllustChan chan *pixiv.IllustDigest
+	fullIllustChan  chan *pixiv.IllustInfo
+}
+
+func NewSearchDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *SearchDownloader {
+	keywordChan := make(chan string, 10)
+	basicIllustChan := make(chan *pixiv.IllustDigest, 50)
+	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
+
+	downloader := &SearchDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindSearch, "SearchDownloader", options, illustMgr, queueKeyword),
+		searchWorker:         NewSearchWorker(options, illustMgr, keywordChan, basicIllustChan),
+		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
+		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
+		keywordChan:          keywordChan,
+		basicIllustChan:      basicIllustChan,
+		fullIllustChan:       fullIllustChan,
+	}
+	downloader.searchWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueBasicIllust))
+	downloader.illustInfoWorker.SetJobQueues(downloader.jobQueue(queueBasicIllust), downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
+	return downloader
+}
+
+// resume send the jobs left by last run, the downstream first
+func (d *SearchDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.illustInfoWorker.inputQueue, d.basicIllustChan, nil, d.searchWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.keywordChan, d.options.DownloadSearchKeywords)
+}
+
+func (d *SearchDownloader) waitDone(ctx context.Context) {
+	for {
+		if d.searchWorker.GetConsumeCnt() == d.getInputCnt() &&
+			d.illustInfoWorker.GetConsumeCnt() == d.searchWorker.GetProduceCnt() &&
+			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
+			return
+		}
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+func (d *SearchDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadSearchKeywords) == 0 && !d.options.ServiceMode {
+		return
+	}
+
+	ctx = d.run(ctx)
+	d.searchWorker.Run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
+
+	for {
+		for _, keyword := range d.options.DownloadSearchKeywords {
+			if !sendInput(ctx, d.pixivDownloader, d.keywordChan, keyword) {
+				return
+			}
+		}
+
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
+			break
+		}
+	}
+}
+
+// Enqueue search the keywords immediately
+func (d *SearchDownloader) Enqueue(ctx context.Context, keywords []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.keywordChan, keywords)
+}
+
+func (d *SearchDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *SearchDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *SearchDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueKeyword, d.keywordChan),
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("search", d.searchWorker.pixivWorker),
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
+func (d *SearchDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.searchWorker, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
+	close(d.keywordChan)
+	close(d.basicIllustChan)
+	close(d.fullIllustChan)
+}
+
+// RankingDownloader download the illust of the rankings, in service mode every new day of the rankings is downloaded
+type RankingDownloader struct {
+	*pixivDownloader
+
+	rankingWorker        *RankingWorker
+	illustInfoWorker     *IllustInfoWorker
+	illustDownloadWorker *IllustDownloadWorker
+
+	modeChan        chan string
+	basicIllustChan chan *pixiv.IllustDigest
+	fullIllustChan  chan *pixiv.IllustInfo
+}
+
+func NewRankingDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *RankingDownloader {
+	modeChan := make(chan string, 10)
+	basicIllustChan := make(chan *pixiv.IllustDigest, 50)
+	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
+
+	downloader := &RankingDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindRanking, "RankingDownloader", options, illustMgr, queueRankingMode),
+		rankingWorker:        NewRankingWorker(options, illustMgr, modeChan, basicIllustChan),
+		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
+		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
+		modeChan:             modeChan,
+		basicIllustChan:      basicIllustChan,
+		fullIllustChan:       fullIllustChan,
+	}
+	downloader.rankingWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueBasicIllust))
+	downloader.illustInfoWorker.SetJobQueues(downloader.jobQueue(queueBasicIllust), downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
+	return downloader
+}
+
+// resume send the jobs left by last run, the downstream first
+func (d *RankingDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.illustInfoWorker.inputQueue, d.basicIllustChan, nil, d.rankingWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.modeChan, d.options.DownloadRankingModes)
+}
+
+func (d *RankingDownloader) waitDone(ctx context.Context) {
+	for {
+		if d.rankingWorker.GetConsumeCnt() == d.getInputCnt() &&
+			d.illustInfoWorker.GetConsumeCnt() == d.rankingWorker.GetProduceCnt() &&
+			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
+			return
+		}
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+func (d *RankingDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadRankingModes) == 0 && !d.options.ServiceMode {
+		return
+	}
+
+	ctx = d.run(ctx)
+	d.rankingWorker.Run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
+
+	for {
+		for _, mode := range d.options.DownloadRankingModes {
+			if !sendInput(ctx, d.pixivDownloader, d.modeChan, mode) {
+				return
+			}
+		}
+
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
+			break
+		}
+	}
+}
+
+// Enqueue download the rankings of the modes immediately
+func (d *RankingDownloader) Enqueue(ctx context.Context, modes []string) error {
+	for _, mode := range modes {
+		if !IsValidRankingMode(mode) {
+			return fmt.Errorf("not supported ranking mode '%s'", mode)
+		}
+	}
+	return enqueueInputs(ctx, d.pixivDownloader, d.modeChan, modes)
+}
+
+func (d *RankingDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *RankingDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *RankingDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueRankingMode, d.modeChan),
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("ranking", d.rankingWorker.pixivWorker),
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
+func (d *RankingDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.rankingWorker, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
+	close(d.modeChan)
+	close(d.basicIllustChan)
+	close(d.fullIllustChan)
+}
+
+// SeriesDownloader download all the episodes of the manga series in order
+type SeriesDownloader struct {
+	*pixivDownloader
+
+	seriesWorker         *SeriesWorker
+	illustInfoWorker     *IllustInfoWorker
+	illustDownloadWorker *IllustDownloadWorker
+
+	seriesIdChan    chan pixiv.PixivID
+	basicIllustChan chan *pixiv.IllustDigest
+	fullIllustChan  chan *pixiv.IllustInfo
+}
+
+func NewSeriesDownloader(options *PixivDlOptions, illustMgr IllustInfoManager) *SeriesDownloader {
+	seriesIdChan := make(chan pixiv.PixivID, 10)
+	basicIllustChan := make(chan *pixiv.IllustDigest, 50)
+	fullIllustChan := make(chan *pixiv.IllustInfo, 100)
+
+	downloader := &SeriesDownloader{
+		pixivDownloader:      newPixivDownloader(DownloaderKindSeries, "SeriesDownloader", options, illustMgr, queueSeriesId),
+		seriesWorker:         NewSeriesWorker(options, illustMgr, seriesIdChan, basicIllustChan),
+		illustInfoWorker:     NewIllustInfoWorker(options, illustMgr, basicIllustChan, fullIllustChan),
+		illustDownloadWorker: NewIllustDownloadWorker(options, illustMgr, fullIllustChan),
+		seriesIdChan:         seriesIdChan,
+		basicIllustChan:      basicIllustChan,
+		fullIllustChan:       fullIllustChan,
+	}
+	downloader.seriesWorker.SetJobQueues(downloader.inputQueue, downloader.jobQueue(queueBasicIllust))
+	downloader.illustInfoWorker.SetJobQueues(downloader.jobQueue(queueBasicIllust), downloader.jobQueue(queueFullIllust))
+	downloader.illustDownloadWorker.SetJobQueues(downloader.jobQueue(queueFullIllust), nil)
+	return downloader
+}
+
+// resume send the jobs left by last run, the downstream first
+func (d *SeriesDownloader) resume(ctx context.Context) bool {
+	return resumeJobs(ctx, d.illustDownloadWorker.inputQueue, d.fullIllustChan, nil, d.illustInfoWorker.addProduceCnt) &&
+		resumeJobs(ctx, d.illustInfoWorker.inputQueue, d.basicIllustChan, nil, d.seriesWorker.addProduceCnt) &&
+		resumeInputs(ctx, d.pixivDownloader, d.seriesIdChan, d.options.DownloadSeriesIds)
+}
+
+func (d *SeriesDownloader) waitDone(ctx context.Context) {
+	for {
+		if d.seriesWorker.GetConsumeCnt() == d.getInputCnt() &&
+			d.illustInfoWorker.GetConsumeCnt() == d.seriesWorker.GetProduceCnt() &&
+			d.illustDownloadWorker.GetConsumeCnt() == d.illustInfoWorker.GetProduceCnt() {
+			return
+		}
+		if !SleepContext(ctx, 1*time.Second) {
+			return
+		}
+	}
+}
+
+func (d *SeriesDownloader) Start(ctx context.Context) {
+	if len(d.options.DownloadSeriesIds) == 0 && !d.options.ServiceMode {
+		return
+	}
+
+	ctx = d.run(ctx)
+	d.seriesWorker.Run(ctx)
+	d.illustInfoWorker.Run(ctx)
+	d.illustDownloadWorker.Run(ctx)
+	if !d.resume(ctx) {
+		return
+	}
+
+	for {
+		for _, seriesId := range pixivIds(d.options.DownloadSeriesIds) {
+			if !sendInput(ctx, d.pixivDownloader, d.seriesIdChan, seriesId) {
+				return
+			}
+		}
+
+		d.waitDone(ctx)
+		if !d.options.ServiceMode || ctx.Err() != nil {
+			break
+		}
+		if !d.waitNextRound(ctx) {
+			break
+		}
+	}
+}
+
+func (d *SeriesDownloader) Enqueue(ctx context.Context, ids []string) error {
+	return enqueueInputs(ctx, d.pixivDownloader, d.seriesIdChan, pixivIds(ids))
+}
+
+func (d *SeriesDownloader) Pause() {
+	d.illustDownloadWorker.Pause()
+}
+
+func (d *SeriesDownloader) Resume() {
+	d.illustDownloadWorker.Resume()
+}
+
+func (d *SeriesDownloader) Stats() *DownloaderStats {
+	return d.stats(
+		[]QueueStats{
+			queueStats(queueSeriesId, d.seriesIdChan),
+			queueStats(queueBasicIllust, d.basicIllustChan),
+			queueStats(queueFullIllust, d.fullIllustChan),
+		},
+		[]WorkerStats{
+			workerStats("series", d.seriesWorker.pixivWorker),
+			workerStats("illust_info", d.illustInfoWorker.pixivWorker),
+			workerStats("illust_download", d.illustDownloadWorker.pixivWorker),
+		},
+		d.illustDownloadWorker.IsPaused())
+}
+
+func (d *SeriesDownloader) Close() {
+	timeout := time.Duration(d.options.ShutdownTimeoutSec) * time.Second
+	if !d.stop(timeout, d.seriesWorker, d.illustInfoWorker, d.illustDownloadWorker) {
+		// some worker is still running and may write to the channels, do not close them
+		d.illustDownloadWorker.RemovePartialFiles()
+		return
+	}
+	close(d.seriesIdChan)
+	close(d.basicIllustChan)
+	close(d.fullIllustChan)
+}
@@ -0,0 +1,517 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+const (
+	pixivHost    = "https://www.pixiv.net"
+	pixivReferer = "https://www.pixiv.net/"
+
+	partialFileSuffix = ".part"
+)
+
+// PixivWebClient request the pixiv web ajax api which is not supported by pixiv.PixivClient
+type PixivWebClient struct {
+	client    *http.Client
+	cookie    string
+	userAgent string
+}
+
+type pixivAjaxResponse struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+func NewPixivWebClient(options *PixivDlOptions, account *PixivAccount, timeout int32) *PixivWebClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(account.Proxy) > 0 {
+		proxy, _ := url.Parse(account.Proxy)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	client := &PixivWebClient{
+		client: &http.Client{
+			Transport: &metricsTransport{next: &rateLimitTransport{next: transport, limiter: SharedRateLimiter(options)}},
+			Timeout:   time.Duration(timeout) * time.Millisecond,
+		},
+		userAgent: account.UserAgent,
+	}
+	if len(account.Cookie) > 0 {
+		if strings.Contains(account.Cookie, "=") {
+			client.cookie = account.Cookie
+		} else {
+			client.cookie = "PHPSESSID=" + account.Cookie
+		}
+	}
+	return client
+}
+
+// newRequest create the request bound to ctx, so that the in-flight request is cancelled when the worker exits
+func (c *PixivWebClient) newRequest(ctx context.Context, method, rawUrl string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Referer", pixivReferer)
+	if len(c.userAgent) > 0 {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if len(c.cookie) > 0 {
+		req.Header.Set("Cookie", c.cookie)
+	}
+	return req, nil
+}
+
+// AjaxError is the error message responded by the pixiv ajax api
+type AjaxError struct {
+	Message string
+	Url     string
+}
+
+func (e *AjaxError) Error() string {
+	return fmt.Sprintf("%s, url: %s", e.Message, e.Url)
+}
+
+// GetAjax request the pixiv ajax api and unmarshal the response body to result
+func (c *PixivWebClient) GetAjax(ctx context.Context, path string, query url.Values, result interface{}) error {
+	_, err := c.getAjax(ctx, path, query, result)
+	return err
+}
+
+// getAjax is GetAjax and also return the response header
+func (c *PixivWebClient) getAjax(ctx context.Context, path string, query url.Values, result interface{}) (http.Header, error) {
+	rawUrl := pixivHost + path
+	if len(query) > 0 {
+		rawUrl += "?" + query.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.Header, fmt.Errorf("%w, url: %s", pixiv.ErrNotFound, rawUrl)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.Header, &HttpStatusError{Code: resp.StatusCode, Url: rawUrl}
+	}
+
+	var ajaxResp pixivAjaxResponse
+	err = json.Unmarshal(data, &ajaxResp)
+	if err != nil {
+		return resp.Header, err
+	}
+	if ajaxResp.Error {
+		return resp.Header, &AjaxError{Message: ajaxResp.Message, Url: rawUrl}
+	}
+	if result == nil {
+		return resp.Header, nil
+	}
+	return resp.Header, json.Unmarshal(ajaxResp.Body, result)
+}
+
+// LoginStatus is whose account the cookie belongs to, UserId and UserName are empty if not logged in
+type LoginStatus struct {
+	LoggedIn bool
+	UserId   string
+	UserName string
+}
+
+// GetLoginStatus check whether the cookie is logged in. The ajax api only for the logged in user is requested,
+// pixiv responds the user id in the 'x-userid' header if logged in, otherwise 401 or an error message.
+func (c *PixivWebClient) GetLoginStatus(ctx context.Context) (*LoginStatus, error) {
+	header, err := c.getAjax(ctx, "/ajax/user/extra", nil, nil)
+	var (
+		statusErr *HttpStatusError
+		ajaxErr   *AjaxError
+	)
+	if errors.As(err, &ajaxErr) ||
+		(errors.As(err, &statusErr) && (statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden)) {
+		return &LoginStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid := header.Get("x-userid")
+	if len(uid) == 0 {
+		return &LoginStatus{}, nil
+	}
+
+	status := &LoginStatus{LoggedIn: true, UserId: uid}
+	var user struct {
+		Name string `json:"name"`
+	}
+	err = c.GetAjax(ctx, fmt.Sprintf("/ajax/user/%s", uid), nil, &user)
+	if err != nil {
+		// the name is only for display
+		return status, nil
+	}
+	status.UserName = user.Name
+	return status, nil
+}
+
+// GetUgoiraMeta get the frames zip url and the frame delays of an ugoira
+func (c *PixivWebClient) GetUgoiraMeta(ctx context.Context, id pixiv.PixivID) (*UgoiraMeta, error) {
+	var meta UgoiraMeta
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/illust/%s/ugoira_meta", id), nil, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// GetIllustTags get the tags of the illust with the translations
+func (c *PixivWebClient) GetIllustTags(ctx context.Context, id pixiv.PixivID) ([]IllustTag, error) {
+	var body struct {
+		Tags struct {
+			Tags []IllustTag `json:"tags"`
+		} `json:"tags"`
+	}
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/illust/%s", id), nil, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body.Tags.Tags, nil
+}
+
+// GetNovelInfo get the novel with the text content
+func (c *PixivWebClient) GetNovelInfo(ctx context.Context, id pixiv.PixivID) (*NovelInfo, error) {
+	var body novelAjaxBody
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/novel/%s", id), nil, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body.novelInfo(), nil
+}
+
+// GetUserNovels get the ids of all the novels of the user
+func (c *PixivWebClient) GetUserNovels(ctx context.Context, uid pixiv.PixivID) ([]pixiv.PixivID, error) {
+	var body struct {
+		Novels json.RawMessage `json:"novels"`
+	}
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/user/%s/profile/all", uid), nil, &body)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]pixiv.PixivID, 0)
+	if !isJsonObject(body.Novels) {
+		return ids, nil
+	}
+	var novels map[string]json.RawMessage
+	err = json.Unmarshal(body.Novels, &novels)
+	if err != nil {
+		return nil, err
+	}
+	for id := range novels {
+		ids = append(ids, pixiv.PixivID(id))
+	}
+	// newest first, the same as the illust
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) > len(ids[j])
+		}
+		return ids[i] > ids[j]
+	})
+	return ids, nil
+}
+
+// GetUserBookmarks get a page of the illust bookmarks of the user, the private bookmarks are only visible to the
+// owner of the cookie. tag is the bookmark tag, empty means all the tags and '未分類' means the bookmarks without tag.
+func (c *PixivWebClient) GetUserBookmarks(ctx context.Context, uid pixiv.PixivID, tag string, private bool, offset, limit int32) (*BookmarksPage, error) {
+	var body bookmarksAjaxBody
+	scope := bookmarksScope{uid: uid, private: private, tag: tag}
+	query := url.Values{}
+	query.Set("tag", tag)
+	query.Set("offset", strconv.Itoa(int(offset)))
+	query.Set("limit", strconv.Itoa(int(limit)))
+	query.Set("rest", scope.rest())
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/user/%s/illusts/bookmarks", uid), query, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body.bookmarksPage(uid, private), nil
+}
+
+// GetNovelBookmarks get a page of the public novel bookmarks of the user
+func (c *PixivWebClient) GetNovelBookmarks(ctx context.Context, uid pixiv.PixivID, offset, limit int32) (*NovelBookmarks, error) {
+	var body struct {
+		Works []struct {
+			Id json.Number `json:"id"`
+		} `json:"works"`
+		Total int32 `json:"total"`
+	}
+	query := url.Values{}
+	query.Set("tag", "")
+	query.Set("offset", strconv.Itoa(int(offset)))
+	query.Set("limit", strconv.Itoa(int(limit)))
+	query.Set("rest", "show")
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/user/%s/novels/bookmarks", uid), query, &body)
+	if err != nil {
+		return nil, err
+	}
+	bookmarks := &NovelBookmarks{Total: body.Total}
+	for _, work := range body.Works {
+		bookmarks.Ids = append(bookmarks.Ids, pixiv.PixivID(work.Id))
+	}
+	return bookmarks, nil
+}
+
+// GetSearchIllusts get a page of the illust search result, page starts from 1
+func (c *PixivWebClient) GetSearchIllusts(ctx context.Context, keyword string, params *SearchParams, page int) (*SearchResult, error) {
+	var body searchAjaxBody
+	err := c.GetAjax(ctx, "/ajax/search/artworks/"+url.PathEscape(keyword), params.query(keyword, page), &body)
+	if err != nil {
+		return nil, err
+	}
+	result := &SearchResult{Total: body.IllustManga.Total, LastPage: body.IllustManga.LastPage}
+	for _, data := range body.IllustManga.Data {
+		if len(data.Id) == 0 {
+			continue
+		}
+		pageCount := data.PageCount
+		if pageCount <= 0 {
+			pageCount = 1
+		}
+		result.Illusts = append(result.Illusts, &pixiv.IllustDigest{
+			Id:        pixiv.PixivID(data.Id),
+			UserId:    pixiv.PixivID(data.UserId),
+			PageCount: pageCount,
+		})
+	}
+	return result, nil
+}
+
+// GetRanking get a page of the ranking, page starts from 1, date is in 'yyyy-mm-dd' or empty for the latest ranking.
+// pixiv responds 400 with an error message if the ranking of the date is not published yet or the page is out of
+// range, it's returned as pixiv.ErrNotFound.
+func (c *PixivWebClient) GetRanking(ctx context.Context, mode, date string, page int) (*RankingPage, error) {
+	query := url.Values{}
+	query.Set("mode", rankingModes[mode])
+	query.Set("format", "json")
+	query.Set("p", strconv.Itoa(page))
+	if len(date) > 0 {
+		query.Set("date", strings.ReplaceAll(date, "-", ""))
+	}
+	rawUrl := pixivHost + "/ranking.php?" + query.Encode()
+	req, err := c.newRequest(ctx, http.MethodGet, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w, url: %s", pixiv.ErrNotFound, rawUrl)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var body rankingResponse
+	if resp.StatusCode == http.StatusBadRequest && json.Unmarshal(data, &body) == nil && len(body.Error) > 0 {
+		return nil, fmt.Errorf("%w, %s, url: %s", pixiv.ErrNotFound, body.Error, rawUrl)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HttpStatusError{Code: resp.StatusCode, Url: rawUrl}
+	}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.Error) > 0 {
+		return nil, &AjaxError{Message: body.Error, Url: rawUrl}
+	}
+	return body.rankingPage(mode), nil
+}
+
+// GetMangaSeries get a page of the episodes of the manga series, page starts from 1
+func (c *PixivWebClient) GetMangaSeries(ctx context.Context, seriesId pixiv.PixivID, page int) (*SeriesPage, error) {
+	var body seriesAjaxBody
+	query := url.Values{}
+	query.Set("p", strconv.Itoa(page))
+	err := c.GetAjax(ctx, fmt.Sprintf("/ajax/series/%s", seriesId), query, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body.seriesPage(seriesId), nil
+}
+
+// DownloadBytes download the small file such as the novel cover into memory
+func (c *PixivWebClient) DownloadBytes(ctx context.Context, rawUrl string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w, url: %s", pixiv.ErrNotFound, rawUrl)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HttpStatusError{Code: resp.StatusCode, Url: rawUrl}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// DownloadFile download the url to filename and return the file size. The content is written to 'filename.part'
+// first and renamed to filename only after the whole content is received, the '.part' file is kept if failed so
+// that the next download can resume from it by the HTTP Range header. The mtime of the kept '.part' file is set to
+// the Last-Modified of the response and sent as If-Range, so that the server responds the whole file instead of
+// the range if the file is changed since then.
+func (c *PixivWebClient) DownloadFile(ctx context.Context, rawUrl, filename string) (int64, error) {
+	partFilename := filename + partialFileSuffix
+	var (
+		offset  int64
+		modTime time.Time
+	)
+	if stat, err := os.Stat(partFilename); err == nil {
+		offset = stat.Size()
+		modTime = stat.ModTime()
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, rawUrl)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	total := int64(-1)
+	flag := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// the server ignores the Range header or the file is changed, download from the beginning
+		offset = 0
+		total = resp.ContentLength
+		flag |= os.O_TRUNC
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, err
+		}
+		if start != offset {
+			return 0, fmt.Errorf("unexpected content range start %d, expected %d, url: %s", start, offset, rawUrl)
+		}
+		total = size
+		flag |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the '.part' file may be complete if the process exited before rename, otherwise it's broken
+		_, size, _ := parseContentRange(resp.Header.Get("Content-Range"))
+		if size == offset {
+			return offset, os.Rename(partFilename, filename)
+		}
+		_ = os.Remove(partFilename)
+		return 0, fmt.Errorf("range not satisfiable, offset: %d, url: %s", offset, rawUrl)
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("%w, url: %s", pixiv.ErrNotFound, rawUrl)
+	default:
+		return 0, &HttpStatusError{Code: resp.StatusCode, Url: rawUrl}
+	}
+
+	file, err := os.OpenFile(partFilename, flag, 0644)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// the '.part' file without Last-Modified never matches If-Range and is downloaded again from the beginning
+		if lastModified, parseErr := http.ParseTime(resp.Header.Get("Last-Modified")); parseErr == nil {
+			_ = os.Chtimes(partFilename, lastModified, lastModified)
+		}
+		return 0, err
+	}
+
+	size := offset + written
+	if total >= 0 && size != total {
+		return 0, fmt.Errorf("content length mismatch, expected: %d, received: %d, url: %s", total, size, rawUrl)
+	}
+	err = os.Rename(partFilename, filename)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// parseContentRange parse the Content-Range header like 'bytes 100-199/200' or 'bytes */200', the total size
+// is -1 if unknown
+func parseContentRange(contentRange string) (int64, int64, error) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, -1, fmt.Errorf("invalid content range '%s'", contentRange)
+	}
+	rangeSpec, totalSpec, ok := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !ok {
+		return 0, -1, fmt.Errorf("invalid content range '%s'", contentRange)
+	}
+
+	total := int64(-1)
+	if totalSpec != "*" {
+		size, err := strconv.ParseInt(totalSpec, 10, 64)
+		if err != nil {
+			return 0, -1, fmt.Errorf("invalid content range '%s'", contentRange)
+		}
+		total = size
+	}
+	if rangeSpec == "*" {
+		return 0, total, nil
+	}
+	startSpec, _, ok := strings.Cut(rangeSpec, "-")
+	if !ok {
+		return 0, total, fmt.Errorf("invalid content range '%s'", contentRange)
+	}
+	start, err := strconv.ParseInt(startSpec, 10, 64)
+	if err != nil {
+		return 0, total, fmt.Errorf("invalid content range '%s'", contentRange)
+	}
+	return start, total, nil
+}
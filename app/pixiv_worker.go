@@ -1,11 +1,13 @@
 package app
 
 import (
+	"context"
 	"errors"
-	"math/rand"
-	"net/url"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,51 +22,131 @@ func isJsonUnmarshalError(err error) bool {
 	return ok
 }
 
-const BookmarksPageLimit = 48
+const (
+	BookmarksPageLimit = 48
+	FollowingPageLimit = 24
+)
 
 type PixivWorker interface {
-	Run()
+	// Run start the worker goroutines, they exit when the ctx is done or the input channel is closed
+	Run(ctx context.Context)
+	// Wait wait all the worker goroutines exit
+	Wait()
 }
 
 type pixivWorker struct {
 	options   *PixivDlOptions
 	illustMgr IllustInfoManager
-	client    *pixiv.PixivClient
+	pool      *AccountPool
+	accounts  []*accountClient // the clients of every account in the pool
+	limiter   *RateLimiter
 
 	userWhiteListFilter mapset.Set[pixiv.PixivID]
 	userBlockListFilter mapset.Set[pixiv.PixivID]
+	tagFilter           *TagFilter
+	illustFilter        *IllustFilter
 
 	consumeCnt uint64
 	produceCnt uint64
+
+	// inputQueue and outputQueue persist the inputs and outputs to resume after restart, nil if not persisted
+	inputQueue  *jobQueue
+	outputQueue *jobQueue
+
+	inflightFiles sync.Map          // the files being written
+	reservations  *fileReservations // the filenames being used by the downloading illust and novel
+
+	wg sync.WaitGroup
+}
+
+// accountClient is the clients of an account in the AccountPool
+type accountClient struct {
+	idx       int
+	name      string
+	client    *pixiv.PixivClient
+	webClient *PixivWebClient
+}
+
+// pauseGate block the workers when paused until resumed
+type pauseGate struct {
+	mu     sync.Mutex
+	resume chan struct{} // closed when resumed, nil if not paused
+}
+
+// Pause return false if already paused
+func (g *pauseGate) Pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		return false
+	}
+	g.resume = make(chan struct{})
+	return true
+}
+
+// Resume return false if not paused
+func (g *pauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		return false
+	}
+	close(g.resume)
+	g.resume = nil
+	return true
+}
+
+func (g *pauseGate) IsPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resume != nil
+}
+
+// Wait block until resumed if paused, return false if the ctx is done
+func (g *pauseGate) Wait(ctx context.Context) bool {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume == nil {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func newPixivWorker(options *PixivDlOptions, manager IllustInfoManager, timeout int32) *pixivWorker {
 	worker := &pixivWorker{
 		options:             options,
 		illustMgr:           manager,
+		pool:                SharedAccountPool(options),
+		limiter:             SharedRateLimiter(options),
 		userWhiteListFilter: mapset.NewSet[pixiv.PixivID](),
 		userBlockListFilter: mapset.NewSet[pixiv.PixivID](),
+		tagFilter:           NewTagFilter(options),
 		consumeCnt:          0,
 		produceCnt:          0,
+		reservations:        sharedFileReservations(),
 	}
 
-	if len(options.Proxy) > 0 {
-		proxy, _ := url.Parse(options.Proxy)
-		worker.client = pixiv.NewPixivClientWithProxy(proxy, timeout)
-	} else {
-		worker.client = pixiv.NewPixivClient(timeout)
-	}
-	if len(options.Cookie) > 0 {
-		cookieKV := strings.Split(options.Cookie, "=")
-		if len(cookieKV) == 2 {
-			worker.client.AddCookie(cookieKV[0], cookieKV[1])
-		} else {
-			worker.client.SetCookiePHPSESSID(options.Cookie)
-		}
+	for idx, account := range worker.pool.Accounts() {
+		account := account
+		worker.accounts = append(worker.accounts, &accountClient{
+			idx:       idx,
+			name:      account.Name,
+			client:    NewPixivClient(&account, timeout),
+			webClient: NewPixivWebClient(options, &account, timeout),
+		})
 	}
-	if len(options.UserAgent) > 0 {
-		worker.client.SetUserAgent(options.UserAgent)
+
+	illustFilter, err := NewIllustFilter(FilterExpression(options))
+	if err != nil {
+		log.Fatalf("Failed to parse filter, msg: %s", err)
 	}
+	worker.illustFilter = illustFilter
 
 	for _, uid := range options.UserWhiteList {
 		worker.userWhiteListFilter.Add(pixiv.PixivID(uid))
@@ -75,21 +157,52 @@ func newPixivWorker(options *PixivDlOptions, manager IllustInfoManager, timeout
 	return worker
 }
 
-func (w *pixivWorker) retry(workFunc func() bool) {
+// retry run the workFunc until it returns true, return false if exceed the max retries or the ctx is done. The
+// backoff grows exponentially from 'retry-backoff-ms' to 'retry-max-backoff-ms'.
+func (w *pixivWorker) retry(ctx context.Context, workFunc func() bool) bool {
 	var retryTime int32 = 0
 	for {
+		if ctx.Err() != nil {
+			return false
+		}
 		ok := workFunc()
 		if ok {
-			break
+			return true
 		}
 		if retryTime >= w.options.MaxRetries {
-			break
+			return false
 		}
+		backoff := backoffDuration(time.Duration(w.options.RetryBackoffMs)*time.Millisecond,
+			time.Duration(w.options.RetryMaxBackoffMs)*time.Millisecond, retryTime)
 		retryTime++
-		r := rand.New(rand.NewSource(int64(time.Now().Nanosecond())))
-		backoff := w.options.RetryBackoffMs + r.Int31n(w.options.RetryBackoffMs/10)
-		time.Sleep(time.Duration(backoff) * time.Millisecond)
+		retryCnt.Inc()
+		if !SleepContext(ctx, backoff) {
+			return false
+		}
+	}
+}
+
+// acquireAccount return the clients of the next available account, return false if the ctx is done
+func (w *pixivWorker) acquireAccount(ctx context.Context) (*accountClient, bool) {
+	idx, err := w.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false
 	}
+	return w.accounts[idx], true
+}
+
+// reportAccount report the result of the request with the account, not found is not the fault of the account
+func (w *pixivWorker) reportAccount(account *accountClient, err error) {
+	if errors.Is(err, pixiv.ErrNotFound) {
+		err = nil
+	}
+	w.pool.Report(account.idx, err)
+}
+
+// waitApi block until the shared rate limiter allows a pixiv api request, return false if the ctx is done. The
+// requests of PixivWebClient are throttled by its transport and must not wait here again.
+func (w *pixivWorker) waitApi(ctx context.Context) bool {
+	return w.limiter.WaitApi(ctx) == nil
 }
 
 // filterByUser return true means this illust should be skipped
@@ -101,45 +214,66 @@ func (w *pixivWorker) filterByUser(illustInfo *pixiv.IllustDigest) bool {
 
 	if w.userWhiteListFilter.Cardinality() > 0 && !w.userWhiteListFilter.Contains(illustInfo.UserId) {
 		log.Debugf("[PixivWorker] Skip illust by UserWhiteList, %s", illustInfo.DigestString())
+		illustFilteredCnt.WithLabelValues(FilterReasonUser).Inc()
 		return true
 	}
 
 	if w.userBlockListFilter.Cardinality() > 0 && w.userBlockListFilter.Contains(illustInfo.UserId) {
 		log.Infof("[PixivWorker] Skip illust by UserBlockList, %s", illustInfo.DigestString())
+		illustFilteredCnt.WithLabelValues(FilterReasonUser).Inc()
 		return true
 	}
 
 	return false
 }
 
-func (w *pixivWorker) filterByIllustInfo(illust *pixiv.IllustInfo) bool {
-	if w.options.NoR18 && illust.R18 {
-		log.Infof("[PixivWorker] Skip R18 illust: %s", illust.DigestString())
-		return true
-	}
-	if w.options.OnlyP0 && illust.PageIdx > 0 {
-		log.Infof("[PixivWorker] Skip no p0 illust: %s", illust.DigestString())
+// filterByUid return true means all illust of this user should be skipped
+func (w *pixivWorker) filterByUid(uid pixiv.PixivID) bool {
+	if w.userWhiteListFilter.Cardinality() > 0 && !w.userWhiteListFilter.Contains(uid) {
+		log.Debugf("[PixivWorker] Skip user by UserWhiteList, uid: %s", uid)
 		return true
 	}
 
-	if w.options.BookmarkGt > 0 && illust.BookmarkCount > 0 && illust.BookmarkCount < w.options.BookmarkGt {
-		log.Infof("[PixivWorker] Skip illust by bookmark count: %s", illust.DigestString())
-		return true
-	}
-	if w.options.LikeGt > 0 && illust.LikeCount > 0 && illust.LikeCount < w.options.LikeGt {
-		log.Infof("[PixivWorker] Skip illust by like count: %s", illust.DigestString())
+	if w.userBlockListFilter.Cardinality() > 0 && w.userBlockListFilter.Contains(uid) {
+		log.Infof("[PixivWorker] Skip user by UserBlockList, uid: %s", uid)
 		return true
 	}
 
-	if w.options.PixelGt > 0 && illust.Width > 0 && illust.Height > 0 &&
-		illust.Width < w.options.PixelGt && illust.Height < w.options.PixelGt {
-		log.Infof("[PixivWorker] Skip illust by width or height: %s", illust.DigestString())
+	return false
+}
+
+func (w *pixivWorker) filterByIllustInfo(illust *pixiv.IllustInfo) bool {
+	if !w.illustFilter.Match(illust) {
+		log.Infof("[PixivWorker] Skip illust by filter '%s': %s", w.illustFilter, illust.DigestString())
+		illustFilteredCnt.WithLabelValues(FilterReasonFilter).Inc()
 		return true
 	}
-
 	return false
 }
 
+// filterByTags return true if the illust should be skipped by the tag white list and block list, the translated
+// tags are requested only if needed
+func (w *pixivWorker) filterByTags(ctx context.Context, account *accountClient, illust *pixiv.IllustInfo) (bool, error) {
+	if !w.tagFilter.Enabled() {
+		return false, nil
+	}
+	tags := IllustTagsFromNames(illust.Tags)
+	if w.tagFilter.NeedTranslation() {
+		var err error
+		tags, err = account.webClient.GetIllustTags(ctx, illust.Id)
+		w.reportAccount(account, err)
+		if err != nil {
+			return false, err
+		}
+	}
+	if skip, reason := w.tagFilter.Filter(tags); skip {
+		log.Infof("[PixivWorker] Skip illust by tags: %s, reason: %s", illust.DigestString(), reason)
+		illustFilteredCnt.WithLabelValues(FilterReasonTag).Inc()
+		return true, nil
+	}
+	return false, nil
+}
+
 func (w *pixivWorker) checkIllustExist(id pixiv.PixivID) (bool, error) {
 	exist := false
 	err := Retry(func() error {
@@ -166,16 +300,42 @@ func (w *pixivWorker) saveIllustInfo(illust *pixiv.IllustInfo, hash, filename st
 	}, 3)
 }
 
-func (w *pixivWorker) markIllustNotFound(id pixiv.PixivID) error {
-	illust := &pixiv.IllustInfo{
-		Id:        id,
+// markIllustStatus record the illust page is not downloaded with the reason, it's skipped or retried next time
+// according to the status
+func (w *pixivWorker) markIllustStatus(illust *pixiv.IllustInfo, status string) {
+	err := Retry(func() error {
+		return w.illustMgr.SaveIllustStatus(illust, status)
+	}, 3)
+	if err != nil {
+		log.Warningf("[PixivWorker] Failed to save illust status '%s', %s, msg: %s", status, illust.DigestString(), err)
+	}
+}
+
+// digestIllust is the placeholder of the illust which full info is not available
+func digestIllust(illust *pixiv.IllustDigest) *pixiv.IllustInfo {
+	return &pixiv.IllustInfo{
+		Id:        illust.Id,
 		PageIdx:   0,
 		PageCount: 1,
-		Title:     "NOT FOUND",
+		UserId:    illust.UserId,
 	}
-	return Retry(func() error {
-		return w.illustMgr.SaveIllust(illust, "", "")
-	}, 3)
+}
+
+// SetJobQueues persist the inputs and outputs of the worker, the input is deleted from the queue after processed
+func (w *pixivWorker) SetJobQueues(input, output *jobQueue) {
+	w.inputQueue = input
+	w.outputQueue = output
+}
+
+// inputDone delete the input from the job queue, it's kept if the worker is stopped while processing
+func (w *pixivWorker) inputDone(ctx context.Context, input interface{}) {
+	if ctx.Err() == nil {
+		w.inputQueue.done(input)
+	}
+}
+
+func (w *pixivWorker) Wait() {
+	w.wg.Wait()
 }
 
 func (w *pixivWorker) GetConsumeCnt() uint64 {
@@ -190,6 +350,11 @@ func (w *pixivWorker) GetProduceCnt() uint64 {
 	return atomic.LoadUint64(&w.produceCnt)
 }
 
+// addProduceCnt count the outputs resumed from the job queue as produced by the worker
+func (w *pixivWorker) addProduceCnt() {
+	atomic.AddUint64(&w.produceCnt, 1)
+}
+
 func (w *pixivWorker) ResetProduceCnt() {
 	atomic.StoreUint64(&w.produceCnt, 0)
 }
@@ -205,6 +370,7 @@ type BookmarksWorker struct {
 
 	input  <-chan pixiv.PixivID // input user id
 	output chan<- *pixiv.IllustDigest
+	owners map[pixiv.PixivID]*accountClient // the account logged in as the user, to get the private bookmarks
 }
 
 func NewBookmarksWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
@@ -213,29 +379,78 @@ func NewBookmarksWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
 		pixivWorker: newPixivWorker(options, illustMgr, options.ParseTimeoutMs),
 		input:       input,
 		output:      output,
+		owners:      make(map[pixiv.PixivID]*accountClient),
 	}
 
 	return worker
 }
 
-func (w *BookmarksWorker) Run() {
+func (w *BookmarksWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
 	go func() {
-		for uid := range w.input {
-			w.processInput(uid)
-			atomic.AddUint64(&w.consumeCnt, 1)
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case uid, ok := <-w.input:
+				if !ok {
+					return
+				}
+				w.processInput(ctx, uid)
+				w.inputDone(ctx, uid)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
 		}
 	}()
 }
 
-func (w *BookmarksWorker) processInput(uid pixiv.PixivID) {
-	bookmarkClient := NewBookmarksPageClient(w.client, string(uid), BookmarksPageLimit)
+// processInput download every scope of the user bookmarks by 'bookmarks-visibility' and 'bookmark-tags'
+func (w *BookmarksWorker) processInput(ctx context.Context, uid pixiv.PixivID) {
+	for _, scope := range bookmarksScopes(w.options, uid) {
+		if ctx.Err() != nil {
+			return
+		}
+		var owner *accountClient
+		if scope.private {
+			if owner = w.ownerAccount(ctx, uid); owner == nil {
+				log.Warningf("[BookmarksWorker] Skip private bookmarks of uid '%s', no account is logged in as the user", uid)
+				continue
+			}
+		}
+		w.processScope(ctx, scope, owner)
+	}
+}
+
+// processScope page through a scope of the user bookmarks, the private bookmarks are always requested with the owner
+// account, the public ones rotate through all the accounts
+func (w *BookmarksWorker) processScope(ctx context.Context, scope bookmarksScope, owner *accountClient) {
+	cursorKey := scope.key()
+	bookmarkClient := NewBookmarksPageClient(nil, string(scope.uid), scope.tag, scope.private, BookmarksPageLimit)
+	if offset := w.inputQueue.cursor(cursorKey); offset > 0 {
+		log.Infof("[BookmarksWorker] Resume bookmarks of %s from offset %d", scope, offset)
+		bookmarkClient.SetOffset(offset)
+	}
+	scan := w.newBookmarksScan(scope)
 	for {
+		if ctx.Err() != nil {
+			return
+		}
 		if !bookmarkClient.HasMorePage() {
-			log.Infof("[BookmarksWorker] End scan all bookmarks for uid '%s'", uid)
+			log.Infof("[BookmarksWorker] End scan all bookmarks for %s", scope)
 			break
 		}
-		w.retry(func() bool {
-			bmInfos, err := bookmarkClient.GetNextPageBookmarks()
+		ok := w.retry(ctx, func() bool {
+			account := owner
+			if account == nil {
+				var ok bool
+				if account, ok = w.acquireAccount(ctx); !ok {
+					return false
+				}
+			}
+			bookmarkClient.SetWebClient(account.webClient)
+			bmPage, err := bookmarkClient.GetNextPageBookmarks(ctx)
+			w.reportAccount(account, err)
 			if errors.Is(err, pixiv.ErrNotFound) || isJsonUnmarshalError(err) {
 				log.Warningf("[BookmarksWorker] Skip bookmarks page, offset: %d, msg: %s", bookmarkClient.CurOffset(), err)
 				return true
@@ -244,21 +459,55 @@ func (w *BookmarksWorker) processInput(uid pixiv.PixivID) {
 				log.Warningf("[BookmarksWorker] Failed to get bookmarks, offset: %d, retry, msg: %s", bookmarkClient.CurOffset(), err)
 				return false
 			}
-			err = w.processOutput(bmInfos)
+			w.saveIllustBookmarks(bmPage.Bookmarks)
+			newCnt, err := w.processOutput(ctx, bmPage)
 			if err != nil {
 				log.Warningf("[BookmarksWorker] Failed to process bookmarks, offset: %d, retry, msg: %s", bookmarkClient.CurOffset(), err)
 				return false
 			}
+			scan.observePage(bookmarkClient.CurOffset(), bmPage, newCnt)
 			log.Infof("[BookmarksWorker] Success get bookmarks, offset: %d, total: %d", bookmarkClient.CurOffset(), bookmarkClient.Total())
 			return true
 		})
+		if !ok && ctx.Err() != nil {
+			return
+		}
 		bookmarkClient.MoveToNextPage()
+		w.inputQueue.saveCursor(cursorKey, bookmarkClient.CurOffset())
+		if scan.shouldStop() {
+			log.Infof("[BookmarksWorker] Stop incremental scan for %s after %d pages without new illust, offset: %d",
+				scope, scan.knownPages, bookmarkClient.CurOffset())
+			break
+		}
 	}
+	w.inputQueue.deleteCursor(cursorKey)
+	w.saveBookmarkMarker(scan)
 }
 
-func (w *BookmarksWorker) processOutput(bmInfo *pixiv.BookmarksInfo) error {
-	for idx := range bmInfo.Works {
-		illust := bmInfo.Works[idx]
+// ownerAccount return the account logged in as the user, nil if not found. The result is cached because the
+// login status is checked with an extra request.
+func (w *BookmarksWorker) ownerAccount(ctx context.Context, uid pixiv.PixivID) *accountClient {
+	if account, ok := w.owners[uid]; ok {
+		return account
+	}
+	for _, account := range w.accounts {
+		status, err := account.webClient.GetLoginStatus(ctx)
+		if err != nil {
+			log.Warningf("[BookmarksWorker] Failed to get login status of account '%s', msg: %s", account.name, err)
+			continue
+		}
+		if status.LoggedIn && status.UserId == string(uid) {
+			w.owners[uid] = account
+			return account
+		}
+	}
+	return nil
+}
+
+func (w *BookmarksWorker) processOutput(ctx context.Context, bmPage *BookmarksPage) (int, error) {
+	newCnt := 0
+	for idx := range bmPage.Works {
+		illust := bmPage.Works[idx]
 		if w.filterByUser(illust) {
 			continue
 		}
@@ -266,7 +515,7 @@ func (w *BookmarksWorker) processOutput(bmInfo *pixiv.BookmarksInfo) error {
 		exist, err := w.checkIllustExist(illust.Id)
 		if err != nil {
 			log.Errorf("[BookmarksWorker] Failed to check illust exist, illust info: %s, msg: %s", illust.DigestString(), err)
-			return err
+			return newCnt, err
 		}
 		if exist {
 			log.Debugf("[BookmarksWorker] Skip exist illust, illust info: %s", illust.DigestString())
@@ -274,8 +523,220 @@ func (w *BookmarksWorker) processOutput(bmInfo *pixiv.BookmarksInfo) error {
 		}
 
 		log.Infof("[BookmarksWorker] Success get bookmark illust info: %s", illust.DigestString())
-		w.output <- illust
-		atomic.AddUint64(&w.produceCnt, 1)
+		w.outputQueue.push(illust)
+		select {
+		case w.output <- illust:
+			atomic.AddUint64(&w.produceCnt, 1)
+			newCnt++
+		case <-ctx.Done():
+			return newCnt, ctx.Err()
+		}
+	}
+	return newCnt, nil
+}
+
+// bookmarksScan track a scan of the user bookmarks, in incremental mode the scan stops after 'incremental-scan-pages'
+// consecutive pages without new illust, the pages after the last seen bookmark are always treated as no new illust
+type bookmarksScan struct {
+	scope        bookmarksScope
+	marker       *BookmarkMarker // nil if never scanned
+	incremental  bool
+	maxKnown     int32
+	newestPid    pixiv.PixivID // the first bookmark of the first page
+	passedMarker bool
+	knownPages   int32 // the consecutive pages without new illust
+	stopped      bool
+}
+
+func (w *BookmarksWorker) newBookmarksScan(scope bookmarksScope) *bookmarksScan {
+	scan := &bookmarksScan{scope: scope, maxKnown: w.options.IncrementalScanPages}
+	if w.options.IncrementalScanPages <= 0 {
+		return scan
+	}
+	err := Retry(func() error {
+		var err error
+		scan.marker, err = w.illustMgr.GetBookmarkMarker(string(scope.key()))
+		return err
+	}, 3)
+	if err != nil {
+		log.Warningf("[BookmarksWorker] Failed to get bookmark marker, scan all pages, %s, msg: %s", scope, err)
+		return scan
+	}
+	if scan.marker == nil {
+		log.Infof("[BookmarksWorker] Scan all bookmarks pages for %s at the first time", scope)
+		return scan
+	}
+	interval := time.Duration(w.options.FullScanIntervalSec) * time.Second
+	if interval > 0 && time.Since(scan.marker.LastFullScanTime) >= interval {
+		log.Infof("[BookmarksWorker] Scan all bookmarks pages for %s, last full scan: %s", scope, scan.marker.LastFullScanTime)
+		return scan
+	}
+	scan.incremental = true
+	log.Infof("[BookmarksWorker] Incremental scan bookmarks for %s, last seen illust: %s", scope, scan.marker.LastSeenPid)
+	return scan
+}
+
+func (s *bookmarksScan) observePage(offset int32, bmPage *BookmarksPage, newCnt int) {
+	if offset == 0 && len(bmPage.Works) > 0 {
+		s.newestPid = bmPage.Works[0].Id
+	}
+	if newCnt == 0 || s.passedMarker {
+		s.knownPages++
+	} else {
+		s.knownPages = 0
+	}
+	if s.marker == nil || s.passedMarker {
+		return
+	}
+	for _, illust := range bmPage.Works {
+		if string(illust.Id) == s.marker.LastSeenPid {
+			s.passedMarker = true
+			break
+		}
+	}
+}
+
+func (s *bookmarksScan) shouldStop() bool {
+	s.stopped = s.incremental && s.knownPages >= s.maxKnown
+	return s.stopped
+}
+
+// saveBookmarkMarker save the newest bookmark and the full scan time after the scan finished
+func (w *BookmarksWorker) saveBookmarkMarker(scan *bookmarksScan) {
+	if w.options.IncrementalScanPages <= 0 {
+		return
+	}
+	marker := &BookmarkMarker{Uid: string(scan.scope.key())}
+	if scan.marker != nil {
+		*marker = *scan.marker
+	}
+	if len(scan.newestPid) > 0 {
+		marker.LastSeenPid = string(scan.newestPid)
+	}
+	if !scan.stopped {
+		marker.LastFullScanTime = time.Now()
+	}
+	err := Retry(func() error {
+		return w.illustMgr.SaveBookmarkMarker(marker)
+	}, 3)
+	if err != nil {
+		log.Warningf("[BookmarksWorker] Failed to save bookmark marker, %s, msg: %s", scan.scope, err)
+	}
+}
+
+// saveIllustBookmarks record the visibility and the bookmark tags of all the bookmarks in the page, include the
+// exist and filtered illust
+func (w *BookmarksWorker) saveIllustBookmarks(bookmarks []*IllustBookmark) {
+	for _, bookmark := range bookmarks {
+		err := Retry(func() error {
+			return w.illustMgr.SaveIllustBookmark(bookmark)
+		}, 3)
+		if err != nil {
+			log.Warningf("[BookmarksWorker] Failed to save illust bookmark, uid: %s, pid: %s, msg: %s",
+				bookmark.Uid, bookmark.Pid, err)
+		}
+	}
+}
+
+// FollowingWorker process the input user id and output the user id of all his following users
+type FollowingWorker struct {
+	*pixivWorker
+
+	input  <-chan pixiv.PixivID // input user id
+	output chan<- pixiv.PixivID // following user id
+}
+
+func NewFollowingWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
+	input <-chan pixiv.PixivID, output chan<- pixiv.PixivID) *FollowingWorker {
+	worker := &FollowingWorker{
+		pixivWorker: newPixivWorker(options, illustMgr, options.ParseTimeoutMs),
+		input:       input,
+		output:      output,
+	}
+
+	return worker
+}
+
+func (w *FollowingWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case uid, ok := <-w.input:
+				if !ok {
+					return
+				}
+				w.processInput(ctx, uid)
+				w.inputDone(ctx, uid)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
+		}
+	}()
+}
+
+func (w *FollowingWorker) processInput(ctx context.Context, uid pixiv.PixivID) {
+	followingClient := NewFollowingPageClient(nil, string(uid), FollowingPageLimit)
+	if offset := w.inputQueue.cursor(uid); offset > 0 {
+		log.Infof("[FollowingWorker] Resume following of uid '%s' from offset %d", uid, offset)
+		followingClient.SetOffset(offset)
+	}
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if !followingClient.HasMorePage() {
+			log.Infof("[FollowingWorker] End scan all following users for uid '%s'", uid)
+			break
+		}
+		ok := w.retry(ctx, func() bool {
+			account, ok := w.acquireAccount(ctx)
+			if !ok || !w.waitApi(ctx) {
+				return false
+			}
+			followingClient.SetClient(account.client)
+			followingInfo, err := followingClient.GetNextPageFollowing()
+			w.reportAccount(account, err)
+			if errors.Is(err, pixiv.ErrNotFound) || isJsonUnmarshalError(err) {
+				log.Warningf("[FollowingWorker] Skip following page, offset: %d, msg: %s", followingClient.CurOffset(), err)
+				return true
+			}
+			if err != nil {
+				log.Warningf("[FollowingWorker] Failed to get following, offset: %d, retry, msg: %s", followingClient.CurOffset(), err)
+				return false
+			}
+			err = w.processOutput(ctx, followingInfo)
+			if err != nil {
+				return false
+			}
+			log.Infof("[FollowingWorker] Success get following, offset: %d, total: %d", followingClient.CurOffset(), followingClient.Total())
+			return true
+		})
+		if !ok && ctx.Err() != nil {
+			return
+		}
+		followingClient.MoveToNextPage()
+		w.inputQueue.saveCursor(uid, followingClient.CurOffset())
+	}
+	w.inputQueue.deleteCursor(uid)
+}
+
+func (w *FollowingWorker) processOutput(ctx context.Context, followingInfo *pixiv.FollowingInfo) error {
+	for _, user := range followingInfo.Users {
+		if len(user.UserId) == 0 || w.filterByUid(user.UserId) {
+			continue
+		}
+
+		log.Infof("[FollowingWorker] Success get following user, uid: %s, name: %s", user.UserId, user.UserName)
+		w.outputQueue.push(user.UserId)
+		select {
+		case w.output <- user.UserId:
+			atomic.AddUint64(&w.produceCnt, 1)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return nil
 }
@@ -299,18 +760,35 @@ func NewArtistWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
 	return worker
 }
 
-func (w *ArtistWorker) Run() {
+func (w *ArtistWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
 	go func() {
-		for uid := range w.input {
-			w.processInput(uid)
-			atomic.AddUint64(&w.consumeCnt, 1)
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case uid, ok := <-w.input:
+				if !ok {
+					return
+				}
+				w.processInput(ctx, uid)
+				w.inputDone(ctx, uid)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
 		}
 	}()
 }
 
-func (w *ArtistWorker) processInput(uid pixiv.PixivID) {
-	w.retry(func() bool {
-		illustIds, err := w.client.GetUserIllusts(string(uid))
+func (w *ArtistWorker) processInput(ctx context.Context, uid pixiv.PixivID) {
+	w.retry(ctx, func() bool {
+		account, ok := w.acquireAccount(ctx)
+		if !ok || !w.waitApi(ctx) {
+			return false
+		}
+		illustIds, err := account.client.GetUserIllusts(string(uid))
+		w.reportAccount(account, err)
+		observeApiRequest("get_user_illusts", err)
 		if errors.Is(err, pixiv.ErrNotFound) || isJsonUnmarshalError(err) {
 			log.Warningf("[ArtistWorker] Skip user: %s, msg: %s", uid, err)
 			return true
@@ -321,7 +799,7 @@ func (w *ArtistWorker) processInput(uid pixiv.PixivID) {
 		}
 
 		log.Infof("[ArtistWorker] Success get user all ilusts, count: %d, ids: %+v", len(illustIds), illustIds)
-		err = w.processOutput(illustIds)
+		err = w.processOutput(ctx, illustIds)
 		if err != nil {
 			log.Warningf("[ArtistWorker] Failed to process artist user %s, retry, msg: %s", uid, err)
 			return false
@@ -330,7 +808,7 @@ func (w *ArtistWorker) processInput(uid pixiv.PixivID) {
 	})
 }
 
-func (w *ArtistWorker) processOutput(illustIds []pixiv.PixivID) error {
+func (w *ArtistWorker) processOutput(ctx context.Context, illustIds []pixiv.PixivID) error {
 	for _, id := range illustIds {
 		exist, err := w.checkIllustExist(id)
 		if err != nil {
@@ -346,8 +824,13 @@ func (w *ArtistWorker) processOutput(illustIds []pixiv.PixivID) error {
 			Id:        id,
 			PageCount: 1,
 		}
-		w.output <- illust
-		atomic.AddUint64(&w.produceCnt, 1)
+		w.outputQueue.push(illust)
+		select {
+		case w.output <- illust:
+			atomic.AddUint64(&w.produceCnt, 1)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	return nil
 }
@@ -369,20 +852,31 @@ func NewIllustInfoWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
 	return worker
 }
 
-func (w *IllustInfoWorker) Run() {
+func (w *IllustInfoWorker) Run(ctx context.Context) {
 	for i := int32(0); i < w.options.ParseParallel; i++ {
+		w.wg.Add(1)
 		go func() {
-			for illust := range w.input {
-				w.processInput(illust)
-				atomic.AddUint64(&w.consumeCnt, 1)
+			defer w.wg.Done()
+			defer log.Info("[IllustInfoWorker] exit")
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case illust, ok := <-w.input:
+					if !ok {
+						return
+					}
+					w.processInput(ctx, illust)
+					w.inputDone(ctx, illust)
+					atomic.AddUint64(&w.consumeCnt, 1)
+				}
 			}
-			log.Info("[IllustInfoWorker] exit")
 		}()
 	}
 }
 
-func (w *IllustInfoWorker) processInput(illust *pixiv.IllustDigest) {
-	w.retry(func() bool {
+func (w *IllustInfoWorker) processInput(ctx context.Context, illust *pixiv.IllustDigest) {
+	ok := w.retry(ctx, func() bool {
 		exist, err := w.checkIllustExist(illust.Id)
 		if err != nil {
 			log.Errorf("[IllustInfoWorker] Failed to check illust exist, illust info: %s, msg: %s", illust.DigestString(), err)
@@ -393,11 +887,18 @@ func (w *IllustInfoWorker) processInput(illust *pixiv.IllustDigest) {
 			return true
 		}
 
-		illusts, err := w.client.GetIllustInfo(illust.Id, w.options.OnlyP0)
+		account, ok := w.acquireAccount(ctx)
+		if !ok || !w.waitApi(ctx) {
+			return false
+		}
+		illusts, err := account.client.GetIllustInfo(illust.Id, w.options.OnlyP0)
+		w.reportAccount(account, err)
+		observeApiRequest("get_illust_info", err)
 		if errors.Is(err, pixiv.ErrNotFound) || isJsonUnmarshalError(err) {
 			log.Warningf("[IllustInfoWorker] Skip illust: %s, msg: %s", illust.DigestString(), err)
+			illustFailedCnt.WithLabelValues(FailedStageParse).Inc()
 			if errors.Is(err, pixiv.ErrNotFound) {
-				_ = w.markIllustNotFound(illust.Id)
+				w.markIllustStatus(digestIllust(illust), IllustStatusNotFound)
 			}
 			return true
 		}
@@ -406,20 +907,41 @@ func (w *IllustInfoWorker) processInput(illust *pixiv.IllustDigest) {
 			return false
 		}
 		log.Debugf("[IllustInfoWorker] Success get illust info: %s", illusts[0].DigestString())
-		w.processOutput(illusts)
+		illustParsedCnt.Add(float64(len(illusts)))
+
+		// all the pages have the same tags
+		skip, err := w.filterByTags(ctx, account, illusts[0])
+		if err != nil {
+			log.Warningf("[IllustInfoWorker] Failed to get illust tags: %s, msg: %s", illust.DigestString(), err)
+			return false
+		}
+		if skip {
+			return true
+		}
+		w.processOutput(ctx, illusts)
 
 		return true
 	})
+	if !ok && ctx.Err() == nil {
+		log.Errorf("[IllustInfoWorker] Give up illust after %d retries: %s", w.options.MaxRetries, illust.DigestString())
+		illustFailedCnt.WithLabelValues(FailedStageParse).Inc()
+		w.markIllustStatus(digestIllust(illust), IllustStatusFailed)
+	}
 }
 
-func (w *IllustInfoWorker) processOutput(illusts []*pixiv.IllustInfo) {
+func (w *IllustInfoWorker) processOutput(ctx context.Context, illusts []*pixiv.IllustInfo) {
 	for idx := range illusts {
 		fullIllust := illusts[idx]
 		if w.filterByIllustInfo(fullIllust) {
 			continue
 		}
-		w.output <- fullIllust
-		atomic.AddUint64(&w.produceCnt, 1)
+		w.outputQueue.push(fullIllust)
+		select {
+		case w.output <- fullIllust:
+			atomic.AddUint64(&w.produceCnt, 1)
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
@@ -427,54 +949,150 @@ func (w *IllustInfoWorker) processOutput(illusts []*pixiv.IllustInfo) {
 type IllustDownloadWorker struct {
 	*pixivWorker
 	input <-chan *pixiv.IllustInfo
+
+	filenameTemplate *FilenameTemplate
+	phashIndex       *phashIndex
+	pause            pauseGate
 }
 
 func NewIllustDownloadWorker(options *PixivDlOptions, illustMgr IllustInfoManager, illustChan <-chan *pixiv.IllustInfo) *IllustDownloadWorker {
+	filenameTemplate, err := NewFilenameTemplate(options.FilenamePattern, options.FilenameMaxLength)
+	if err != nil {
+		log.Fatalf("Failed to parse filename pattern, msg: %s", err)
+	}
 	worker := &IllustDownloadWorker{
-		pixivWorker: newPixivWorker(options, illustMgr, options.DownloadTimeoutMs),
-		input:       illustChan,
+		pixivWorker:      newPixivWorker(options, illustMgr, options.DownloadTimeoutMs),
+		input:            illustChan,
+		filenameTemplate: filenameTemplate,
+		phashIndex:       sharedPhashIndex(illustMgr),
 	}
 	return worker
 }
 
-func (w *IllustDownloadWorker) Run() {
+func (w *IllustDownloadWorker) Run(ctx context.Context) {
 	for i := int32(0); i < w.options.DownloadParallel; i++ {
+		w.wg.Add(1)
 		go func() {
-			for illust := range w.input {
-				w.processInput(illust)
-				atomic.AddUint64(&w.consumeCnt, 1)
+			defer w.wg.Done()
+			defer log.Info("[IllustDownloadWorker] exit")
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case illust, ok := <-w.input:
+					if !ok {
+						return
+					}
+					if !w.pause.Wait(ctx) {
+						return
+					}
+					w.processInput(ctx, illust)
+					w.inputDone(ctx, illust)
+					atomic.AddUint64(&w.consumeCnt, 1)
+				}
 			}
-			log.Info("[IllustDownloadWorker] exit")
 		}()
 	}
 }
 
-func FormatFileName(illust *pixiv.IllustInfo, pattern string) string {
-	filename := filepath.Base(illust.Urls.Original)
-	if len(pattern) == 0 {
-		return filename
+func (w *IllustDownloadWorker) Pause() {
+	if w.pause.Pause() {
+		log.Info("[IllustDownloadWorker] Paused")
 	}
-	fileExt := filepath.Ext(filename)
-	pid := filename[:len(filename)-len(fileExt)]
+}
+
+func (w *IllustDownloadWorker) Resume() {
+	if w.pause.Resume() {
+		log.Info("[IllustDownloadWorker] Resumed")
+	}
+}
+
+func (w *IllustDownloadWorker) IsPaused() bool {
+	return w.pause.IsPaused()
+}
 
-	newName := pattern
-	newName = strings.Replace(newName, "{id}", pid, -1)
-	newName = strings.Replace(newName, "{title}", StandardizeFileName(illust.Title), -1)
-	newName = strings.Replace(newName, "{user_id}", string(illust.UserId), -1)
-	newName = strings.Replace(newName, "{user}", StandardizeFileName(illust.UserName), -1)
-	newName += fileExt
-	return newName
+// trackInflightFile record the file is being written until the returned func is called
+func (w *pixivWorker) trackInflightFile(filename string) func() {
+	w.inflightFiles.Store(filename, struct{}{})
+	return func() {
+		w.inflightFiles.Delete(filename)
+	}
 }
 
-func (w *IllustDownloadWorker) processInput(illust *pixiv.IllustInfo) {
+// RemovePartialFiles remove the files which are still being written and can not be resumed (the downloading '.part'
+// files are kept to resume next time), it's called when the worker can not exit in time
+func (w *pixivWorker) RemovePartialFiles() {
+	w.inflightFiles.Range(func(key, value any) bool {
+		filename := key.(string)
+		err := os.Remove(filename)
+		if err == nil {
+			log.Warningf("[PixivWorker] Remove partial file: %s", filename)
+		}
+		return true
+	})
+}
+
+// reserveFilename pick an available filename according to the 'filename-collision' option and reserve it for self
+// until the returned func is called, see fileReservations.reserve. The exist file is the other's if failed to query
+// the database so that it's never overwritten. Return empty filename if the illust should be skipped.
+func (w *pixivWorker) reserveFilename(ctx context.Context, filename string, self FileOwner) (string, func(), error) {
+	fileOwner := func(name string) int {
+		if _, err := os.Stat(filepath.Join(w.options.DownloadPath, name)); err != nil {
+			return fileOwnerNone
+		}
+		owner, err := w.illustMgr.GetFileOwner(name)
+		if err != nil {
+			log.Warningf("[PixivWorker] Failed to get file owner, filename: %s, msg: %s", name, err)
+			return fileOwnerOther
+		}
+		if owner == nil {
+			return fileOwnerUnknown
+		}
+		if *owner == self {
+			return fileOwnerSelf
+		}
+		return fileOwnerOther
+	}
+	return w.reservations.reserve(ctx, filename, self, w.options.FilenameCollision, fileOwner)
+}
+
+// collisionFilename add a sequence number to the filename, e.g. '12345678_p0_1.jpg'
+func collisionFilename(filename string, seq int) string {
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(filename, ext), seq, ext)
+}
+
+func (w *IllustDownloadWorker) processInput(ctx context.Context, illust *pixiv.IllustInfo) {
 	if len(illust.Urls.Original) == 0 {
 		log.Warningf("[IllustDownloadWorker] Skip empty url illust: %s", illust.DigestString())
+		w.markIllustStatus(illust, IllustStatusRestricted)
 		return
 	}
 
-	filename := FormatFileName(illust, w.options.FilenamePattern)
-	fullFilename := filepath.Join(w.options.DownloadPath, filename)
-	w.retry(func() bool {
+	var series *SeriesEpisode
+	if w.filenameTemplate.UsesSeries() {
+		series = w.illustSeries(illust.Id)
+	}
+	patternFilename, err := w.filenameTemplate.Format(illust, series)
+	if err != nil {
+		log.Errorf("[IllustDownloadWorker] Failed to format filename, skip illust: %s, msg: %s", illust.DigestString(), err)
+		return
+	}
+	isUgoira := IsUgoira(illust)
+	if isUgoira {
+		patternFilename = UgoiraFileName(patternFilename, w.options.UgoiraFormat)
+	}
+	// the filename is resolved only once, the retries write the same file
+	filename, release, err := w.reserveFilename(ctx, patternFilename, FileOwner{Id: string(illust.Id), Page: illust.PageIdx})
+	if err != nil {
+		return
+	}
+	defer release()
+	if len(filename) == 0 {
+		log.Warningf("[IllustDownloadWorker] Skip illust because the file already exists, illust info: %s, filename: %s", illust.DigestString(), patternFilename)
+		return
+	}
+	ok := w.retry(ctx, func() bool {
 		exist, err := w.checkIllustPageExist(illust.Id, illust.PageIdx)
 		if err != nil {
 			log.Warningf("[IllustDownloadWorker] Failed to check illust exist, illust info: %s, msg: %s", illust.DigestString(), err)
@@ -484,9 +1102,34 @@ func (w *IllustDownloadWorker) processInput(illust *pixiv.IllustInfo) {
 			return true
 		}
 
+		fullFilename := filepath.Join(w.options.DownloadPath, filename)
+		err = os.MkdirAll(filepath.Dir(fullFilename), 0755)
+		if err != nil {
+			log.Errorf("[IllustDownloadWorker] Failed to create directory and retry, %s, msg: %s", illust.DigestString(), err)
+			return false
+		}
+
+		account, ok := w.acquireAccount(ctx)
+		if !ok {
+			return false
+		}
 		start := time.Now()
-		size, hash, err := w.client.DownloadIllust(illust.Urls.Original, fullFilename)
+		var (
+			size int64
+			hash string
+		)
+		if isUgoira {
+			size, hash, err = w.downloadUgoira(ctx, account.webClient, illust, fullFilename)
+		} else {
+			size, hash, err = w.downloadIllust(ctx, account.webClient, illust.Urls.Original, fullFilename)
+		}
+		w.reportAccount(account, err)
 		if errors.Is(err, pixiv.ErrNotFound) || isJsonUnmarshalError(err) {
+			log.Warningf("[IllustDownloadWorker] Skip illust: %s, msg: %s", illust.DigestString(), err)
+			illustFailedCnt.WithLabelValues(FailedStageDownload).Inc()
+			if errors.Is(err, pixiv.ErrNotFound) {
+				w.markIllustStatus(illust, IllustStatusNotFound)
+			}
 			return true
 		}
 		if err != nil {
@@ -494,13 +1137,196 @@ func (w *IllustDownloadWorker) processInput(illust *pixiv.IllustInfo) {
 			return false
 		}
 
+		var phash *IllustPhash
+		if !isUgoira {
+			phash = w.perceptualHash(illust, fullFilename, filename)
+			if w.skipNearDuplicate(illust, phash, fullFilename) {
+				return true
+			}
+		}
+		size, hash, err = w.embedMetadata(illust, fullFilename, size, hash)
+		if err != nil {
+			log.Warningf("[IllustDownloadWorker] Failed to hash the file after embed metadata and retry, %s, filename: %s, msg: %s", illust.DigestString(), filename, err)
+			return false
+		}
+		w.saveSidecars(illust, hash, filename)
 		err = w.saveIllustInfo(illust, hash, filename)
 		if err != nil {
 			log.Errorf("[IllustDownloadWorker] Failed to save illust info and retry, %s, msg: %s", illust.DigestString(), err)
 			return false
 		}
+		w.savePerceptualHash(phash)
 		elapsed := time.Since(start)
+		illustDownloadedCnt.Inc()
+		downloadedBytes.Add(float64(size))
+		downloadDuration.Observe(elapsed.Seconds())
 		log.Infof("[IllustDownloadWorker] Success download illust: %s, cost: %s, size: %dKB, filename: %s, URL: %s", illust.DigestString(), elapsed, size/1024, filename, illust.Urls.Original)
 		return true
 	})
+	if !ok && ctx.Err() == nil {
+		log.Errorf("[IllustDownloadWorker] Give up illust after %d retries: %s", w.options.MaxRetries, illust.DigestString())
+		illustFailedCnt.WithLabelValues(FailedStageDownload).Inc()
+		w.markIllustStatus(illust, IllustStatusFailed)
+	}
+}
+
+// perceptualHash compute the DHash of the downloaded illust page, nil if the file can not be decoded
+func (w *IllustDownloadWorker) perceptualHash(illust *pixiv.IllustInfo, fullFilename, filename string) *IllustPhash {
+	hash, err := FileDHash(fullFilename)
+	if err != nil {
+		log.Warningf("[IllustDownloadWorker] Failed to compute perceptual hash, %s, filename: %s, msg: %s", illust.DigestString(), filename, err)
+		return nil
+	}
+	return &IllustPhash{
+		Pid:      string(illust.Id),
+		Page:     illust.PageIdx,
+		UserId:   string(illust.UserId),
+		Filename: filename,
+		Phash:    FormatPhash(hash),
+	}
+}
+
+// skipNearDuplicate delete the downloaded file and mark the illust page as duplicate if 'skip-near-duplicate' and
+// there is a downloaded page of the other illust within 'phash-max-distance'
+func (w *IllustDownloadWorker) skipNearDuplicate(illust *pixiv.IllustInfo, phash *IllustPhash, fullFilename string) bool {
+	if !w.options.SkipNearDuplicate || phash == nil {
+		return false
+	}
+	hash, _ := ParsePhash(phash.Phash)
+	dup, distance, err := w.phashIndex.find(hash, phash.Pid, w.options.PhashMaxDistance)
+	if err != nil {
+		log.Warningf("[IllustDownloadWorker] Failed to find near duplicate, keep it, %s, msg: %s", illust.DigestString(), err)
+		return false
+	}
+	if dup == nil {
+		return false
+	}
+	log.Infof("[IllustDownloadWorker] Skip near duplicate illust: %s, duplicate of: %s, page: %d, distance: %d, filename: %s",
+		illust.DigestString(), dup.Pid, dup.Page, distance, dup.Filename)
+	if err := os.Remove(fullFilename); err != nil {
+		log.Warningf("[IllustDownloadWorker] Failed to remove near duplicate file: %s, msg: %s", fullFilename, err)
+	}
+	w.markIllustStatus(illust, IllustStatusDuplicate)
+	return true
+}
+
+// savePerceptualHash record the hash after the illust page is saved, it's not retried because only the near
+// duplicate detection is affected
+func (w *IllustDownloadWorker) savePerceptualHash(phash *IllustPhash) {
+	if phash == nil {
+		return
+	}
+	err := Retry(func() error {
+		return w.illustMgr.SaveIllustPhash(phash.Pid, phash.Page, phash.Phash)
+	}, 3)
+	if err != nil {
+		log.Warningf("[IllustDownloadWorker] Failed to save perceptual hash, illust: %s, page: %d, msg: %s", phash.Pid, phash.Page, err)
+		return
+	}
+	hash, _ := ParsePhash(phash.Phash)
+	w.phashIndex.add(phash, hash)
+}
+
+// embedMetadata write the illust metadata into the downloaded file if 'embed-metadata', return the size and hash of
+// the final file. The downloaded file is kept as it is if the format is not supported or failed to embed, the error
+// is returned only if the file is rewritten but failed to hash it again.
+func (w *IllustDownloadWorker) embedMetadata(illust *pixiv.IllustInfo, fullFilename string, size int64, hash string) (int64, string, error) {
+	if !w.options.EmbedMetadata {
+		return size, hash, nil
+	}
+	defer w.trackInflightFile(fullFilename + partialFileSuffix)()
+	err := EmbedIllustMetadata(illust, fullFilename)
+	if errors.Is(err, errEmbedNotSupported) {
+		log.Debugf("[IllustDownloadWorker] Skip embed metadata, %s, filename: %s, msg: %s", illust.DigestString(), fullFilename, err)
+		return size, hash, nil
+	}
+	if err != nil {
+		log.Warningf("[IllustDownloadWorker] Failed to embed metadata, %s, filename: %s, msg: %s", illust.DigestString(), fullFilename, err)
+		return size, hash, nil
+	}
+	stat, err := os.Stat(fullFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	hash, err = FileSha1Sum(fullFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	return stat.Size(), hash, nil
+}
+
+// saveSidecars save the metadata sidecars of 'sidecar-formats' next to the downloaded file, the download is not
+// retried if failed because the file is already there
+func (w *IllustDownloadWorker) saveSidecars(illust *pixiv.IllustInfo, hash, filename string) {
+	if len(w.options.SidecarFormats) == 0 {
+		return
+	}
+	sidecar := &IllustSidecar{
+		Illust:       illust,
+		Page:         illust.PageIdx,
+		Filename:     filename,
+		Sha1:         hash,
+		DownloadTime: time.Now(),
+	}
+	err := SaveIllustSidecars(sidecar, filepath.Join(w.options.DownloadPath, filename), w.options.SidecarFormats)
+	if err != nil {
+		log.Warningf("[IllustDownloadWorker] Failed to save sidecars, %s, filename: %s, msg: %s", illust.DigestString(), filename, err)
+	}
+}
+
+// downloadIllust download the url to fullFilename and return the size and hash of the file, the file only appears
+// after it's completely downloaded, see PixivWebClient.DownloadFile
+func (w *IllustDownloadWorker) downloadIllust(ctx context.Context, webClient *PixivWebClient, url, fullFilename string) (int64, string, error) {
+	size, err := webClient.DownloadFile(ctx, url, fullFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	hash, err := FileSha1Sum(fullFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hash, nil
+}
+
+// downloadUgoira download the frames zip and save the frame delays along with it, then convert it to the
+// ugoira format, return the size and hash of the final file
+func (w *IllustDownloadWorker) downloadUgoira(ctx context.Context, webClient *PixivWebClient, illust *pixiv.IllustInfo, fullFilename string) (int64, string, error) {
+	meta, err := webClient.GetUgoiraMeta(ctx, illust.Id)
+	if err != nil {
+		return 0, "", err
+	}
+	zipFilename, metaFilename := ugoiraCompanionFiles(fullFilename)
+	size, hash, err := w.downloadIllust(ctx, webClient, meta.OriginalSrc, zipFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	err = SaveUgoiraMeta(meta, metaFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	if w.options.UgoiraFormat == UgoiraFormatZip {
+		return size, hash, nil
+	}
+
+	start := time.Now()
+	partFilename := fullFilename + partialFileSuffix
+	defer w.trackInflightFile(partFilename)()
+	err = ConvertUgoira(zipFilename, meta, w.options.UgoiraFormat, partFilename)
+	if err == nil {
+		err = os.Rename(partFilename, fullFilename)
+	}
+	if err != nil {
+		_ = os.Remove(partFilename)
+		return 0, "", err
+	}
+	stat, err := os.Stat(fullFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	hash, err = FileSha1Sum(fullFilename)
+	if err != nil {
+		return 0, "", err
+	}
+	log.Infof("[IllustDownloadWorker] Success convert ugoira to %s: %s, frames: %d, cost: %s", w.options.UgoiraFormat, illust.DigestString(), len(meta.Frames), time.Since(start))
+	return stat.Size(), hash, nil
 }
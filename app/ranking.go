@@ -0,0 +1,309 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// the 'ranking mode' options
+const (
+	RankingModeDaily    = "daily"
+	RankingModeWeekly   = "weekly"
+	RankingModeMonthly  = "monthly"
+	RankingModeRookie   = "rookie"
+	RankingModeOriginal = "original"
+	RankingModeR18      = "r18"
+)
+
+var rankingModes = map[string]string{
+	RankingModeDaily:    "daily",
+	RankingModeWeekly:   "weekly",
+	RankingModeMonthly:  "monthly",
+	RankingModeRookie:   "rookie",
+	RankingModeOriginal: "original",
+	RankingModeR18:      "daily_r18",
+}
+
+// rankingDateLayout is the date format of '/ranking.php'
+const rankingDateLayout = "20060102"
+
+func IsValidRankingMode(mode string) bool {
+	_, ok := rankingModes[mode]
+	return ok
+}
+
+// IllustRank is the position of the illust in the ranking of the date
+type IllustRank struct {
+	Mode   string
+	Date   string // yyyy-mm-dd
+	Rank   int
+	Pid    string
+	UserId string
+}
+
+// RankingPage is a page of the ranking
+type RankingPage struct {
+	Date    string // yyyy-mm-dd, the actual date if the latest ranking is requested
+	Illusts []*pixiv.IllustDigest
+	Ranks   []*IllustRank
+	HasNext bool
+}
+
+// rankingResponse is the response of '/ranking.php?format=json', it's not wrapped as the ajax api
+type rankingResponse struct {
+	Contents []struct {
+		IllustId  json.Number `json:"illust_id"`
+		UserId    json.Number `json:"user_id"`
+		PageCount json.Number `json:"illust_page_count"` // it's a string
+		Rank      int         `json:"rank"`
+	} `json:"contents"`
+	Date string `json:"date"`
+	// Next is the next page number, or false if it's the last page
+	Next  json.RawMessage `json:"next"`
+	Error string          `json:"error"`
+}
+
+func (r *rankingResponse) rankingPage(mode string) *RankingPage {
+	rankingPage := &RankingPage{Date: r.Date}
+	if date, err := time.Parse(rankingDateLayout, r.Date); err == nil {
+		rankingPage.Date = date.Format(DateLayout)
+	}
+	next, err := strconv.Atoi(string(r.Next))
+	rankingPage.HasNext = err == nil && next > 0
+	for _, content := range r.Contents {
+		pageCount, _ := strconv.Atoi(string(content.PageCount))
+		if pageCount <= 0 {
+			pageCount = 1
+		}
+		rankingPage.Illusts = append(rankingPage.Illusts, &pixiv.IllustDigest{
+			Id:        pixiv.PixivID(content.IllustId),
+			UserId:    pixiv.PixivID(content.UserId),
+			PageCount: pageCount,
+		})
+		rankingPage.Ranks = append(rankingPage.Ranks, &IllustRank{
+			Mode:   mode,
+			Date:   rankingPage.Date,
+			Rank:   content.Rank,
+			Pid:    string(content.IllustId),
+			UserId: string(content.UserId),
+		})
+	}
+	return rankingPage
+}
+
+// nextRankingDate return the day after the date in 'yyyy-mm-dd'
+func nextRankingDate(date string) string {
+	t, err := time.Parse(DateLayout, date)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, 1).Format(DateLayout)
+}
+
+// RankingWorker process the input ranking mode and output basic illust info of the ranking
+type RankingWorker struct {
+	*pixivWorker
+
+	input  <-chan string // input ranking mode
+	output chan<- *pixiv.IllustDigest
+}
+
+func NewRankingWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
+	input <-chan string, output chan<- *pixiv.IllustDigest) *RankingWorker {
+	return &RankingWorker{
+		pixivWorker: newPixivWorker(options, illustMgr, options.ParseTimeoutMs),
+		input:       input,
+		output:      output,
+	}
+}
+
+func (w *RankingWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case mode, ok := <-w.input:
+				if !ok {
+					return
+				}
+				w.processInput(ctx, mode)
+				w.inputDone(ctx, mode)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
+		}
+	}()
+}
+
+// processInput download the ranking of 'ranking-date', or the latest one if it's empty. In service mode, the
+// rankings since the last downloaded date are downloaded day by day until the one not published yet.
+func (w *RankingWorker) processInput(ctx context.Context, mode string) {
+	if !w.options.ServiceMode {
+		w.processRanking(ctx, mode, w.options.RankingDate)
+		return
+	}
+
+	var lastDate string
+	err := Retry(func() error {
+		var err error
+		lastDate, err = w.illustMgr.GetRankingMarker(mode)
+		return err
+	}, 3)
+	if err != nil {
+		log.Warningf("[RankingWorker] Failed to get ranking marker of mode '%s', msg: %s", mode, err)
+		return
+	}
+	date := w.options.RankingDate
+	if len(lastDate) > 0 {
+		date = nextRankingDate(lastDate)
+	}
+	if len(date) == 0 {
+		// never downloaded, start from the latest
+		if rankingDate, ok := w.processRanking(ctx, mode, ""); ok {
+			w.saveRankingMarker(mode, rankingDate)
+		}
+		return
+	}
+	for len(date) > 0 {
+		rankingDate, ok := w.processRanking(ctx, mode, date)
+		if !ok {
+			return
+		}
+		w.saveRankingMarker(mode, rankingDate)
+		date = nextRankingDate(rankingDate)
+	}
+}
+
+// processRanking download all the pages of the ranking, return the date of the ranking and false if the ranking is
+// not published yet or failed
+func (w *RankingWorker) processRanking(ctx context.Context, mode, date string) (string, bool) {
+	cursorKey := pixiv.PixivID(mode)
+	page := int(w.inputQueue.cursor(cursorKey))
+	if page > 1 {
+		log.Infof("[RankingWorker] Resume %s ranking of '%s' from page %d", mode, date, page)
+	} else {
+		page = 1
+	}
+	rankingDate := date
+	for {
+		if ctx.Err() != nil {
+			return "", false
+		}
+		var (
+			rankingPage *RankingPage
+			notFound    bool
+		)
+		ok := w.retry(ctx, func() bool {
+			account, ok := w.acquireAccount(ctx)
+			if !ok {
+				return false
+			}
+			var err error
+			rankingPage, err = account.webClient.GetRanking(ctx, mode, date, page)
+			w.reportAccount(account, err)
+			observeApiRequest("get_ranking", err)
+			// pixiv may respond the latest ranking instead of the not published one
+			if errors.Is(err, pixiv.ErrNotFound) || (err == nil && len(date) > 0 && rankingPage.Date != date) {
+				notFound = true
+				return true
+			}
+			if err != nil {
+				log.Warningf("[RankingWorker] Failed to get %s ranking of '%s', page: %d, retry, msg: %s", mode, date, page, err)
+				return false
+			}
+			w.saveIllustRanks(rankingPage.Ranks)
+			_, err = w.processOutput(ctx, rankingPage.Illusts)
+			if err != nil {
+				log.Warningf("[RankingWorker] Failed to process %s ranking of '%s', page: %d, retry, msg: %s", mode, date, page, err)
+				return false
+			}
+			log.Infof("[RankingWorker] Success get %s ranking of '%s', page: %d", mode, rankingPage.Date, page)
+			return true
+		})
+		if !ok {
+			// resume from this page next time
+			return "", false
+		}
+		if notFound {
+			if page == 1 {
+				log.Infof("[RankingWorker] The %s ranking of '%s' is not published yet", mode, date)
+				w.inputQueue.deleteCursor(cursorKey)
+				return "", false
+			}
+			break
+		}
+		rankingDate = rankingPage.Date
+		if !rankingPage.HasNext {
+			break
+		}
+		page++
+		w.inputQueue.saveCursor(cursorKey, int32(page))
+	}
+	log.Infof("[RankingWorker] End %s ranking of '%s'", mode, rankingDate)
+	w.inputQueue.deleteCursor(cursorKey)
+	return rankingDate, true
+}
+
+func (w *RankingWorker) processOutput(ctx context.Context, illusts []*pixiv.IllustDigest) (int, error) {
+	newCnt := 0
+	for _, illust := range illusts {
+		if w.filterByUser(illust) {
+			continue
+		}
+
+		exist, err := w.checkIllustExist(illust.Id)
+		if err != nil {
+			log.Errorf("[RankingWorker] Failed to check illust exist, illust info: %s, msg: %s", illust.DigestString(), err)
+			return newCnt, err
+		}
+		if exist {
+			log.Debugf("[RankingWorker] Skip exist illust, illust info: %s", illust.DigestString())
+			continue
+		}
+
+		w.outputQueue.push(illust)
+		select {
+		case w.output <- illust:
+			atomic.AddUint64(&w.produceCnt, 1)
+			newCnt++
+		case <-ctx.Done():
+			return newCnt, ctx.Err()
+		}
+	}
+	return newCnt, nil
+}
+
+// saveIllustRanks record the positions of all the illust in the ranking if 'ranking-save-rank', include the exist
+// and filtered illust
+func (w *RankingWorker) saveIllustRanks(ranks []*IllustRank) {
+	if !w.options.RankingSaveRank {
+		return
+	}
+	for _, rank := range ranks {
+		err := Retry(func() error {
+			return w.illustMgr.SaveIllustRank(rank)
+		}, 3)
+		if err != nil {
+			log.Warningf("[RankingWorker] Failed to save illust rank, mode: %s, date: %s, rank: %d, pid: %s, msg: %s",
+				rank.Mode, rank.Date, rank.Rank, rank.Pid, err)
+		}
+	}
+}
+
+func (w *RankingWorker) saveRankingMarker(mode, date string) {
+	err := Retry(func() error {
+		return w.illustMgr.SaveRankingMarker(mode, date)
+	}, 3)
+	if err != nil {
+		log.Warningf("[RankingWorker] Failed to save ranking marker, mode: %s, date: %s, msg: %s", mode, date, err)
+	}
+}
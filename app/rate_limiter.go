@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const minBytesBurst = 32 * 1024
+
+// RateLimiter is shared by all the workers to throttle the requests to pixiv, the api requests and the downloaded
+// bytes of the images are limited separately by token buckets. All the requests are paused if pixiv responds
+// 'Retry-After'.
+type RateLimiter struct {
+	api   *rate.Limiter
+	bytes *rate.Limiter
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+var (
+	sharedLimiter     *RateLimiter
+	sharedLimiterOnce sync.Once
+)
+
+// SharedRateLimiter return the rate limiter shared by all the workers in this process
+func SharedRateLimiter(options *PixivDlOptions) *RateLimiter {
+	sharedLimiterOnce.Do(func() {
+		sharedLimiter = NewRateLimiter(options)
+	})
+	return sharedLimiter
+}
+
+func NewRateLimiter(options *PixivDlOptions) *RateLimiter {
+	limiter := &RateLimiter{
+		api:   rate.NewLimiter(rate.Inf, 1),
+		bytes: rate.NewLimiter(rate.Inf, minBytesBurst),
+	}
+	if options.ApiRateLimit > 0 {
+		burst := int(math.Ceil(options.ApiRateLimit))
+		limiter.api = rate.NewLimiter(rate.Limit(options.ApiRateLimit), burst)
+	}
+	if options.DownloadRateLimitKB > 0 {
+		bytesPerSec := options.DownloadRateLimitKB * 1024
+		burst := bytesPerSec
+		if burst < minBytesBurst {
+			burst = minBytesBurst
+		}
+		limiter.bytes = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+	}
+	return limiter
+}
+
+// PauseFor pause all the requests for the duration
+func (l *RateLimiter) PauseFor(duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(duration)
+	if until.After(l.pauseUntil) {
+		l.pauseUntil = until
+		log.Warningf("[RateLimiter] Pause all the requests for %s", duration)
+	}
+}
+
+func (l *RateLimiter) waitPause(ctx context.Context) error {
+	l.mu.Lock()
+	until := l.pauseUntil
+	l.mu.Unlock()
+	if wait := time.Until(until); wait > 0 && !SleepContext(ctx, wait) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// WaitApi block until an api request is allowed
+func (l *RateLimiter) WaitApi(ctx context.Context) error {
+	err := l.waitPause(ctx)
+	if err != nil {
+		return err
+	}
+	return l.api.Wait(ctx)
+}
+
+// WaitBytes block until n bytes can be read, n must not be greater than the burst
+func (l *RateLimiter) WaitBytes(ctx context.Context, n int) error {
+	return l.bytes.WaitN(ctx, n)
+}
+
+func (l *RateLimiter) bytesBurst() int {
+	return l.bytes.Burst()
+}
+
+// parseRetryAfter parse the 'Retry-After' header in seconds or HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return time.Until(date), true
+}
+
+// isImageHost return true for the image server of pixiv, e.g. 'i.pximg.net', the downloaded bytes from it are
+// limited instead of the requests
+func isImageHost(host string) bool {
+	return strings.HasSuffix(host, "pximg.net")
+}
+
+// rateLimitTransport throttle the requests of PixivWebClient with the shared RateLimiter
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	image := isImageHost(req.URL.Hostname())
+	var err error
+	if image {
+		err = t.limiter.waitPause(req.Context())
+	} else {
+		err = t.limiter.WaitApi(req.Context())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if duration, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok && duration > 0 {
+			t.limiter.PauseFor(duration)
+		}
+	}
+	if image {
+		resp.Body = &rateLimitReader{ctx: req.Context(), body: resp.Body, limiter: t.limiter}
+	}
+	return resp, nil
+}
+
+// rateLimitReader limit the read bytes per second of the response body
+type rateLimitReader struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	limiter *RateLimiter
+}
+
+func (r *rateLimitReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.bytesBurst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitBytes(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *rateLimitReader) Close() error {
+	return r.body.Close()
+}
+
+// backoffDuration return the backoff of the nth retry, it's base * 2^n and not greater than max (at least base),
+// with a random jitter in [1/2, 1) to avoid all the workers retry at the same time
+func backoffDuration(base, max time.Duration, n int32) time.Duration {
+	if max < base {
+		max = base
+	}
+	backoff := base
+	for i := int32(0); i < n && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return time.Duration(half + r.Int63n(half))
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// the 'search-order' options, the popular orders are only available for the premium account
+const (
+	SearchOrderNewest        = "newest"
+	SearchOrderOldest        = "oldest"
+	SearchOrderPopular       = "popular"
+	SearchOrderPopularMale   = "popular_male"
+	SearchOrderPopularFemale = "popular_female"
+)
+
+var searchOrders = map[string]string{
+	SearchOrderNewest:        "date_d",
+	SearchOrderOldest:        "date",
+	SearchOrderPopular:       "popular_d",
+	SearchOrderPopularMale:   "popular_male_d",
+	SearchOrderPopularFemale: "popular_female_d",
+}
+
+// the 'search-mode' options
+const (
+	SearchModeAll  = "all"
+	SearchModeSafe = "safe"
+	SearchModeR18  = "r18"
+)
+
+// the 'search-type' options
+const (
+	SearchTypeAll    = "all"
+	SearchTypeIllust = "illust"
+	SearchTypeManga  = "manga"
+	SearchTypeUgoira = "ugoira"
+)
+
+// the 'search-match' options
+const (
+	SearchMatchTag          = "tag"           // the tags partially match the keyword
+	SearchMatchTagFull      = "tag_full"      // the tags exactly match the keyword
+	SearchMatchTitleCaption = "title_caption" // the title or caption contains the keyword
+)
+
+var searchMatches = map[string]string{
+	SearchMatchTag:          "s_tag",
+	SearchMatchTagFull:      "s_tag_full",
+	SearchMatchTitleCaption: "s_tc",
+}
+
+func IsValidSearchOrder(order string) bool {
+	_, ok := searchOrders[order]
+	return ok
+}
+
+func IsValidSearchMode(mode string) bool {
+	return mode == SearchModeAll || mode == SearchModeSafe || mode == SearchModeR18
+}
+
+func IsValidSearchType(typ string) bool {
+	return typ == SearchTypeAll || typ == SearchTypeIllust || typ == SearchTypeManga || typ == SearchTypeUgoira
+}
+
+func IsValidSearchMatch(match string) bool {
+	_, ok := searchMatches[match]
+	return ok
+}
+
+// SearchParams is the conditions of the search except the keyword and page
+type SearchParams struct {
+	Order     string
+	Mode      string
+	Type      string
+	Match     string
+	StartDate string // yyyy-mm-dd, empty means no limit
+	EndDate   string
+}
+
+func NewSearchParams(options *PixivDlOptions) *SearchParams {
+	return &SearchParams{
+		Order:     options.SearchOrder,
+		Mode:      options.SearchMode,
+		Type:      options.SearchType,
+		Match:     options.SearchMatch,
+		StartDate: options.SearchStartDate,
+		EndDate:   options.SearchEndDate,
+	}
+}
+
+// String return the search conditions for log
+func (p *SearchParams) String() string {
+	return fmt.Sprintf("order: %s, mode: %s, type: %s, match: %s, date: %s ~ %s",
+		p.Order, p.Mode, p.Type, p.Match, p.StartDate, p.EndDate)
+}
+
+// query return the query of '/ajax/search/artworks/{keyword}', page starts from 1
+func (p *SearchParams) query(keyword string, page int) url.Values {
+	query := url.Values{}
+	query.Set("word", keyword)
+	query.Set("order", searchOrders[p.Order])
+	query.Set("mode", p.Mode)
+	query.Set("s_mode", searchMatches[p.Match])
+	query.Set("type", p.Type)
+	query.Set("p", strconv.Itoa(page))
+	if len(p.StartDate) > 0 {
+		query.Set("scd", p.StartDate)
+	}
+	if len(p.EndDate) > 0 {
+		query.Set("ecd", p.EndDate)
+	}
+	return query
+}
+
+// SearchResult is a page of the search result
+type SearchResult struct {
+	Illusts  []*pixiv.IllustDigest
+	Total    int
+	LastPage int
+}
+
+type searchAjaxBody struct {
+	IllustManga struct {
+		Data []struct {
+			// the advertisement has no id
+			Id        json.Number `json:"id"`
+			UserId    json.Number `json:"userId"`
+			PageCount int         `json:"pageCount"`
+		} `json:"data"`
+		Total    int `json:"total"`
+		LastPage int `json:"lastPage"`
+	} `json:"illustManga"`
+}
+
+// SearchWorker process the input keyword and output basic illust info of the search result
+type SearchWorker struct {
+	*pixivWorker
+
+	params *SearchParams
+	input  <-chan string // input keyword
+	output chan<- *pixiv.IllustDigest
+}
+
+func NewSearchWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
+	input <-chan string, output chan<- *pixiv.IllustDigest) *SearchWorker {
+	return &SearchWorker{
+		pixivWorker: newPixivWorker(options, illustMgr, options.ParseTimeoutMs),
+		params:      NewSearchParams(options),
+		input:       input,
+		output:      output,
+	}
+}
+
+func (w *SearchWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case keyword, ok := <-w.input:
+				if !ok {
+					return
+				}
+				w.processInput(ctx, keyword)
+				w.inputDone(ctx, keyword)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
+		}
+	}()
+}
+
+// processInput page through the search result. In incremental mode ('incremental-scan-pages') the scan stops after
+// the consecutive pages without new illust, it's only meaningful with the newest order.
+func (w *SearchWorker) processInput(ctx context.Context, keyword string) {
+	cursorKey := pixiv.PixivID(keyword)
+	page := int(w.inputQueue.cursor(cursorKey))
+	if page > 1 {
+		log.Infof("[SearchWorker] Resume search '%s' from page %d", keyword, page)
+	} else {
+		page = 1
+	}
+	log.Infof("[SearchWorker] Start search '%s', %s", keyword, w.params)
+	lastPage := -1
+	knownPages := int32(0)
+	for lastPage < 0 || page <= lastPage {
+		if ctx.Err() != nil {
+			return
+		}
+		if w.options.SearchMaxPages > 0 && page > int(w.options.SearchMaxPages) {
+			log.Infof("[SearchWorker] Stop search '%s' after max %d pages", keyword, w.options.SearchMaxPages)
+			break
+		}
+		newCnt := 0
+		ok := w.retry(ctx, func() bool {
+			account, ok := w.acquireAccount(ctx)
+			if !ok {
+				return false
+			}
+			result, err := account.webClient.GetSearchIllusts(ctx, keyword, w.params, page)
+			w.reportAccount(account, err)
+			observeApiRequest("search_illusts", err)
+			if errors.Is(err, pixiv.ErrNotFound) {
+				log.Warningf("[SearchWorker] Skip search '%s', msg: %s", keyword, err)
+				lastPage = 0
+				return true
+			}
+			if err != nil {
+				log.Warningf("[SearchWorker] Failed to search '%s', page: %d, retry, msg: %s", keyword, page, err)
+				return false
+			}
+			newCnt, err = w.processOutput(ctx, result.Illusts)
+			if err != nil {
+				log.Warningf("[SearchWorker] Failed to process search result '%s', page: %d, retry, msg: %s", keyword, page, err)
+				return false
+			}
+			lastPage = result.LastPage
+			if len(result.Illusts) == 0 {
+				lastPage = page
+			}
+			log.Infof("[SearchWorker] Success search '%s', page: %d/%d, total: %d", keyword, page, lastPage, result.Total)
+			return true
+		})
+		if !ok && ctx.Err() != nil {
+			return
+		}
+		if !ok && lastPage < 0 {
+			// skip the keyword if the first page can not be got
+			break
+		}
+		page++
+		w.inputQueue.saveCursor(cursorKey, int32(page))
+		if newCnt > 0 {
+			knownPages = 0
+		} else {
+			knownPages++
+		}
+		if w.options.IncrementalScanPages > 0 && knownPages >= w.options.IncrementalScanPages {
+			log.Infof("[SearchWorker] Stop incremental search '%s' after %d pages without new illust, page: %d",
+				keyword, knownPages, page)
+			break
+		}
+	}
+	log.Infof("[SearchWorker] End search '%s'", keyword)
+	w.inputQueue.deleteCursor(cursorKey)
+}
+
+func (w *SearchWorker) processOutput(ctx context.Context, illusts []*pixiv.IllustDigest) (int, error) {
+	newCnt := 0
+	for _, illust := range illusts {
+		if w.filterByUser(illust) {
+			continue
+		}
+
+		exist, err := w.checkIllustExist(illust.Id)
+		if err != nil {
+			log.Errorf("[SearchWorker] Failed to check illust exist, illust info: %s, msg: %s", illust.DigestString(), err)
+			return newCnt, err
+		}
+		if exist {
+			log.Debugf("[SearchWorker] Skip exist illust, illust info: %s", illust.DigestString())
+			continue
+		}
+
+		w.outputQueue.push(illust)
+		select {
+		case w.output <- illust:
+			atomic.AddUint64(&w.produceCnt, 1)
+			newCnt++
+		case <-ctx.Done():
+			return newCnt, ctx.Err()
+		}
+	}
+	return newCnt, nil
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// SeriesEpisode is an illust in the manga series
+type SeriesEpisode struct {
+	Pid         string
+	SeriesId    string
+	SeriesTitle string
+	Episode     int // starts from 1
+}
+
+// SeriesPage is a page of the manga series, the episodes are in the order of the page
+type SeriesPage struct {
+	Title    string
+	Total    int
+	Episodes []*SeriesEpisode
+	Illusts  map[pixiv.PixivID]*pixiv.IllustDigest
+}
+
+// seriesAjaxBody is the response of '/ajax/series/{id}'
+type seriesAjaxBody struct {
+	// the series of the user, include the others
+	IllustSeries []struct {
+		Id    json.Number `json:"id"`
+		Title string      `json:"title"`
+	} `json:"illustSeries"`
+	Page struct {
+		Series []struct {
+			WorkId json.Number `json:"workId"`
+			Order  int         `json:"order"`
+		} `json:"series"`
+		Total int `json:"total"`
+	} `json:"page"`
+	Thumbnails struct {
+		Illust []struct {
+			Id        json.Number `json:"id"`
+			UserId    json.Number `json:"userId"`
+			PageCount int         `json:"pageCount"`
+		} `json:"illust"`
+	} `json:"thumbnails"`
+}
+
+func (b *seriesAjaxBody) seriesPage(seriesId pixiv.PixivID) *SeriesPage {
+	page := &SeriesPage{Total: b.Page.Total, Illusts: make(map[pixiv.PixivID]*pixiv.IllustDigest)}
+	for _, series := range b.IllustSeries {
+		if pixiv.PixivID(series.Id) == seriesId {
+			page.Title = series.Title
+			break
+		}
+	}
+	for _, work := range b.Page.Series {
+		page.Episodes = append(page.Episodes, &SeriesEpisode{
+			Pid:         string(work.WorkId),
+			SeriesId:    string(seriesId),
+			SeriesTitle: page.Title,
+			Episode:     work.Order,
+		})
+	}
+	for _, illust := range b.Thumbnails.Illust {
+		pageCount := illust.PageCount
+		if pageCount <= 0 {
+			pageCount = 1
+		}
+		page.Illusts[pixiv.PixivID(illust.Id)] = &pixiv.IllustDigest{
+			Id:        pixiv.PixivID(illust.Id),
+			UserId:    pixiv.PixivID(illust.UserId),
+			PageCount: pageCount,
+		}
+	}
+	return page
+}
+
+// illustSeries return the series of the illust recorded by the series source, nil if not in any series or failed
+func (w *pixivWorker) illustSeries(pid pixiv.PixivID) *SeriesEpisode {
+	var episode *SeriesEpisode
+	err := Retry(func() error {
+		var err error
+		episode, err = w.illustMgr.GetIllustSeries(string(pid))
+		return err
+	}, 3)
+	if err != nil {
+		log.Warningf("[PixivWorker] Failed to get the series of illust '%s', msg: %s", pid, err)
+		return nil
+	}
+	return episode
+}
+
+// SeriesWorker process the input manga series id and output basic illust info of all the episodes in order
+type SeriesWorker struct {
+	*pixivWorker
+
+	input  <-chan pixiv.PixivID // input series id
+	output chan<- *pixiv.IllustDigest
+}
+
+func NewSeriesWorker(options *PixivDlOptions, illustMgr IllustInfoManager,
+	input <-chan pixiv.PixivID, output chan<- *pixiv.IllustDigest) *SeriesWorker {
+	return &SeriesWorker{
+		pixivWorker: newPixivWorker(options, illustMgr, options.ParseTimeoutMs),
+		input:       input,
+		output:      output,
+	}
+}
+
+func (w *SeriesWorker) Run(ctx context.Context) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case seriesId, ok := <-w.input:
+				if !ok {
+					return
+				}
+				w.processInput(ctx, seriesId)
+				w.inputDone(ctx, seriesId)
+				atomic.AddUint64(&w.consumeCnt, 1)
+			}
+		}
+	}()
+}
+
+// processInput get all the pages of the series first, because the episodes are not in order across the pages
+func (w *SeriesWorker) processInput(ctx context.Context, seriesId pixiv.PixivID) {
+	var (
+		title    string
+		episodes []*SeriesEpisode
+	)
+	illusts := make(map[pixiv.PixivID]*pixiv.IllustDigest)
+	for page, total := 1, -1; total < 0 || len(episodes) < total; page++ {
+		if ctx.Err() != nil {
+			return
+		}
+		var seriesPage *SeriesPage
+		ok := w.retry(ctx, func() bool {
+			account, ok := w.acquireAccount(ctx)
+			if !ok {
+				return false
+			}
+			var err error
+			seriesPage, err = account.webClient.GetMangaSeries(ctx, seriesId, page)
+			w.reportAccount(account, err)
+			observeApiRequest("get_manga_series", err)
+			if errors.Is(err, pixiv.ErrNotFound) {
+				log.Warningf("[SeriesWorker] Skip series '%s', msg: %s", seriesId, err)
+				return true
+			}
+			if err != nil {
+				log.Warningf("[SeriesWorker] Failed to get series '%s', page: %d, retry, msg: %s", seriesId, page, err)
+				return false
+			}
+			return true
+		})
+		if !ok && ctx.Err() == nil {
+			log.Errorf("[SeriesWorker] Give up series '%s' at page %d", seriesId, page)
+		}
+		if seriesPage == nil {
+			return
+		}
+		if len(seriesPage.Episodes) == 0 {
+			break
+		}
+		title, total = seriesPage.Title, seriesPage.Total
+		episodes = append(episodes, seriesPage.Episodes...)
+		for id, illust := range seriesPage.Illusts {
+			illusts[id] = illust
+		}
+	}
+	log.Infof("[SeriesWorker] Success get series '%s', title: %s, episodes: %d", seriesId, title, len(episodes))
+
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].Episode < episodes[j].Episode
+	})
+	for _, episode := range episodes {
+		episode.SeriesTitle = title
+		illust, ok := illusts[pixiv.PixivID(episode.Pid)]
+		if !ok {
+			illust = &pixiv.IllustDigest{Id: pixiv.PixivID(episode.Pid), PageCount: 1}
+		}
+		if !w.processOutput(ctx, episode, illust) {
+			return
+		}
+	}
+	log.Infof("[SeriesWorker] End series '%s'", seriesId)
+}
+
+// processOutput record the episode and send the illust if it's not downloaded, return false if the ctx is done
+func (w *SeriesWorker) processOutput(ctx context.Context, episode *SeriesEpisode, illust *pixiv.IllustDigest) bool {
+	// the episode is recorded even if the illust is filtered, so that the filename of the illust downloaded by
+	// the other sources can use the series
+	err := Retry(func() error {
+		return w.illustMgr.SaveIllustSeries(episode)
+	}, 3)
+	if err != nil {
+		log.Warningf("[SeriesWorker] Failed to save series episode, illust: %s, series: %s, episode: %d, msg: %s",
+			episode.Pid, episode.SeriesId, episode.Episode, err)
+	}
+
+	if w.filterByUser(illust) {
+		return true
+	}
+	exist, err := w.checkIllustExist(illust.Id)
+	if err != nil {
+		log.Errorf("[SeriesWorker] Failed to check illust exist, download it anyway, illust info: %s, msg: %s", illust.DigestString(), err)
+	} else if exist {
+		log.Debugf("[SeriesWorker] Skip exist illust, illust info: %s", illust.DigestString())
+		return true
+	}
+
+	w.outputQueue.push(illust)
+	select {
+	case w.output <- illust:
+		atomic.AddUint64(&w.produceCnt, 1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
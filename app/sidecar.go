@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+// the 'sidecar-formats' options, the sidecar is saved along with the downloaded file by appending the extension
+const (
+	SidecarFormatJson = "json" // the full illust info, see IllustSidecar
+	SidecarFormatTxt  = "txt"  // the booru style tag list, e.g. 'blue_sky, original, 風景'
+)
+
+func IsValidSidecarFormat(format string) bool {
+	return format == SidecarFormatJson || format == SidecarFormatTxt
+}
+
+// IllustSidecar is the content of the json sidecar
+type IllustSidecar struct {
+	Illust       *pixiv.IllustInfo `json:"illust"`
+	Page         int               `json:"page"`
+	Filename     string            `json:"filename"` // relative to 'download-path'
+	Sha1         string            `json:"sha1"`
+	DownloadTime time.Time         `json:"download_time"`
+}
+
+// sidecarFilename return the sidecar file of the downloaded file, e.g. '12345678_p0.jpg.json' of '12345678_p0.jpg'.
+// The extension is kept so that the files only differ in extension, e.g. 'x.jpg' and 'x.png', never share a sidecar.
+func sidecarFilename(filename, format string) string {
+	return filename + "." + format
+}
+
+// SaveIllustSidecars save the sidecars of all the formats next to the downloaded file
+func SaveIllustSidecars(sidecar *IllustSidecar, fullFilename string, formats []string) error {
+	for _, format := range formats {
+		var (
+			data []byte
+			err  error
+		)
+		switch format {
+		case SidecarFormatJson:
+			data, err = json.MarshalIndent(sidecar, "", "  ")
+		case SidecarFormatTxt:
+			data = []byte(booruTags(sidecar.Illust.Tags) + "\n")
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(sidecarFilename(fullFilename, format), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// booruTags join the tags in booru style, the spaces in a tag are replaced by underscores
+func booruTags(tags []string) string {
+	booru := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), "_")
+		if len(tag) > 0 {
+			booru = append(booru, tag)
+		}
+	}
+	return strings.Join(booru, ", ")
+}
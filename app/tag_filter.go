@@ -0,0 +1,119 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TagMatchExact      = "exact"
+	TagMatchIgnoreCase = "ignore-case"
+	TagMatchTranslated = "translated"
+
+	TagListModeAny = "any"
+	TagListModeAll = "all"
+)
+
+func IsValidTagMatch(match string) bool {
+	return match == TagMatchExact || match == TagMatchIgnoreCase || match == TagMatchTranslated
+}
+
+func IsValidTagListMode(mode string) bool {
+	return mode == TagListModeAny || mode == TagListModeAll
+}
+
+// IllustTag is a tag of the illust with its translations, e.g. {"tag": "風景", "translation": {"en": "scenery"}}
+type IllustTag struct {
+	Tag         string            `json:"tag"`
+	Translation map[string]string `json:"translation"`
+}
+
+// TagFilter filter the illust by the 'tag-white-list' and 'tag-block-list'. With 'any' mode, the list matches if
+// any tag in the list is found in the illust tags, with 'all' mode, all the tags in the list must be found.
+type TagFilter struct {
+	whiteList     []string
+	blockList     []string
+	whiteListMode string
+	blockListMode string
+	match         string
+}
+
+func NewTagFilter(options *PixivDlOptions) *TagFilter {
+	filter := &TagFilter{
+		whiteListMode: options.TagWhiteListMode,
+		blockListMode: options.TagBlockListMode,
+		match:         options.TagMatch,
+	}
+	for _, tag := range options.TagWhiteList {
+		filter.whiteList = append(filter.whiteList, filter.normalize(tag))
+	}
+	for _, tag := range options.TagBlockList {
+		filter.blockList = append(filter.blockList, filter.normalize(tag))
+	}
+	return filter
+}
+
+func (f *TagFilter) Enabled() bool {
+	return len(f.whiteList) > 0 || len(f.blockList) > 0
+}
+
+// NeedTranslation return true if the translated tags are needed, they are not in pixiv.IllustInfo
+func (f *TagFilter) NeedTranslation() bool {
+	return f.Enabled() && f.match == TagMatchTranslated
+}
+
+func (f *TagFilter) normalize(tag string) string {
+	tag = strings.TrimSpace(tag)
+	if f.match == TagMatchExact {
+		return tag
+	}
+	return strings.ToLower(tag)
+}
+
+// Filter return true and the reason if the illust should be skipped
+func (f *TagFilter) Filter(tags []IllustTag) (bool, string) {
+	if !f.Enabled() {
+		return false, ""
+	}
+
+	names := make(map[string]struct{})
+	for _, tag := range tags {
+		names[f.normalize(tag.Tag)] = struct{}{}
+		if f.match != TagMatchTranslated {
+			continue
+		}
+		for _, translation := range tag.Translation {
+			names[f.normalize(translation)] = struct{}{}
+		}
+	}
+
+	if len(f.blockList) > 0 && f.matchList(f.blockList, f.blockListMode, names) {
+		return true, fmt.Sprintf("tags match block list %v", f.blockList)
+	}
+	if len(f.whiteList) > 0 && !f.matchList(f.whiteList, f.whiteListMode, names) {
+		return true, fmt.Sprintf("tags not match white list %v", f.whiteList)
+	}
+	return false, ""
+}
+
+func (f *TagFilter) matchList(list []string, mode string, names map[string]struct{}) bool {
+	for _, tag := range list {
+		_, ok := names[tag]
+		if ok && mode != TagListModeAll {
+			return true
+		}
+		if !ok && mode == TagListModeAll {
+			return false
+		}
+	}
+	return mode == TagListModeAll
+}
+
+// IllustTagsFromNames convert the tag names to IllustTag without translation
+func IllustTagsFromNames(names []string) []IllustTag {
+	tags := make([]IllustTag, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, IllustTag{Tag: name})
+	}
+	return tags
+}
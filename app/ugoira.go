@@ -0,0 +1,240 @@
+package app
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pixiv "github.com/littleneko/pixiv-api-go"
+)
+
+const (
+	UgoiraFormatZip  = "zip"
+	UgoiraFormatGif  = "gif"
+	UgoiraFormatApng = "apng"
+	UgoiraFormatWebp = "webp"
+
+	ugoiraMetaSuffix = ".ugoira.json"
+)
+
+var ugoiraFormats = map[string]struct{}{
+	UgoiraFormatZip:  {},
+	UgoiraFormatGif:  {},
+	UgoiraFormatApng: {},
+	UgoiraFormatWebp: {},
+}
+
+// UgoiraFrame is a frame in the ugoira zip, delay in milliseconds
+type UgoiraFrame struct {
+	File  string `json:"file"`
+	Delay int    `json:"delay"`
+}
+
+// UgoiraMeta is the response of '/ajax/illust/{id}/ugoira_meta'
+type UgoiraMeta struct {
+	Src         string        `json:"src"`
+	OriginalSrc string        `json:"originalSrc"`
+	MimeType    string        `json:"mime_type"`
+	Frames      []UgoiraFrame `json:"frames"`
+}
+
+func IsValidUgoiraFormat(format string) bool {
+	_, ok := ugoiraFormats[format]
+	return ok
+}
+
+// IsUgoira check whether the illust is an ugoira (animated illust), the original url of ugoira is the first frame,
+// e.g. 'https://i.pximg.net/img-original/img/2020/01/01/00/00/00/12345678_ugoira0.jpg'
+func IsUgoira(illust *pixiv.IllustInfo) bool {
+	return strings.Contains(filepath.Base(illust.Urls.Original), "_ugoira")
+}
+
+// UgoiraFileName replace the extension of the illust filename by the ugoira format
+func UgoiraFileName(filename, format string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "." + format
+}
+
+// ugoiraCompanionFiles return the zip and metadata files stored along with the ugoira file
+func ugoiraCompanionFiles(filename string) (string, string) {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+	return base + "." + UgoiraFormatZip, base + ugoiraMetaSuffix
+}
+
+// SaveUgoiraMeta save the frames and delays to the metadata file
+func SaveUgoiraMeta(meta *UgoiraMeta, filename string) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+type animationEncoder interface {
+	AddFrame(img image.Image, delay int) error
+	Close() error
+}
+
+// ConvertUgoira convert the ugoira frames zip to an animated image
+func ConvertUgoira(zipFilename string, meta *UgoiraMeta, format string, output string) error {
+	reader, err := zip.OpenReader(zipFilename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+	frames := meta.Frames
+	// the zip contains all the frames in order even if the frames metadata is missing
+	if len(frames) == 0 {
+		for _, file := range reader.File {
+			frames = append(frames, UgoiraFrame{File: file.Name, Delay: 100})
+		}
+		sort.Slice(frames, func(i, j int) bool { return frames[i].File < frames[j].File })
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frame in ugoira zip %s", zipFilename)
+	}
+
+	outFile, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = outFile.Close()
+	}()
+
+	var encoder animationEncoder
+	switch format {
+	case UgoiraFormatGif:
+		encoder = newGifEncoder(outFile)
+	case UgoiraFormatApng:
+		encoder = newApngEncoder(outFile, len(frames))
+	case UgoiraFormatWebp:
+		encoder = newWebpAnimationEncoder(outFile)
+	default:
+		return fmt.Errorf("not supported ugoira format '%s'", format)
+	}
+
+	for _, frame := range frames {
+		file, ok := files[frame.File]
+		if !ok {
+			return fmt.Errorf("frame %s not found in ugoira zip %s", frame.File, zipFilename)
+		}
+		img, err := decodeZipImage(file)
+		if err != nil {
+			return err
+		}
+		err = encoder.AddFrame(img, frame.Delay)
+		if err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+func decodeZipImage(file *zip.File) (image.Image, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	img, _, err := image.Decode(rc)
+	return img, err
+}
+
+// gifEncoder quantize every frame to a 256 colors palette and encode all the frames when close
+type gifEncoder struct {
+	w   io.Writer
+	gif gif.GIF
+}
+
+func newGifEncoder(w io.Writer) *gifEncoder {
+	return &gifEncoder{w: w}
+}
+
+func (e *gifEncoder) AddFrame(img image.Image, delay int) error {
+	e.gif.Image = append(e.gif.Image, quantizeImage(img))
+	// the delay of gif is in 100ths of a second
+	e.gif.Delay = append(e.gif.Delay, (delay+5)/10)
+	return nil
+}
+
+func (e *gifEncoder) Close() error {
+	return gif.EncodeAll(e.w, &e.gif)
+}
+
+// rgb555 reduce the color to 15 bits
+func rgb555(c color.NRGBA) int {
+	return int(c.R>>3)<<10 | int(c.G>>3)<<5 | int(c.B>>3)
+}
+
+// quantizeImage use the 256 most popular colors in 15 bits color space as palette
+func quantizeImage(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make([]bucket, 1<<15)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			b := &buckets[rgb555(c)]
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+		}
+	}
+
+	used := make([]int, 0)
+	for idx, b := range buckets {
+		if b.count > 0 {
+			used = append(used, idx)
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return buckets[used[i]].count > buckets[used[j]].count })
+	if len(used) > 256 {
+		used = used[:256]
+	}
+	palette := make(color.Palette, 0, len(used))
+	for _, idx := range used {
+		b := buckets[idx]
+		palette = append(palette, color.RGBA{R: uint8(b.r / b.count), G: uint8(b.g / b.count), B: uint8(b.b / b.count), A: 0xff})
+	}
+
+	// cache the nearest palette index of every 15 bits color
+	lookup := make([]int16, 1<<15)
+	for i := range lookup {
+		lookup[i] = -1
+	}
+	paletted := image.NewPaletted(bounds, palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			key := rgb555(c)
+			if lookup[key] < 0 {
+				lookup[key] = int16(palette.Index(c))
+			}
+			paletted.SetColorIndex(x, y, uint8(lookup[key]))
+		}
+	}
+	return paletted
+}
@@ -1,10 +1,12 @@
 package app
 
 import (
+	"context"
 	"crypto/sha1"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -23,6 +25,30 @@ func Retry(workFunc func() error, cnt int) error {
 	return err
 }
 
+// SleepContext sleep for the duration, return false if the ctx is done before the duration elapsed
+func SleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// DateLayout is the date format of the options, e.g. 'search-start-date'
+const DateLayout = "2006-01-02"
+
+// IsValidDate return true if the date is empty or in 'yyyy-mm-dd'
+func IsValidDate(date string) bool {
+	if len(date) == 0 {
+		return true
+	}
+	_, err := time.Parse(DateLayout, date)
+	return err == nil
+}
+
 func CheckAndMkdir(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return os.MkdirAll(path, 0755)
@@ -40,6 +66,11 @@ func StandardizeFileName(name string) string {
 	return newName
 }
 
+// FileFormat return the file format by the extension, e.g. 'jpg', 'png'
+func FileFormat(filename string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+}
+
 func FileSha1Sum(filename string) (string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -51,7 +82,7 @@ func FileSha1Sum(filename string) (string, error) {
 
 	h := sha1.New()
 	if _, err := io.Copy(h, file); err != nil {
-		return "", nil
+		return "", err
 	}
 
 	sum := fmt.Sprintf("%x", h.Sum(nil))
@@ -0,0 +1,621 @@
+package app
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+// A minimal pure go WebP lossless (VP8L) encoder, it supports the subtract green transform, the predictor transform
+// and LZ77 backward references, it's used to encode the animated WebP of ugoira.
+// See https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+const (
+	vp8lSignature        = 0x2f
+	vp8lMaxImageSize     = 1 << 14
+	vp8lNumLiteralCodes  = 256
+	vp8lNumLengthCodes   = 24
+	vp8lNumDistanceCodes = 40
+	vp8lMaxCodeLength    = 15
+	vp8lMaxCLCodeLength  = 7
+	vp8lNumCLCodes       = 19
+
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+	vp8lPredictorBits          = 5
+
+	vp8lMinMatchLength = 3
+	vp8lMaxMatchLength = 4096
+	vp8lMaxChainLength = 16
+	vp8lHashBits       = 16
+	// the max distance code is (1 << 20), and the first 120 distance codes are for the 2D neighborhood
+	vp8lMaxDistance = 1<<20 - 120
+)
+
+var vp8lCodeLengthCodeOrder = [vp8lNumCLCodes]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lPredictorModes is the predictor modes we try for every block: L, T and Average2(L, T)
+var vp8lPredictorModes = [...]uint32{1, 2, 7}
+
+type vp8lBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *vp8lBitWriter) writeBits(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *vp8lBitWriter) writeSymbol(code *vp8lHuffmanCode, symbol int) {
+	w.writeBits(uint32(code.codes[symbol]), uint(code.lengths[symbol]))
+}
+
+func (w *vp8lBitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc = 0
+		w.nbits = 0
+	}
+	return w.buf
+}
+
+// vp8lHuffmanCode is a canonical huffman code, the codes are bit reversed so that they can be written LSB first
+type vp8lHuffmanCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+type huffmanNode struct {
+	count  uint32
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type huffmanNodeHeap []*huffmanNode
+
+func (h huffmanNodeHeap) Len() int { return len(h) }
+func (h huffmanNodeHeap) Less(i, j int) bool {
+	if h[i].count == h[j].count {
+		return h[i].symbol < h[j].symbol
+	}
+	return h[i].count < h[j].count
+}
+func (h huffmanNodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanNodeHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanNodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func huffmanTreeLengths(counts []uint32, lengths []uint8) int {
+	h := make(huffmanNodeHeap, 0, len(counts))
+	for symbol, count := range counts {
+		lengths[symbol] = 0
+		if count > 0 {
+			h = append(h, &huffmanNode{count: count, symbol: symbol})
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 1 {
+		left := heap.Pop(&h).(*huffmanNode)
+		right := heap.Pop(&h).(*huffmanNode)
+		symbol := left.symbol
+		if right.symbol < symbol {
+			symbol = right.symbol
+		}
+		heap.Push(&h, &huffmanNode{count: left.count + right.count, symbol: symbol, left: left, right: right})
+	}
+
+	maxLength := 0
+	var walk func(node *huffmanNode, depth int)
+	walk = func(node *huffmanNode, depth int) {
+		if node.left == nil {
+			lengths[node.symbol] = uint8(depth)
+			if depth > maxLength {
+				maxLength = depth
+			}
+			return
+		}
+		walk(node.left, depth+1)
+		walk(node.right, depth+1)
+	}
+	walk(h[0], 0)
+	return maxLength
+}
+
+// newVp8lHuffmanCode build a length limited canonical huffman code, the code always has at least two symbols,
+// so that every symbol has a non-zero code length and the code is complete.
+func newVp8lHuffmanCode(histo []uint32, maxLength int) *vp8lHuffmanCode {
+	counts := make([]uint32, len(histo))
+	copy(counts, histo)
+	used := 0
+	for _, count := range counts {
+		if count > 0 {
+			used++
+		}
+	}
+	for symbol := 0; used < 2; symbol++ {
+		if counts[symbol] == 0 {
+			counts[symbol] = 1
+			used++
+		}
+	}
+
+	lengths := make([]uint8, len(counts))
+	for huffmanTreeLengths(counts, lengths) > maxLength {
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = (count + 1) / 2
+			}
+		}
+	}
+
+	var lengthCount [vp8lMaxCodeLength + 1]uint16
+	for _, length := range lengths {
+		lengthCount[length]++
+	}
+	lengthCount[0] = 0
+	var nextCode [vp8lMaxCodeLength + 1]uint16
+	code := uint16(0)
+	for length := 1; length <= vp8lMaxCodeLength; length++ {
+		code = (code + lengthCount[length-1]) << 1
+		nextCode[length] = code
+	}
+
+	codes := make([]uint16, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		codes[symbol] = bits.Reverse16(nextCode[length]) >> (16 - length)
+		nextCode[length]++
+	}
+	return &vp8lHuffmanCode{lengths: lengths, codes: codes}
+}
+
+// writeHuffmanCode write the code lengths of code as a normal code length code
+func (w *vp8lBitWriter) writeHuffmanCode(code *vp8lHuffmanCode) {
+	var clHisto [vp8lNumCLCodes]uint32
+	for _, length := range code.lengths {
+		clHisto[length]++
+	}
+	clCode := newVp8lHuffmanCode(clHisto[:], vp8lMaxCLCodeLength)
+
+	numCodes := vp8lNumCLCodes
+	for numCodes > 4 && clCode.lengths[vp8lCodeLengthCodeOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+
+	w.writeBits(0, 1) // normal code
+	w.writeBits(uint32(numCodes-4), 4)
+	for i := 0; i < numCodes; i++ {
+		w.writeBits(uint32(clCode.lengths[vp8lCodeLengthCodeOrder[i]]), 3)
+	}
+	w.writeBits(0, 1) // max_symbol is the alphabet size
+	for _, length := range code.lengths {
+		w.writeSymbol(clCode, int(length))
+	}
+}
+
+// vp8lPrefixEncode return the prefix code, extra bits count and extra bits value of a length or distance value
+func vp8lPrefixEncode(value int) (int, uint, uint32) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+	h := bits.Len(uint(v)) - 1
+	s := (v >> (h - 1)) & 1
+	extraBits := uint(h - 1)
+	return 2*h + s, extraBits, uint32(v & (1<<extraBits - 1))
+}
+
+// vp8lSymbol is a literal ARGB pixel if length is zero, otherwise it's a backward reference
+type vp8lSymbol struct {
+	argb         uint32
+	length       int
+	distanceCode int
+}
+
+func vp8lHash(a, b uint32) uint32 {
+	return ((a * 0x9e3779b1) ^ (b * 0x85ebca6b)) >> (32 - vp8lHashBits)
+}
+
+func vp8lMatchLength(pixels []uint32, a, b, maxLength int) int {
+	n := 0
+	for n < maxLength && pixels[a+n] == pixels[b+n] {
+		n++
+	}
+	return n
+}
+
+// vp8lBackwardReferences find the LZ77 backward references with hash chain
+func vp8lBackwardReferences(pixels []uint32, width int) []vp8lSymbol {
+	symbols := make([]vp8lSymbol, 0, len(pixels)/2)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pixels))
+
+	insert := func(pos int) {
+		if pos+1 >= len(pixels) {
+			return
+		}
+		h := vp8lHash(pixels[pos], pixels[pos+1])
+		prev[pos] = head[h]
+		head[h] = int32(pos)
+	}
+
+	for i := 0; i < len(pixels); {
+		maxLength := len(pixels) - i
+		if maxLength > vp8lMaxMatchLength {
+			maxLength = vp8lMaxMatchLength
+		}
+
+		bestLength, bestDistance := 0, 0
+		// the pixel above and the left pixel have the shortest distance codes
+		for _, distance := range [...]int{width, 1} {
+			if distance <= i {
+				if n := vp8lMatchLength(pixels, i-distance, i, maxLength); n > bestLength {
+					bestLength, bestDistance = n, distance
+				}
+			}
+		}
+		if maxLength >= 2 {
+			candidate := head[vp8lHash(pixels[i], pixels[i+1])]
+			for chain := 0; candidate >= 0 && chain < vp8lMaxChainLength && bestLength < maxLength; chain++ {
+				distance := i - int(candidate)
+				if distance > vp8lMaxDistance {
+					break
+				}
+				if n := vp8lMatchLength(pixels, int(candidate), i, maxLength); n > bestLength {
+					bestLength, bestDistance = n, distance
+				}
+				candidate = prev[candidate]
+			}
+		}
+
+		if bestLength < vp8lMinMatchLength {
+			symbols = append(symbols, vp8lSymbol{argb: pixels[i]})
+			insert(i)
+			i++
+			continue
+		}
+
+		distanceCode := bestDistance + 120
+		if bestDistance == width {
+			distanceCode = 1
+		} else if bestDistance == 1 {
+			distanceCode = 2
+		}
+		symbols = append(symbols, vp8lSymbol{length: bestLength, distanceCode: distanceCode})
+		for j := 0; j < bestLength; j++ {
+			insert(i + j)
+		}
+		i += bestLength
+	}
+	return symbols
+}
+
+// writeEntropyCodedImage write the color cache info, the prefix codes and the entropy coded pixels
+func (w *vp8lBitWriter) writeEntropyCodedImage(pixels []uint32, width int, isMainImage bool) {
+	var symbols []vp8lSymbol
+	if isMainImage {
+		symbols = vp8lBackwardReferences(pixels, width)
+	} else {
+		symbols = make([]vp8lSymbol, len(pixels))
+		for i, argb := range pixels {
+			symbols[i].argb = argb
+		}
+	}
+
+	green := make([]uint32, vp8lNumLiteralCodes+vp8lNumLengthCodes)
+	red := make([]uint32, vp8lNumLiteralCodes)
+	blue := make([]uint32, vp8lNumLiteralCodes)
+	alpha := make([]uint32, vp8lNumLiteralCodes)
+	distance := make([]uint32, vp8lNumDistanceCodes)
+	for _, s := range symbols {
+		if s.length == 0 {
+			green[(s.argb>>8)&0xff]++
+			red[(s.argb>>16)&0xff]++
+			blue[s.argb&0xff]++
+			alpha[s.argb>>24]++
+			continue
+		}
+		lengthPrefix, _, _ := vp8lPrefixEncode(s.length)
+		distancePrefix, _, _ := vp8lPrefixEncode(s.distanceCode)
+		green[vp8lNumLiteralCodes+lengthPrefix]++
+		distance[distancePrefix]++
+	}
+
+	codes := [5]*vp8lHuffmanCode{
+		newVp8lHuffmanCode(green, vp8lMaxCodeLength),
+		newVp8lHuffmanCode(red, vp8lMaxCodeLength),
+		newVp8lHuffmanCode(blue, vp8lMaxCodeLength),
+		newVp8lHuffmanCode(alpha, vp8lMaxCodeLength),
+		newVp8lHuffmanCode(distance, vp8lMaxCodeLength),
+	}
+
+	w.writeBits(0, 1) // no color cache
+	if isMainImage {
+		w.writeBits(0, 1) // no meta prefix codes
+	}
+	for _, code := range codes {
+		w.writeHuffmanCode(code)
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			w.writeSymbol(codes[0], int((s.argb>>8)&0xff))
+			w.writeSymbol(codes[1], int((s.argb>>16)&0xff))
+			w.writeSymbol(codes[2], int(s.argb&0xff))
+			w.writeSymbol(codes[3], int(s.argb>>24))
+			continue
+		}
+		prefix, extraBits, extraValue := vp8lPrefixEncode(s.length)
+		w.writeSymbol(codes[0], vp8lNumLiteralCodes+prefix)
+		w.writeBits(extraValue, extraBits)
+		prefix, extraBits, extraValue = vp8lPrefixEncode(s.distanceCode)
+		w.writeSymbol(codes[4], prefix)
+		w.writeBits(extraValue, extraBits)
+	}
+}
+
+func vp8lSubPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+func vp8lAverage2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func vp8lPredict(mode uint32, left, top uint32) uint32 {
+	switch mode {
+	case 1:
+		return left
+	case 2:
+		return top
+	default:
+		return vp8lAverage2(left, top)
+	}
+}
+
+func vp8lAbsResidual(residual uint32) uint32 {
+	sum := uint32(0)
+	for shift := 0; shift < 32; shift += 8 {
+		c := int8(residual >> shift)
+		if c < 0 {
+			sum += uint32(-int(c))
+		} else {
+			sum += uint32(c)
+		}
+	}
+	return sum
+}
+
+func vp8lSubSampleSize(size int, sampleBits uint) int {
+	return (size + 1<<sampleBits - 1) >> sampleBits
+}
+
+// vp8lApplyPredictor choose the best predictor mode for every block and return the modes sub image and residuals
+func vp8lApplyPredictor(pixels []uint32, width, height int) ([]uint32, []uint32) {
+	blockSize := 1 << vp8lPredictorBits
+	blocksX := vp8lSubSampleSize(width, vp8lPredictorBits)
+	blocksY := vp8lSubSampleSize(height, vp8lPredictorBits)
+	modes := make([]uint32, blocksX*blocksY)
+	residuals := make([]uint32, len(pixels))
+
+	for by := 0; by < blocksY; by++ {
+		for bx := 0; bx < blocksX; bx++ {
+			bestMode, bestCost := vp8lPredictorModes[0], ^uint32(0)
+			for _, mode := range vp8lPredictorModes {
+				cost := uint32(0)
+				for y := by * blockSize; y < height && y < (by+1)*blockSize; y++ {
+					for x := bx * blockSize; x < width && x < (bx+1)*blockSize; x++ {
+						if x == 0 || y == 0 {
+							continue
+						}
+						i := y*width + x
+						cost += vp8lAbsResidual(vp8lSubPixels(pixels[i], vp8lPredict(mode, pixels[i-1], pixels[i-width])))
+					}
+				}
+				if cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksX+bx] = 0xff000000 | bestMode<<8
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var predict uint32
+			switch {
+			case x == 0 && y == 0:
+				predict = 0xff000000
+			case y == 0:
+				predict = pixels[i-1]
+			case x == 0:
+				predict = pixels[i-width]
+			default:
+				mode := (modes[(y>>vp8lPredictorBits)*blocksX+(x>>vp8lPredictorBits)] >> 8) & 0xff
+				predict = vp8lPredict(mode, pixels[i-1], pixels[i-width])
+			}
+			residuals[i] = vp8lSubPixels(pixels[i], predict)
+		}
+	}
+	return modes, residuals
+}
+
+// encodeVp8l encode the image to a VP8L bitstream
+func encodeVp8l(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= 0 || height <= 0 || width > vp8lMaxImageSize || height > vp8lMaxImageSize {
+		return nil, errors.New("webp: invalid image size")
+	}
+
+	pixels := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			if c.A < 0xff {
+				hasAlpha = true
+			}
+			pixels[y*width+x] = uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+		}
+	}
+
+	w := &vp8lBitWriter{}
+	w.writeBits(vp8lSignature, 8)
+	w.writeBits(uint32(width-1), 14)
+	w.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+	w.writeBits(0, 3) // version
+
+	// subtract green transform
+	w.writeBits(1, 1)
+	w.writeBits(vp8lTransformSubtractGreen, 2)
+	for i, argb := range pixels {
+		green := (argb >> 8) & 0xff
+		red := ((argb >> 16) - green) & 0xff
+		blue := (argb - green) & 0xff
+		pixels[i] = argb&0xff00ff00 | red<<16 | blue
+	}
+
+	// predictor transform
+	modes, residuals := vp8lApplyPredictor(pixels, width, height)
+	w.writeBits(1, 1)
+	w.writeBits(vp8lTransformPredictor, 2)
+	w.writeBits(vp8lPredictorBits-2, 3)
+	w.writeEntropyCodedImage(modes, vp8lSubSampleSize(width, vp8lPredictorBits), false)
+
+	w.writeBits(0, 1) // no more transform
+	w.writeEntropyCodedImage(residuals, width, true)
+	return w.bytes(), nil
+}
+
+// webpAnimationEncoder write an animated WebP file frame by frame
+type webpAnimationEncoder struct {
+	w      io.WriteSeeker
+	width  int
+	height int
+	size   uint32 // size of the RIFF payload
+	err    error
+}
+
+func newWebpAnimationEncoder(w io.WriteSeeker) *webpAnimationEncoder {
+	return &webpAnimationEncoder{w: w}
+}
+
+func (e *webpAnimationEncoder) write(data []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(data)
+	e.size += uint32(len(data))
+}
+
+func (e *webpAnimationEncoder) writeChunkHeader(fourCC string, size int) {
+	header := make([]byte, 8)
+	copy(header, fourCC)
+	binary.LittleEndian.PutUint32(header[4:], uint32(size))
+	e.write(header)
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func (e *webpAnimationEncoder) writeHeader(width, height int) {
+	e.width, e.height = width, height
+	// the RIFF size will be rewrite when close
+	e.write([]byte("RIFF\x00\x00\x00\x00WEBP"))
+	e.size = 4
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10 // animation and alpha flags
+	putUint24(vp8x[4:], width-1)
+	putUint24(vp8x[7:], height-1)
+	e.writeChunkHeader("VP8X", len(vp8x))
+	e.write(vp8x)
+
+	anim := make([]byte, 6) // background color 0 and loop forever
+	e.writeChunkHeader("ANIM", len(anim))
+	e.write(anim)
+}
+
+// AddFrame encode the image as a frame of the animation, delay in milliseconds
+func (e *webpAnimationEncoder) AddFrame(img image.Image, delay int) error {
+	if e.err != nil {
+		return e.err
+	}
+	bounds := img.Bounds()
+	if e.width == 0 {
+		e.writeHeader(bounds.Dx(), bounds.Dy())
+	}
+
+	data, err := encodeVp8l(img)
+	if err != nil {
+		return err
+	}
+	padding := len(data) & 1
+
+	frame := make([]byte, 16)
+	putUint24(frame[6:], bounds.Dx()-1)
+	putUint24(frame[9:], bounds.Dy()-1)
+	putUint24(frame[12:], delay)
+	frame[15] = 0x02 // do not blend, do not dispose
+	e.writeChunkHeader("ANMF", len(frame)+8+len(data)+padding)
+	e.write(frame)
+	e.writeChunkHeader("VP8L", len(data))
+	e.write(data)
+	if padding > 0 {
+		e.write([]byte{0})
+	}
+	return e.err
+}
+
+// Close rewrite the RIFF size, it doesn't close the underlying writer
+func (e *webpAnimationEncoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.width == 0 {
+		return errors.New("webp: no frame")
+	}
+	if _, err := e.w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, e.size)
+	if _, err := e.w.Write(size); err != nil {
+		return err
+	}
+	_, err := e.w.Seek(0, io.SeekEnd)
+	return err
+}
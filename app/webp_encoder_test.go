@@ -0,0 +1,219 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testFrame return an image with gradients, noise and translucent pixels, so that all the predictor modes, the
+// backward references and the alpha are used
+func testFrame(width, height int, seed int64) *image.NRGBA {
+	rnd := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x * 7), G: uint8(y * 5), B: uint8((x + y) * 3), A: 0xff}
+			switch {
+			case x%11 == 0:
+				c.A = uint8(rnd.Intn(256))
+			case (x+y)%5 == 0:
+				c.R, c.G, c.B = uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func assertSameImage(t *testing.T, want, got image.Image) {
+	t.Helper()
+	if !want.Bounds().Size().Eq(got.Bounds().Size()) {
+		t.Fatalf("size mismatch, want: %v, got: %v", want.Bounds().Size(), got.Bounds().Size())
+	}
+	wb, gb := want.Bounds(), got.Bounds()
+	for y := 0; y < wb.Dy(); y++ {
+		for x := 0; x < wb.Dx(); x++ {
+			wc := color.NRGBAModel.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+			gc := color.NRGBAModel.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+			if wc.A == 0 && gc.A == 0 {
+				// the color of the fully transparent pixel is undefined
+				continue
+			}
+			if wc != gc {
+				t.Fatalf("pixel (%d, %d) mismatch, want: %v, got: %v", x, y, wc, gc)
+			}
+		}
+	}
+}
+
+// webpFile wrap the chunks into a RIFF WebP file
+func webpFile(chunks ...[]byte) []byte {
+	payload := []byte("WEBP")
+	for _, chunk := range chunks {
+		payload = append(payload, chunk...)
+	}
+	header := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := []byte(fourCC + "\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+type riffChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// parseRiffChunks split the chunks and check the sizes and paddings
+func parseRiffChunks(t *testing.T, data []byte) []riffChunk {
+	t.Helper()
+	var chunks []riffChunk
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated chunk header: %d bytes", len(data))
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if 8+size > len(data) {
+			t.Fatalf("chunk '%s' size %d out of range %d", data[:4], size, len(data)-8)
+		}
+		chunks = append(chunks, riffChunk{fourCC: string(data[:4]), data: data[8 : 8+size]})
+		data = data[8+size+size%2:]
+	}
+	return chunks
+}
+
+func TestEncodeVp8lLossless(t *testing.T) {
+	solid := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0x80
+	}
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"1x1", testFrame(1, 1, 1)},
+		{"odd size", testFrame(17, 13, 2)},
+		{"larger than a predictor block", testFrame(97, 61, 3)},
+		{"solid", solid},
+		{"offset bounds", testFrame(50, 40, 4).SubImage(image.Rect(5, 7, 45, 33))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encodeVp8l(tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := webp.Decode(bytes.NewReader(webpFile(webpChunk("VP8L", data))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameImage(t, tt.img, got)
+		})
+	}
+}
+
+func TestEncodeVp8lInvalidSize(t *testing.T) {
+	if _, err := encodeVp8l(image.NewNRGBA(image.Rect(0, 0, 0, 10))); err == nil {
+		t.Fatal("expect error for empty image")
+	}
+}
+
+func TestWebpAnimationEncoder(t *testing.T) {
+	const width, height = 33, 21
+	delays := []int{100, 250, 70000}
+	frames := make([]*image.NRGBA, len(delays))
+	for i := range frames {
+		frames[i] = testFrame(width, height, int64(10+i))
+	}
+
+	filename := filepath.Join(t.TempDir(), "ugoira.webp")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := newWebpAnimationEncoder(file)
+	for i, frame := range frames {
+		if err := encoder.AddFrame(frame, delays[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("invalid RIFF header: %q", data[:12])
+	}
+	if size := int(binary.LittleEndian.Uint32(data[4:])); size != len(data)-8 {
+		t.Fatalf("RIFF size mismatch, want: %d, got: %d", len(data)-8, size)
+	}
+
+	chunks := parseRiffChunks(t, data[12:])
+	if len(chunks) != 2+len(frames) {
+		t.Fatalf("chunk count mismatch, want: %d, got: %d", 2+len(frames), len(chunks))
+	}
+	vp8x := chunks[0]
+	if vp8x.fourCC != "VP8X" || len(vp8x.data) != 10 {
+		t.Fatalf("invalid VP8X chunk: %s, %d bytes", vp8x.fourCC, len(vp8x.data))
+	}
+	if vp8x.data[0]&0x02 == 0 {
+		t.Fatalf("animation flag is not set: %#x", vp8x.data[0])
+	}
+	if w, h := uint24(vp8x.data[4:])+1, uint24(vp8x.data[7:])+1; w != width || h != height {
+		t.Fatalf("canvas size mismatch, want: %dx%d, got: %dx%d", width, height, w, h)
+	}
+	if chunks[1].fourCC != "ANIM" || len(chunks[1].data) != 6 {
+		t.Fatalf("invalid ANIM chunk: %s, %d bytes", chunks[1].fourCC, len(chunks[1].data))
+	}
+
+	for i, chunk := range chunks[2:] {
+		if chunk.fourCC != "ANMF" {
+			t.Fatalf("frame %d: unexpected chunk '%s'", i, chunk.fourCC)
+		}
+		if x, y := uint24(chunk.data[0:]), uint24(chunk.data[3:]); x != 0 || y != 0 {
+			t.Fatalf("frame %d: offset mismatch, got: (%d, %d)", i, x, y)
+		}
+		if w, h := uint24(chunk.data[6:])+1, uint24(chunk.data[9:])+1; w != width || h != height {
+			t.Fatalf("frame %d: size mismatch, got: %dx%d", i, w, h)
+		}
+		if delay := uint24(chunk.data[12:]); delay != delays[i] {
+			t.Fatalf("frame %d: delay mismatch, want: %d, got: %d", i, delays[i], delay)
+		}
+		inner := parseRiffChunks(t, chunk.data[16:])
+		if len(inner) != 1 || inner[0].fourCC != "VP8L" {
+			t.Fatalf("frame %d: expect a VP8L chunk, got: %v", i, inner)
+		}
+		got, err := webp.Decode(bytes.NewReader(webpFile(webpChunk("VP8L", inner[0].data))))
+		if err != nil {
+			t.Fatalf("frame %d: %s", i, err)
+		}
+		assertSameImage(t, frames[i], got)
+	}
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
@@ -0,0 +1,55 @@
+/*
+Copyright © 2023 litao.little@gmail.com
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"pixiv/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// authCmd represents the auth command
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage the login status of the cookies",
+}
+
+var authCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check whether the cookies are logged in",
+	Long: `Check whether the cookie of every account is logged in and whose account it is. With an
+expired cookie pixiv responds empty bookmarks and not found illust instead of errors, so it's
+recommended to check it after updating the cookie. Exit with code 1 if any cookie is not logged in.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		ok := true
+		for _, status := range app.CheckAccounts(cmd.Context(), options) {
+			switch {
+			case !status.HasCookie:
+				fmt.Printf("ANONYMOUS\tACCOUNT: %s\n", status.Account)
+			case status.Err != nil:
+				fmt.Printf("FAILED\tACCOUNT: %s, MSG: %s\n", status.Account, status.Err)
+			case !status.LoggedIn:
+				fmt.Printf("LOGGED OUT\tACCOUNT: %s\n", status.Account)
+			default:
+				fmt.Printf("OK\tACCOUNT: %s, UID: %s, NAME: %s\n", status.Account, status.UserId, status.UserName)
+			}
+			ok = ok && status.Ok()
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	authCmd.AddCommand(authCheckCmd)
+}
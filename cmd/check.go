@@ -0,0 +1,73 @@
+/*
+Copyright © 2023 litao.little@gmail.com
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"pixiv/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	checkFix          = false
+	checkRemoveOrphan = false
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the downloaded files with the database",
+	Long: `Check every illust recorded in database, verify the file exists in download path and
+the sha1 of the file matches the database. The missing, corrupted and orphaned (not recorded
+in database) files will be reported. Use '--fix' to delete the records of missing and
+corrupted files so that they will be downloaded next time.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+		defer func() {
+			_ = illustMgr.Close()
+		}()
+
+		result, err := illustMgr.CheckDatabaseAndFile(&app.CheckOptions{
+			DownloadPath: options.DownloadPath,
+			Fix:          checkFix,
+			RemoveOrphan: checkRemoveOrphan,
+		})
+		cobra.CheckErr(err)
+
+		for _, item := range result.Missing {
+			fmt.Printf("MISSING\t%s\n", checkItemString(item))
+		}
+		for _, item := range result.Corrupted {
+			fmt.Printf("CORRUPTED\t%s\n", checkItemString(item))
+		}
+		for _, filename := range result.Orphaned {
+			fmt.Printf("ORPHANED\tFILE: %s\n", filename)
+		}
+		for _, filename := range result.Partial {
+			fmt.Printf("PARTIAL\tFILE: %s\n", filename)
+		}
+		fmt.Printf("Total: %d, ok: %d, missing: %d, corrupted: %d, orphaned: %d, partial: %d\n",
+			result.Total, result.Ok, len(result.Missing), len(result.Corrupted), len(result.Orphaned), len(result.Partial))
+	},
+}
+
+func checkItemString(item *app.CheckItem) string {
+	if item.Novel {
+		return fmt.Sprintf("NOVEL: %s, FILE: %s", item.Pid, item.Filename)
+	}
+	return fmt.Sprintf("ID: %s, PAGE: %d, FILE: %s", item.Pid, item.Page, item.Filename)
+}
+
+func init() {
+	checkCmd.Flags().BoolVar(&checkFix, "fix", false, "Delete the database records of missing and corrupted files, they will be downloaded next time")
+	checkCmd.Flags().BoolVar(&checkRemoveOrphan, "remove-orphan", false, "Delete the files which are not recorded in database")
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2023 litao.little@gmail.com
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"pixiv/app"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var dbListStatus = ""
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Query the illust recorded in database",
+}
+
+var dbListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the illust pages in database",
+	Long: `List the illust pages recorded in database with the status, the status is one of
+'ok' (downloaded), 'not_found', 'restricted' (no url, e.g. R-18 without login), 'failed'
+(give up after max retries) and 'duplicate' (skipped as near duplicate). The failed illust are
+retried next time, the not found and restricted illust are retried after 'recheck-interval-sec'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		status := strings.ToLower(strings.TrimSpace(dbListStatus))
+		if len(status) > 0 && !app.IsValidIllustStatus(status) {
+			cobra.CheckErr(fmt.Sprintf("Not supported status '%s'", dbListStatus))
+		}
+
+		options := getOptions()
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+		defer func() {
+			_ = illustMgr.Close()
+		}()
+
+		records, err := illustMgr.ListIllusts(status)
+		cobra.CheckErr(err)
+		for _, record := range records {
+			fmt.Printf("%s\tID: %s, PAGE: %d, TITLE: %s, USER: %s, FILE: %s, TIME: %s\n", strings.ToUpper(record.Status),
+				record.Pid, record.Page, record.Title, record.UserId, record.Filename, record.StatusTime.Local().Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("Total: %d\n", len(records))
+	},
+}
+
+func init() {
+	dbListCmd.Flags().StringVar(&dbListStatus, "status", "", "Only list the illust with this status, choices: ['ok', 'not_found', 'restricted', 'failed', 'duplicate']")
+
+	dbCmd.AddCommand(dbListCmd)
+}
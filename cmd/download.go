@@ -6,12 +6,14 @@ Copyright © 2023 litao.little@gmail.com
 package cmd
 
 import (
+	"context"
 	"math"
-	"os"
 	"os/signal"
 	"pixiv/app"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -35,21 +37,40 @@ and download new illust periodically.`,
 		illustMgr, err := app.GetIllustInfoManager(options)
 		cobra.CheckErr(err)
 
-		if len(options.DownloadBookmarksUserIds) > 0 {
-			downloadBookmarks(options, illustMgr)
+		// all the downloaders are needed to enqueue any kind of jobs by the control API
+		withControl := options.ServiceMode && len(options.ListenAddr) > 0
+		var downloaders []app.PixivDownloader
+		if len(options.DownloadBookmarksUserIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewBookmarksDownloader(options, illustMgr))
 		}
-		if len(options.DownloadIllustIds) > 0 {
-			downloadIllusts(options, illustMgr)
+		if len(options.DownloadIllustIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewIllustDownloader(options, illustMgr))
 		}
-		if len(options.DownloadArtistUserIds) > 0 {
-			downloadArtists(options, illustMgr)
+		if len(options.DownloadArtistUserIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewArtistDownloader(options, illustMgr))
 		}
-
-		if options.ServiceMode {
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			<-sigCh
+		if len(options.DownloadFollowingUserIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewFollowingDownloader(options, illustMgr))
+		}
+		if len(options.DownloadNovelIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewNovelDownloader(options, illustMgr))
+		}
+		if len(options.DownloadNovelArtistUserIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewNovelArtistDownloader(options, illustMgr))
+		}
+		if len(options.DownloadNovelBookmarksUserIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewNovelBookmarksDownloader(options, illustMgr))
+		}
+		if len(options.DownloadSearchKeywords) > 0 || withControl {
+			downloaders = append(downloaders, app.NewSearchDownloader(options, illustMgr))
 		}
+		if len(options.DownloadRankingModes) > 0 || withControl {
+			downloaders = append(downloaders, app.NewRankingDownloader(options, illustMgr))
+		}
+		if len(options.DownloadSeriesIds) > 0 || withControl {
+			downloaders = append(downloaders, app.NewSeriesDownloader(options, illustMgr))
+		}
+		runDownloaders(options, illustMgr, downloaders...)
 	},
 }
 
@@ -69,13 +90,7 @@ var downloadIllustCmd = &cobra.Command{
 		illustMgr, err := app.GetIllustInfoManager(options)
 		cobra.CheckErr(err)
 
-		downloadIllusts(options, illustMgr)
-
-		if options.ServiceMode {
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			<-sigCh
-		}
+		runDownloaders(options, illustMgr, app.NewIllustDownloader(options, illustMgr))
 	},
 }
 
@@ -95,13 +110,7 @@ var downloadArtistCmd = &cobra.Command{
 		illustMgr, err := app.GetIllustInfoManager(options)
 		cobra.CheckErr(err)
 
-		downloadArtists(options, illustMgr)
-
-		if options.ServiceMode {
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			<-sigCh
-		}
+		runDownloaders(options, illustMgr, app.NewArtistDownloader(options, illustMgr))
 	},
 }
 
@@ -121,13 +130,156 @@ var downloadBookmarkCmd = &cobra.Command{
 		illustMgr, err := app.GetIllustInfoManager(options)
 		cobra.CheckErr(err)
 
-		downloadBookmarks(options, illustMgr)
+		runDownloaders(options, illustMgr, app.NewBookmarksDownloader(options, illustMgr))
+	},
+}
+
+var downloadFollowingCmd = &cobra.Command{
+	Use:   "following [user id list]",
+	Short: "Download all illust of the user's following users",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cobra.CheckErr("Must give at least one user id")
+		}
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadFollowingUserIds = processListArgs(args)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewFollowingDownloader(options, illustMgr))
+	},
+}
+
+var downloadNovelCmd = &cobra.Command{
+	Use:   "novel [novel id list]",
+	Short: "Download by novel id",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cobra.CheckErr("Must give at least one novel id")
+		}
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadNovelIds = processListArgs(args)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewNovelDownloader(options, illustMgr))
+	},
+}
+
+var downloadNovelArtistCmd = &cobra.Command{
+	Use:   "novel-artist [user id list]",
+	Short: "Download all novels of the user",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cobra.CheckErr("Must give at least one user id")
+		}
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadNovelArtistUserIds = processListArgs(args)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewNovelArtistDownloader(options, illustMgr))
+	},
+}
+
+var downloadNovelBookmarkCmd = &cobra.Command{
+	Use:   "novel-bookmark [user id list]",
+	Short: "Download all bookmark novels of the user",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cobra.CheckErr("Must give at least one user id")
+		}
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadNovelBookmarksUserIds = processListArgs(args)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewNovelBookmarksDownloader(options, illustMgr))
+	},
+}
+
+var downloadSearchCmd = &cobra.Command{
+	Use:   "search [keyword]...",
+	Short: "Download the illust of the keyword search result",
+	Long: `Download the illust of the keyword search result, every argument is a keyword,
+e.g. 'download search "初音ミク 10000users入り" 風景'. Use the '--search-*' flags to
+set the order, date range, R18 mode and type of the search.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cobra.CheckErr("Must give at least one keyword")
+		}
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadSearchKeywords = standardizeIds(args)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewSearchDownloader(options, illustMgr))
+	},
+}
+
+var downloadRankingCmd = &cobra.Command{
+	Use:   "ranking",
+	Short: "Download the illust of the rankings",
+	Long: `Download the illust of the rankings, e.g. 'download ranking --mode daily,weekly --date 2023-01-01'.
+In service mode, the rankings from '--date' (or the latest if not set) are downloaded,
+and then every new day of the rankings is downloaded automatically.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		modes, _ := cmd.Flags().GetStringSlice("mode")
+		date, _ := cmd.Flags().GetString("date")
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadRankingModes = standardizeModes(modes)
+		if len(date) > 0 {
+			options.RankingDate = strings.TrimSpace(date)
+		}
+		validateRankingOptions(options)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewRankingDownloader(options, illustMgr))
+	},
+}
 
-		if options.ServiceMode {
-			sigCh := make(chan os.Signal, 1)
-			signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-			<-sigCh
+var downloadSeriesCmd = &cobra.Command{
+	Use:   "series [series id list]",
+	Short: "Download all episodes of the manga series",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			cobra.CheckErr("Must give at least one series id")
 		}
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		options.DownloadSeriesIds = processListArgs(args)
+		log.Infof("Use options: %s", options.ToJson(true))
+
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+
+		runDownloaders(options, illustMgr, app.NewSeriesDownloader(options, illustMgr))
 	},
 }
 
@@ -135,26 +287,68 @@ const defaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleW
 
 func init() {
 	downloadCmd.PersistentFlags().Bool("service-mode", false, "Run as a service, check and download new illust periodically")
-	downloadCmd.PersistentFlags().String("database-type", "SQLITE", "Database to store the illust info, 'NONE' means not use database and not check illust exist, choices: ['NONE', 'SQLITE']")
-	downloadCmd.PersistentFlags().String("sqlite-path", "storage", "Sqlite file location if use sqlite database")
-	downloadCmd.PersistentFlags().String("download-path", "pixiv", "Download file location")
-	downloadCmd.PersistentFlags().String("filename-pattern", "{id}", "Filename pattern, all tag can use: ['user_id, 'user', 'id', 'title']")
+	downloadCmd.PersistentFlags().String("filename-pattern", "{id}", "Filename pattern, a go text/template with shorthands: ['id', 'pid', 'page', 'title', 'user_id', 'user', 'account', 'tags', 'r18', 'width', 'height', 'bookmarks', 'likes', 'series', 'series_id', 'episode', 'yyyy', 'yyyy-mm', 'yyyy-mm-dd'], '/' creates subdirectories")
+	downloadCmd.PersistentFlags().Int("filename-max-length", 200, "Max bytes of every path element of the filename, 0 means no limit")
+	downloadCmd.PersistentFlags().String("filename-collision", "rename", "What to do if the file already exists, choices: ['rename', 'overwrite', 'skip']")
+	downloadCmd.PersistentFlags().StringSlice("sidecar-formats", []string{}, "Save the metadata sidecars next to every downloaded file, choices: ['json', 'txt'], 'json' is the full illust info and 'txt' is the booru style tag list")
+	downloadCmd.PersistentFlags().Bool("embed-metadata", false, "Write the title, artist, tags, source url and creation date into the downloaded JPEG (XMP and EXIF) and PNG (iTXt) files without re-encoding")
+	downloadCmd.PersistentFlags().Int("phash-max-distance", 5, "Max different bits of the perceptual hashes (64 bits DHash) of two illust pages to be near duplicates")
+	downloadCmd.PersistentFlags().Bool("skip-near-duplicate", false, "Delete the downloaded illust page and mark it as 'duplicate' if it's the near duplicate of a downloaded page of another illust")
+	downloadCmd.PersistentFlags().String("novel-format", "md", "The format to save novel, the cover is embedded in epub or saved along with the others, choices: ['md', 'txt', 'epub']")
+	downloadCmd.PersistentFlags().String("novel-filename-pattern", "novel/{id}", "Novel filename pattern, the same as 'filename-pattern' with shorthands: ['id', 'title', 'user_id', 'user', 'tags', 'r18', 'series', 'series_id', 'bookmarks', 'likes', 'yyyy', 'yyyy-mm', 'yyyy-mm-dd']")
+	downloadCmd.PersistentFlags().String("search-order", "newest", "The order of the search result, the popular orders need the premium account, choices: ['newest', 'oldest', 'popular', 'popular_male', 'popular_female']")
+	downloadCmd.PersistentFlags().String("search-mode", "all", "The R18 mode of the search, choices: ['all', 'safe', 'r18']")
+	downloadCmd.PersistentFlags().String("search-type", "all", "The illust type of the search, choices: ['all', 'illust', 'manga', 'ugoira']")
+	downloadCmd.PersistentFlags().String("search-match", "tag", "How the keyword matches, choices: ['tag', 'tag_full', 'title_caption'], 'tag' is partial match and 'tag_full' is exact match")
+	downloadCmd.PersistentFlags().String("search-start-date", "", "Only search the illust uploaded since this date, in 'yyyy-mm-dd'")
+	downloadCmd.PersistentFlags().String("search-end-date", "", "Only search the illust uploaded until this date, in 'yyyy-mm-dd'")
+	downloadCmd.PersistentFlags().Int32("search-max-pages", 0, "Max pages (60 illust per page) to scan of the search result for every keyword, 0 means no limit")
+	downloadCmd.PersistentFlags().String("ranking-date", "", "The date of the rankings in 'yyyy-mm-dd', empty means the latest, in service mode the rankings since this date are downloaded day by day")
+	downloadCmd.PersistentFlags().Bool("ranking-save-rank", false, "Record the rank position of all the illust in the rankings in the database")
+	downloadCmd.PersistentFlags().String("bookmarks-visibility", "public", "Which bookmarks to download, choices: ['public', 'private', 'all'], the private bookmarks need the cookie of the user")
+	downloadCmd.PersistentFlags().StringSlice("bookmark-tags", []string{}, "Only download the bookmarks with these bookmark tags, '未分類' means the bookmarks without tag, empty means all")
+	downloadCmd.PersistentFlags().String("ugoira-format", "zip", "The format to save ugoira (animated illust), the frames zip is always kept, choices: ['zip', 'gif', 'apng', 'webp']")
 	downloadCmd.PersistentFlags().Int32("scan-interval-sec", 3600, "The interval to check new illust if run in service mode")
+	downloadCmd.PersistentFlags().Int32("incremental-scan-pages", 0, "Stop scanning the bookmarks after this number of consecutive pages have no new illust, 0 means always scan all the pages")
+	downloadCmd.PersistentFlags().Int32("full-scan-interval-sec", 86400, "The interval to scan all the bookmarks pages if incremental scan is enabled, 0 means never")
+	downloadCmd.PersistentFlags().Int32("recheck-interval-sec", 0, "Try the not found and restricted illust again after this interval, 0 means never")
 	downloadCmd.PersistentFlags().Int32("parse-parallel", 5, "Parallel number to get an parse illust info")
 	downloadCmd.PersistentFlags().Int32("download-parallel", 10, "Parallel number to download illust")
 	downloadCmd.PersistentFlags().Int32("max-retries", math.MaxInt32, "Max retry times")
-	downloadCmd.PersistentFlags().Int32("retry-backoff-ms", 30000, "Backoff time if request failed")
+	downloadCmd.PersistentFlags().Int32("retry-backoff-ms", 30000, "Backoff time if request failed, it's doubled for every retry")
+	downloadCmd.PersistentFlags().Int32("retry-max-backoff-ms", 600000, "Max backoff time of the retries")
+	downloadCmd.PersistentFlags().Float64("api-rate-limit", 0, "Max pixiv api requests per second of all the workers, 0 means no limit")
+	downloadCmd.PersistentFlags().Int("download-rate-limit-kb", 0, "Max download speed in KB/s of all the workers, 0 means no limit")
 	downloadCmd.PersistentFlags().Int32("parse-timeout-ms", 5000, "Timeout for get illust info")
 	downloadCmd.PersistentFlags().Int32("download-timeout-ms", 600000, "Timeout for download illust")
+	downloadCmd.PersistentFlags().String("listen-addr", "", "Listen address of the HTTP control API in service mode, e.g. '127.0.0.1:8080', disabled if empty")
+	downloadCmd.PersistentFlags().Int32("auth-check-interval-sec", 1800, "The interval to check whether the cookies are still logged in if run in service mode, 0 means never")
+	downloadCmd.PersistentFlags().Int32("shutdown-timeout-sec", 60, "Max time to wait for the in-flight downloads finishing when stopping")
 
 	downloadCmd.Flags().StringSlice("dl-bookmarks-uids", []string{}, "Download all bookmarks illust of this user")
 	downloadCmd.Flags().StringSlice("dl-following-uids", []string{}, "Download all following user's illust of this user")
 	downloadCmd.Flags().StringSlice("dl-artist-uids", []string{}, "Download all illust of this user")
 	downloadCmd.Flags().StringSlice("dl-illust-ids", []string{}, "Download illust of this id")
+	downloadCmd.Flags().StringSlice("dl-novel-ids", []string{}, "Download novel of this id")
+	downloadCmd.Flags().StringSlice("dl-novel-artist-uids", []string{}, "Download all novels of this user")
+	downloadCmd.Flags().StringSlice("dl-novel-bookmarks-uids", []string{}, "Download all bookmarks novels of this user")
+	downloadCmd.Flags().StringSlice("dl-search-keywords", []string{}, "Download the illust of this keyword search result")
+	downloadCmd.Flags().StringSlice("dl-ranking-modes", []string{}, "Download the illust of the rankings of this mode, choices: ['daily', 'weekly', 'monthly', 'rookie', 'original', 'r18']")
+	downloadCmd.Flags().StringSlice("dl-series-ids", []string{}, "Download all episodes of the manga series of this id")
+
+	downloadRankingCmd.Flags().StringSlice("mode", []string{app.RankingModeDaily}, "The ranking mode, choices: ['daily', 'weekly', 'monthly', 'rookie', 'original', 'r18']")
+	downloadRankingCmd.Flags().String("date", "", "The date of the rankings in 'yyyy-mm-dd', the same as '--ranking-date'")
 
 	downloadCmd.PersistentFlags().StringSlice("user-white-list", []string{}, "Only download illust which user id in this list")
 	downloadCmd.PersistentFlags().StringSlice("user-block-list", []string{}, "Not download illust which user id in this list")
 
+	downloadCmd.PersistentFlags().StringSlice("tag-white-list", []string{}, "Only download illust which tags match this list")
+	downloadCmd.PersistentFlags().StringSlice("tag-block-list", []string{}, "Not download illust which tags match this list")
+	downloadCmd.PersistentFlags().String("tag-white-list-mode", "any", "How the tag white list matches, 'any': the illust has any tag in the list, 'all': the illust has all the tags in the list")
+	downloadCmd.PersistentFlags().String("tag-block-list-mode", "any", "How the tag block list matches, 'any': the illust has any tag in the list, 'all': the illust has all the tags in the list")
+	downloadCmd.PersistentFlags().String("tag-match", "ignore-case", "How to compare the tags, choices: ['exact', 'ignore-case', 'translated'], 'translated' also compare the translated tags (ignore case)")
+
+	downloadCmd.PersistentFlags().String("filter", "", "Only download the illust matches this expression, e.g. 'bookmarks > 500 && !r18 && \"landscape\" in tags && width >= height'")
 	downloadCmd.PersistentFlags().Bool("no-r18", false, "Not download R18 illust")
 	downloadCmd.PersistentFlags().Bool("only-p0", false, "Only download the first picture of the illust if it's a multi picture illust")
 	downloadCmd.PersistentFlags().Int("bookmark-gt", -1, "Only download the illust bookmarks count great then it")
@@ -164,6 +358,13 @@ func init() {
 	downloadCmd.AddCommand(downloadIllustCmd)
 	downloadCmd.AddCommand(downloadArtistCmd)
 	downloadCmd.AddCommand(downloadBookmarkCmd)
+	downloadCmd.AddCommand(downloadFollowingCmd)
+	downloadCmd.AddCommand(downloadNovelCmd)
+	downloadCmd.AddCommand(downloadNovelArtistCmd)
+	downloadCmd.AddCommand(downloadNovelBookmarkCmd)
+	downloadCmd.AddCommand(downloadSearchCmd)
+	downloadCmd.AddCommand(downloadRankingCmd)
+	downloadCmd.AddCommand(downloadSeriesCmd)
 }
 
 func standardizeIds(ids []string) []string {
@@ -182,8 +383,52 @@ func standardizeOptions(options *app.PixivDlOptions) {
 	options.DownloadFollowingUserIds = standardizeIds(options.DownloadFollowingUserIds)
 	options.DownloadArtistUserIds = standardizeIds(options.DownloadArtistUserIds)
 	options.DownloadBookmarksUserIds = standardizeIds(options.DownloadBookmarksUserIds)
+	options.DownloadNovelIds = standardizeIds(options.DownloadNovelIds)
+	options.DownloadNovelArtistUserIds = standardizeIds(options.DownloadNovelArtistUserIds)
+	options.DownloadNovelBookmarksUserIds = standardizeIds(options.DownloadNovelBookmarksUserIds)
+	options.DownloadSearchKeywords = standardizeIds(options.DownloadSearchKeywords)
+	options.DownloadRankingModes = standardizeModes(options.DownloadRankingModes)
+	options.DownloadSeriesIds = standardizeIds(options.DownloadSeriesIds)
 	options.UserBlockList = standardizeIds(options.UserBlockList)
 	options.UserWhiteList = standardizeIds(options.UserWhiteList)
+	options.TagWhiteList = standardizeIds(options.TagWhiteList)
+	options.TagBlockList = standardizeIds(options.TagBlockList)
+	options.TagWhiteListMode = strings.ToLower(strings.TrimSpace(options.TagWhiteListMode))
+	options.TagBlockListMode = strings.ToLower(strings.TrimSpace(options.TagBlockListMode))
+	options.TagMatch = strings.ToLower(strings.TrimSpace(options.TagMatch))
+	options.UgoiraFormat = strings.ToLower(strings.TrimSpace(options.UgoiraFormat))
+	options.NovelFormat = strings.ToLower(strings.TrimSpace(options.NovelFormat))
+	options.FilenameCollision = strings.ToLower(strings.TrimSpace(options.FilenameCollision))
+	options.SidecarFormats = standardizeModes(options.SidecarFormats)
+	options.SearchOrder = strings.ToLower(strings.TrimSpace(options.SearchOrder))
+	options.SearchMode = strings.ToLower(strings.TrimSpace(options.SearchMode))
+	options.SearchType = strings.ToLower(strings.TrimSpace(options.SearchType))
+	options.SearchMatch = strings.ToLower(strings.TrimSpace(options.SearchMatch))
+	options.SearchStartDate = strings.TrimSpace(options.SearchStartDate)
+	options.SearchEndDate = strings.TrimSpace(options.SearchEndDate)
+	options.RankingDate = strings.TrimSpace(options.RankingDate)
+	options.BookmarksVisibility = strings.ToLower(strings.TrimSpace(options.BookmarksVisibility))
+	options.BookmarkTags = standardizeIds(options.BookmarkTags)
+}
+
+func standardizeModes(modes []string) []string {
+	sModes := standardizeIds(modes)
+	for i := range sModes {
+		sModes[i] = strings.ToLower(sModes[i])
+	}
+	return sModes
+}
+
+// validateRankingOptions is separated from getOptions because the ranking command overrides the options by its flags
+func validateRankingOptions(options *app.PixivDlOptions) {
+	for _, mode := range options.DownloadRankingModes {
+		if !app.IsValidRankingMode(mode) {
+			log.Fatalf("Not supported ranking mode '%s'", mode)
+		}
+	}
+	if !app.IsValidDate(options.RankingDate) {
+		log.Fatalf("Invalid ranking date '%s', must be 'yyyy-mm-dd'", options.RankingDate)
+	}
 }
 
 func getOptions() *app.PixivDlOptions {
@@ -193,35 +438,119 @@ func getOptions() *app.PixivDlOptions {
 		log.Fatalf("Failed to read config file, msg: %s", err)
 	}
 	standardizeOptions(&options)
+	if !app.IsValidUgoiraFormat(options.UgoiraFormat) {
+		log.Fatalf("Not supported ugoira format '%s'", options.UgoiraFormat)
+	}
+	if !app.IsValidNovelFormat(options.NovelFormat) {
+		log.Fatalf("Not supported novel format '%s'", options.NovelFormat)
+	}
+	if !app.IsValidTagListMode(options.TagWhiteListMode) || !app.IsValidTagListMode(options.TagBlockListMode) {
+		log.Fatalf("Not supported tag list mode '%s', '%s'", options.TagWhiteListMode, options.TagBlockListMode)
+	}
+	if !app.IsValidTagMatch(options.TagMatch) {
+		log.Fatalf("Not supported tag match '%s'", options.TagMatch)
+	}
+	if !app.IsValidFilenameCollision(options.FilenameCollision) {
+		log.Fatalf("Not supported filename collision '%s'", options.FilenameCollision)
+	}
+	if options.PhashMaxDistance < 0 {
+		log.Fatalf("Invalid phash max distance %d", options.PhashMaxDistance)
+	}
+	for _, format := range options.SidecarFormats {
+		if !app.IsValidSidecarFormat(format) {
+			log.Fatalf("Not supported sidecar format '%s'", format)
+		}
+	}
+	if !app.IsValidSearchOrder(options.SearchOrder) {
+		log.Fatalf("Not supported search order '%s'", options.SearchOrder)
+	}
+	if !app.IsValidSearchMode(options.SearchMode) {
+		log.Fatalf("Not supported search mode '%s'", options.SearchMode)
+	}
+	if !app.IsValidSearchType(options.SearchType) {
+		log.Fatalf("Not supported search type '%s'", options.SearchType)
+	}
+	if !app.IsValidSearchMatch(options.SearchMatch) {
+		log.Fatalf("Not supported search match '%s'", options.SearchMatch)
+	}
+	if !app.IsValidDate(options.SearchStartDate) || !app.IsValidDate(options.SearchEndDate) {
+		log.Fatalf("Invalid search date '%s', '%s', must be 'yyyy-mm-dd'", options.SearchStartDate, options.SearchEndDate)
+	}
+	validateRankingOptions(&options)
+	if !app.IsValidBookmarksVisibility(options.BookmarksVisibility) {
+		log.Fatalf("Not supported bookmarks visibility '%s'", options.BookmarksVisibility)
+	}
+	if _, err := app.NewIllustFilter(options.Filter); err != nil {
+		log.Fatalf("Failed to parse filter, msg: %s", err)
+	}
+	if _, err := app.NewFilenameTemplate(options.FilenamePattern, options.FilenameMaxLength); err != nil {
+		log.Fatalf("Failed to parse filename pattern, msg: %s", err)
+	}
+	if _, err := app.NewNovelFilenameTemplate(options.NovelFilenamePattern, options.FilenameMaxLength); err != nil {
+		log.Fatalf("Failed to parse novel filename pattern, msg: %s", err)
+	}
 	return &options
 }
 
-func downloadIllusts(options *app.PixivDlOptions, illustMgr app.IllustInfoManager) {
-	downloader := app.NewIllustDownloader(options, illustMgr)
-	if options.ServiceMode {
-		go downloader.Start()
+// runDownloaders run all the downloaders until finished (or forever in service mode), the first SIGINT/SIGTERM
+// stop them gracefully: wait for the in-flight downloads finishing and close the database, the second one exit
+// immediately.
+func runDownloaders(options *app.PixivDlOptions, illustMgr app.IllustInfoManager, downloaders ...app.PixivDownloader) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// restore the default behavior, so that the second signal will kill the process
+		stop()
+		log.Infof("Shutting down, cancelling the in-flight requests, press Ctrl+C again to force exit")
+	}()
+
+	// the workers silently get nothing with an expired cookie
+	if options.ServiceMode && options.AuthCheckIntervalSec > 0 {
+		watchdog := app.NewAuthWatchdog(options)
+		watchdog.Check(ctx)
+		go watchdog.Run(ctx)
 	} else {
-		downloader.Start()
-		downloader.Close()
+		app.LogAccountStatuses(app.CheckAccounts(ctx, options))
 	}
-}
 
-func downloadArtists(options *app.PixivDlOptions, illustMgr app.IllustInfoManager) {
-	downloader := app.NewArtistDownloader(options, illustMgr)
-	if options.ServiceMode {
-		go downloader.Start()
-	} else {
-		downloader.Start()
-		downloader.Close()
+	var server *app.ControlServer
+	if options.ServiceMode && len(options.ListenAddr) > 0 {
+		server = app.NewControlServer(options.ListenAddr, downloaders...)
+		server.Start()
 	}
-}
 
-func downloadBookmarks(options *app.PixivDlOptions, illustMgr app.IllustInfoManager) {
-	downloader := app.NewBookmarksDownloader(options, illustMgr)
 	if options.ServiceMode {
-		go downloader.Start()
+		var wg sync.WaitGroup
+		for _, downloader := range downloaders {
+			wg.Add(1)
+			go func(downloader app.PixivDownloader) {
+				defer wg.Done()
+				downloader.Start(ctx)
+			}(downloader)
+		}
+		wg.Wait()
 	} else {
-		downloader.Start()
+		for _, downloader := range downloaders {
+			if ctx.Err() != nil {
+				break
+			}
+			downloader.Start(ctx)
+		}
+	}
+
+	// stop accepting jobs before closing the downloaders
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := server.Close(shutdownCtx); err != nil {
+			log.Warningf("Failed to close control server, msg: %s", err)
+		}
+		cancel()
+	}
+	for _, downloader := range downloaders {
 		downloader.Close()
 	}
+	if err := illustMgr.Close(); err != nil {
+		log.Errorf("Failed to close illust info manager, msg: %s", err)
+	}
+	log.Infof("All downloaders stopped")
 }
@@ -0,0 +1,65 @@
+/*
+Copyright © 2023 litao.little@gmail.com
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"pixiv/app"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	dupesMaxDistance    = -1
+	dupesComputeMissing = false
+)
+
+// dupesCmd represents the dupes command
+var dupesCmd = &cobra.Command{
+	Use:   "dupes",
+	Short: "List the near duplicate illust in database",
+	Long: `List the clusters of the downloaded illust pages whose perceptual hashes (DHash) are within
+the max distance, e.g. the same image re-uploaded as another illust after resized or recompressed.
+The clusters only have the pages of the same illust are not listed. The hash is computed when the
+illust is downloaded, use '--compute-missing' to compute the hashes of the illust downloaded before.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app.InitLog(viper.GetString("log-path"), viper.GetString("log-level"))
+
+		options := getOptions()
+		maxDistance := options.PhashMaxDistance
+		if dupesMaxDistance >= 0 {
+			maxDistance = dupesMaxDistance
+		}
+		illustMgr, err := app.GetIllustInfoManager(options)
+		cobra.CheckErr(err)
+		defer func() {
+			_ = illustMgr.Close()
+		}()
+
+		clusters, err := app.FindNearDuplicates(illustMgr, &app.DupesOptions{
+			DownloadPath:   options.DownloadPath,
+			MaxDistance:    maxDistance,
+			ComputeMissing: dupesComputeMissing,
+		})
+		cobra.CheckErr(err)
+		pages := 0
+		for idx, cluster := range clusters {
+			fmt.Printf("CLUSTER %d\n", idx+1)
+			for _, dup := range cluster {
+				fmt.Printf("\tID: %s, PAGE: %d, USER: %s, FILE: %s, HASH: %s, DISTANCE: %d\n",
+					dup.Pid, dup.Page, dup.UserId, dup.Filename, dup.Phash, dup.Distance)
+			}
+			pages += len(cluster)
+		}
+		fmt.Printf("Total: %d clusters, %d pages\n", len(clusters), pages)
+	},
+}
+
+func init() {
+	dupesCmd.Flags().IntVar(&dupesMaxDistance, "max-distance", -1, "Max different bits of the hashes to be near duplicates, default is 'phash-max-distance'")
+	dupesCmd.Flags().BoolVar(&dupesComputeMissing, "compute-missing", false, "Compute and save the hashes of the downloaded illust pages without hash")
+}
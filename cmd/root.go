@@ -65,6 +65,15 @@ func init() {
 	rootCmd.PersistentFlags().String("cookie", "", "Your Cookies, only need the key-value 'PHPSESSID=abcxyz'")
 	rootCmd.PersistentFlags().String("user-agent", defaultUserAgent, "Http User-Agent header")
 	rootCmd.PersistentFlags().String("proxy", "", "HTTP/HTTPS/Socks proxy")
+	rootCmd.PersistentFlags().Int32("account-bench-sec", 600, "How long an account is not used after pixiv responds 401/403/429 to it, if multi accounts are set in config file")
+
+	rootCmd.PersistentFlags().String("database-type", "SQLITE", "Database to store the illust info, 'NONE' means not use database and not check illust exist, choices: ['NONE', 'SQLITE', 'MYSQL']")
+	rootCmd.PersistentFlags().String("sqlite-path", "storage", "Sqlite file location if use sqlite database")
+	rootCmd.PersistentFlags().String("mysql-dsn", "", "Mysql DSN if use mysql database, e.g. 'user:password@tcp(127.0.0.1:3306)/pixiv'")
+	rootCmd.PersistentFlags().Int("mysql-max-open-conns", 20, "Max open connections to mysql")
+	rootCmd.PersistentFlags().Int("mysql-max-idle-conns", 5, "Max idle connections to mysql")
+	rootCmd.PersistentFlags().Int32("mysql-conn-max-lifetime-sec", 3600, "Max lifetime of a mysql connection")
+	rootCmd.PersistentFlags().String("download-path", "pixiv", "Download file location")
 
 	err := viper.BindPFlags(rootCmd.PersistentFlags())
 	cobra.CheckErr(err)
@@ -76,6 +85,10 @@ func init() {
 
 	rootCmd.AddCommand(downloadCmd)
 	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(authCmd)
+	rootCmd.AddCommand(dbCmd)
+	rootCmd.AddCommand(dupesCmd)
 }
 
 // initConfig reads in config file and ENV variables if set.